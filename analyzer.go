@@ -0,0 +1,433 @@
+/*
+* Statistical test (analyser) interface and registry module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// Analyzer is a statistical test computed over the stream of blocks of an image
+type Analyzer interface {
+	// Name is the key of the test in the profile and in the statistics of a result
+	Name() string
+	// Title is the label of the test in the GUI and the reports
+	Title() string
+	// Update receives every block of the file in order, the block must not be retained after the call
+	Update(block []byte)
+	// Finalize is called once at the end of the file with the counts of the whole stream and returns
+	// the statistic of the test, NaN when it could not be computed and the test is to be left out
+	Finalize(counts *StreamCounts) float64
+	// Direction is +1 if larger statistics indicate encryption and -1 otherwise
+	Direction() float64
+	// Vote reports whether the statistic indicates encryption at the given threshold
+	Vote(statistic float64, threshold float64) bool
+}
+
+// DetailedAnalyzer is an Analyzer that reports the intermediate values behind its statistic
+type DetailedAnalyzer interface {
+	Analyzer
+	// Details is called after Finalize
+	Details() string
+}
+
+// CountsAnalyzer is an Analyzer computed from the counts of the stream alone, it receives no blocks
+type CountsAnalyzer interface {
+	Analyzer
+	// NeedsBigrams reports whether the statistic needs the pairs of adjacent bytes
+	NeedsBigrams() bool
+}
+
+// StreamCounts are the counts of the whole stream, the pipeline builds them once for all the analysers
+type StreamCounts struct {
+	Histogram ByteHistogram
+	BytesRead int
+	// Pairs of adjacent bytes, nil unless one of the analysers needs them
+	Bigrams   *BigramHistogram
+	PairsRead int
+}
+
+// histogramInput and bigramInput implement the block part of the CountsAnalyzer interface
+type histogramInput struct{}
+
+func (histogramInput) Update(block []byte) {}
+
+func (histogramInput) NeedsBigrams() bool {
+	return false
+}
+
+type bigramInput struct{}
+
+func (bigramInput) Update(block []byte) {}
+
+func (bigramInput) NeedsBigrams() bool {
+	return true
+}
+
+// streamCounters are the sinks behind the StreamCounts of a run
+type streamCounters struct {
+	histogram *HistogramSink
+	bigrams   *BigramSink
+}
+
+// newStreamCounters counts the bytes of the stream, and the pairs of bytes when one of the analysers needs them
+func newStreamCounters(analyzers []Analyzer) streamCounters {
+	counters := streamCounters{histogram: NewHistogramSink()}
+	for _, analyzer := range analyzers {
+		if counts, ok := analyzer.(CountsAnalyzer); ok && counts.NeedsBigrams() {
+			counters.bigrams = NewBigramSink()
+			break
+		}
+	}
+	return counters
+}
+
+// sinks returns the sinks to stream with their checkpoint layout names
+func (c streamCounters) sinks() ([]BlockSink, []string) {
+	if c.bigrams == nil {
+		return []BlockSink{c.histogram}, []string{"byte_histogram"}
+	}
+	return []BlockSink{c.histogram, c.bigrams}, []string{"byte_histogram", "byte_pairs"}
+}
+
+func (c streamCounters) Counts() *StreamCounts {
+	counts := &StreamCounts{Histogram: c.histogram.Counter, BytesRead: c.histogram.BytesRead}
+	if c.bigrams != nil {
+		counts.Bigrams, counts.PairsRead = c.bigrams.Pairs, c.bigrams.PairsRead
+	}
+	return counts
+}
+
+// analyzerInfo implements the descriptive part of the Analyzer interface
+type analyzerInfo struct {
+	name      string
+	title     string
+	direction float64
+}
+
+func (a analyzerInfo) Name() string {
+	return a.name
+}
+
+func (a analyzerInfo) Title() string {
+	return a.title
+}
+
+func (a analyzerInfo) Direction() float64 {
+	return a.direction
+}
+
+func (a analyzerInfo) Vote(statistic float64, threshold float64) bool {
+	return testVote(a.direction, statistic, threshold)
+}
+
+// AnalyzerRun is what an analyser gets to know about the image it is created for
+type AnalyzerRun struct {
+	// Optimized image the blocks are read from
+	FileName string
+	Options  AnalysisOptions
+	// Receives the errors of the analysers, the standard logger when nil
+	ErrorLogger *log.Logger
+}
+
+func (r AnalyzerRun) errorf(format string, args ...any) {
+	if r.ErrorLogger != nil {
+		r.ErrorLogger.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+type AnalyzerRegistration struct {
+	analyzerInfo
+	// Stage 1 analysers run on every image without a detected encryption tool,
+	// the others only reach images without a file system
+	Stage1 bool
+	// Sampled analysers also run in the sampling mode, the statistics of those that process the blocks
+	// independently (ProcessBlock and Merge) or only use the byte histogram get bootstrap intervals
+	Sampled bool
+	// The statistic is a p-value, its evidence is measured in decades of it
+	PValue             bool
+	DefaultCalibration TestCalibration
+	New                func(info analyzerInfo, run AnalyzerRun) Analyzer
+}
+
+// analyzerRegistry lists the tests of the method in the order of the GUI rows and the reports,
+// a new test only needs an Analyzer implementation and an entry here
+var analyzerRegistry = []AnalyzerRegistration{
+	{
+		analyzerInfo:       analyzerInfo{AutocorrelationTestName, "Автокореляційний тест", -1},
+		Sampled:            true,
+		Stage1:             true,
+		DefaultCalibration: TestCalibration{Threshold: 0.125, Scale: 0.05, Weight: 1.0, WeightStdErr: 0.5},
+		New:                newAutocorrelationAnalyzer,
+	},
+	{
+		analyzerInfo:       analyzerInfo{KsTestName, "Критерій узгодженості Колмогорова (асимптотичне p-значення)", +1},
+		Sampled:            true,
+		PValue:             true,
+		DefaultCalibration: TestCalibration{Threshold: 0.01, Scale: 0.5, Weight: 1.0, WeightStdErr: 0.5},
+		New:                newKsAnalyzer,
+	},
+	{
+		analyzerInfo:       analyzerInfo{ChiSqTestName, "Критерій узгодженості Пірсона (p-значення)", +1},
+		Sampled:            true,
+		PValue:             true,
+		DefaultCalibration: TestCalibration{Threshold: 0.01, Scale: 0.5, Weight: 1.0, WeightStdErr: 0.5},
+		New:                newChiSqAnalyzer,
+	},
+	{
+		analyzerInfo: analyzerInfo{CompressionTestName, "Тест оцінки коефіцієнту стиснення", -1},
+		Sampled:      true,
+		// The 1.1 of the thesis was the mean over five tools, bzip2 expands ciphertext by about 1.5%,
+		// so the mean of the default algorithms is 0.003 higher on it
+		DefaultCalibration: TestCalibration{Threshold: 1.103, Scale: 0.05, Weight: 1.0, WeightStdErr: 0.5},
+		New:                newCompressionAnalyzer,
+	},
+	{
+		analyzerInfo:       analyzerInfo{SignatureTestName, "Тест пошуку сигнатур файлів", -1},
+		Sampled:            true,
+		DefaultCalibration: TestCalibration{Threshold: 150.0, Scale: 50.0, Weight: 1.0, WeightStdErr: 0.5},
+		New:                newSignatureAnalyzer,
+	},
+	{
+		analyzerInfo:       analyzerInfo{EntropyTestName, "Тест оцінки інформаційної ентропії", +1},
+		Sampled:            true,
+		DefaultCalibration: TestCalibration{Threshold: 7.95, Scale: 0.02, Weight: 1.0, WeightStdErr: 0.5},
+		New:                newEntropyAnalyzer,
+	},
+	// The byte pair tests take no part in the classification until a calibration fits their weights,
+	// their 512 KiB histograms per block are also too large to be kept for the bootstrap of a sample
+	{
+		analyzerInfo:       analyzerInfo{BigramTestName, "Послідовний критерій для пар байтів (p-значення)", +1},
+		PValue:             true,
+		DefaultCalibration: TestCalibration{Threshold: 0.01, Scale: 0.5},
+		New:                newBigramAnalyzer,
+	},
+	{
+		analyzerInfo:       analyzerInfo{MutualInformationTestName, "Взаємна інформація сусідніх байтів, біт", -1},
+		DefaultCalibration: TestCalibration{Threshold: 0.001, Scale: 0.001},
+		New:                newMutualInformationAnalyzer,
+	},
+	// Entropy estimators of NIST SP 800-90B, in bits per byte, also left to the calibration
+	{
+		analyzerInfo:       analyzerInfo{McvEntropyTestName, "Мінімальна ентропія за найчастішим значенням", +1},
+		Sampled:            true,
+		DefaultCalibration: TestCalibration{Threshold: 7.9, Scale: 0.05},
+		New:                newMcvAnalyzer,
+	},
+	{
+		analyzerInfo:       analyzerInfo{CollisionEntropyTestName, "Мінімальна ентропія за колізіями", +1},
+		Sampled:            true,
+		DefaultCalibration: TestCalibration{Threshold: 7.5, Scale: 0.2},
+		New:                newCollisionAnalyzer,
+	},
+	{
+		analyzerInfo:       analyzerInfo{MarkovEntropyTestName, "Мінімальна ентропія за марковською моделлю", +1},
+		Sampled:            true,
+		DefaultCalibration: TestCalibration{Threshold: 7.9, Scale: 0.05},
+		New:                newMarkovAnalyzer,
+	},
+	{
+		analyzerInfo:       analyzerInfo{CompressionEntropyTestName, "Мінімальна ентропія за стисненням (Маурер)", +1},
+		Sampled:            true,
+		DefaultCalibration: TestCalibration{Threshold: 7.0, Scale: 0.3},
+		New:                newMaurerAnalyzer,
+	},
+	{
+		analyzerInfo:       analyzerInfo{RenyiEntropyTestName, "Ентропія Реньї другого порядку", +1},
+		Sampled:            true,
+		DefaultCalibration: TestCalibration{Threshold: 7.999, Scale: 0.001},
+		New:                newRenyiAnalyzer,
+	},
+	// Alternatives to the Kolmogorov-Smirnov test, their thresholds are significance levels as well
+	{
+		analyzerInfo:       analyzerInfo{AndersonDarlingTestName, "Критерій Андерсона-Дарлінга (p-значення)", +1},
+		Sampled:            true,
+		PValue:             true,
+		DefaultCalibration: TestCalibration{Threshold: 0.01, Scale: 0.5},
+		New:                newAndersonDarlingAnalyzer,
+	},
+	{
+		analyzerInfo:       analyzerInfo{CramerVonMisesTestName, "Критерій Крамера-фон Мізеса (p-значення)", +1},
+		Sampled:            true,
+		PValue:             true,
+		DefaultCalibration: TestCalibration{Threshold: 0.01, Scale: 0.5},
+		New:                newCramerVonMisesAnalyzer,
+	},
+}
+
+// registeredPValues lists the tests whose statistics are p-values
+func registeredPValues() map[string]bool {
+	pValues := make(map[string]bool)
+	for _, registration := range analyzerRegistry {
+		pValues[registration.name] = registration.PValue
+	}
+	return pValues
+}
+
+// registeredDirections maps the test names to their directions
+func registeredDirections() map[string]float64 {
+	directions := make(map[string]float64)
+	for _, registration := range analyzerRegistry {
+		directions[registration.name] = registration.direction
+	}
+	return directions
+}
+
+// newAnalyzers creates the analysers of one run, only the Stage 1 ones unless withStage2 is set
+func newAnalyzers(run AnalyzerRun, withStage2 bool) []Analyzer {
+	var analyzers []Analyzer
+	for _, registration := range analyzerRegistry {
+		if registration.Stage1 || withStage2 {
+			analyzers = append(analyzers, registration.New(registration.analyzerInfo, run))
+		}
+	}
+	return analyzers
+}
+
+// analyzerSink feeds an Analyzer from StreamFile and keeps its statistic
+type analyzerSink struct {
+	analyzer  Analyzer
+	statistic float64
+}
+
+func (s *analyzerSink) Update(block []byte) {
+	s.analyzer.Update(block)
+}
+
+// Finalize leaves the analyser to finalizeAnalyzers, which has the counts of the whole stream
+func (s *analyzerSink) Finalize() {
+}
+
+// finalizeAnalyzers computes the statistics of the analysers once the stream has been read
+func finalizeAnalyzers(analyzerSinks []*analyzerSink, counts *StreamCounts) {
+	for _, sink := range analyzerSinks {
+		sink.statistic = sink.analyzer.Finalize(counts)
+	}
+}
+
+func (s *analyzerSink) SaveState() ([]byte, error) {
+	saver, ok := s.analyzer.(StateSaver)
+	if !ok {
+		return nil, fmt.Errorf("аналізатор %s не підтримує контрольні точки", s.analyzer.Name())
+	}
+	return saver.SaveState()
+}
+
+func (s *analyzerSink) RestoreState(state []byte) error {
+	saver, ok := s.analyzer.(StateSaver)
+	if !ok {
+		return fmt.Errorf("аналізатор %s не підтримує контрольні точки", s.analyzer.Name())
+	}
+	return saver.RestoreState(state)
+}
+
+type blockProcessor interface {
+	ProcessBlock(block []byte) any
+	Merge(partial any)
+}
+
+// parallelAnalyzerSink feeds an Analyzer whose block work can run on the worker pool
+type parallelAnalyzerSink struct {
+	*analyzerSink
+	processor blockProcessor
+}
+
+func (s *parallelAnalyzerSink) ProcessBlock(block []byte) any {
+	return s.processor.ProcessBlock(block)
+}
+
+func (s *parallelAnalyzerSink) Merge(partial any) {
+	s.processor.Merge(partial)
+}
+
+// newAnalyzerSink wraps the analyser into a BlockSink, keeping it parallel when it can be,
+// a CountsAnalyzer has no BlockSink
+func newAnalyzerSink(analyzer Analyzer) (BlockSink, *analyzerSink) {
+	sink := &analyzerSink{analyzer: analyzer}
+	if _, ok := analyzer.(CountsAnalyzer); ok {
+		return nil, sink
+	}
+	if processor, ok := analyzer.(blockProcessor); ok {
+		return &parallelAnalyzerSink{analyzerSink: sink, processor: processor}, sink
+	}
+	return sink, sink
+}
+
+// TestReport is one row of the results of an image
+type TestReport struct {
+	Name      string
+	Title     string
+	Statistic float64
+	Threshold float64
+	Vote      bool
+	Details   string
+	// Set for the statistics of a sample
+	Interval *ConfidenceInterval
+}
+
+func (r TestReport) Value() string {
+	return strconv.FormatFloat(r.Statistic, 'f', -1, 64)
+}
+
+func (r TestReport) String() string {
+	vote := "шифрування не виявлено"
+	if r.Vote {
+		vote = "ознака шифрування"
+	}
+	text := fmt.Sprintf("%s: %f, реф. значення %f, %s.", r.Title, r.Statistic, r.Threshold, vote)
+	if r.Interval != nil {
+		text += fmt.Sprintf(" За вибіркою, %s.", r.Interval)
+	}
+	if r.Details != "" {
+		text += " " + r.Details
+	}
+	return text
+}
+
+// TestReports returns the rows of the tests that were run on the image, in registry order
+func TestReports(result AnalysisResult, profile Profile) []TestReport {
+	profile = profile.WithOptions(result.Options)
+	var reports []TestReport
+	for _, registration := range analyzerRegistry {
+		statistic, ok := result.Statistics[registration.name]
+		if !ok {
+			continue
+		}
+		threshold := profile.Tests[registration.name].Threshold
+		report := TestReport{
+			Name:      registration.name,
+			Title:     registration.title,
+			Statistic: statistic,
+			Threshold: threshold,
+			Vote:      registration.Vote(statistic, threshold),
+			Details:   result.TestDetails[registration.name],
+		}
+		// An escalated result keeps the sample for the record, its statistics are the full ones
+		if result.Sampling != nil && result.Sampling.Borderline == "" {
+			if interval, ok := result.Sampling.Intervals[registration.name]; ok {
+				report.Interval = &interval
+			}
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
@@ -19,71 +19,422 @@
 package main
 
 import (
+	"cmp"
+	"encoding/json"
 	"fmt"
 	"log"
 	"math"
-	"os"
+	"math/bits"
+	"math/cmplx"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/montanaflynn/stats"
 )
 
-func AutoCorrelation(filename string, blockSize int) float64 {
-	file, err := os.Open(filename)
-	if err != nil {
-		log.Fatal(err)
+const (
+	// Length of the Welch segments of the power spectrum, periods up to it can be told apart
+	spectrumSegment = 4096
+	// A spectrum bin this many times above the mean power is reported as a periodic structure
+	spectrumPeakFactor = 5.0
+	spectrumMaxPeaks   = 3
+)
+
+// Lags 1-49 are the ones of the original test, its threshold was tuned on their mean
+var defaultAutocorrelationLags = lagRange(1, 49)
+
+// Sector lags expose sector-sized structure (XTS, ECB), they are reported apart and take no part in the test
+var defaultSectorLags = []int{512, 4096}
+
+func lagRange(first int, last int) []int {
+	var lags []int
+	for lag := first; lag <= last; lag++ {
+		lags = append(lags, lag)
+	}
+	return lags
+}
+
+type AutocorrelationOptions struct {
+	// Lags averaged into the statistic of the test
+	Lags []int
+	// Lags reported as separate statistics
+	SectorLags []int
+}
+
+func DefaultAutocorrelationOptions() AutocorrelationOptions {
+	return AutocorrelationOptions{Lags: slices.Clone(defaultAutocorrelationLags), SectorLags: slices.Clone(defaultSectorLags)}
+}
+
+func (o AutocorrelationOptions) String() string {
+	var lags, sectorLags []string
+	for _, lag := range o.Lags {
+		lags = append(lags, strconv.Itoa(lag))
+	}
+	for _, lag := range o.SectorLags {
+		sectorLags = append(sectorLags, strconv.Itoa(lag))
 	}
-	defer func(file *os.File) {
-		if err := file.Close(); err != nil {
-			log.Fatal(err)
+	return strings.Join(lags, ",") + "/" + strings.Join(sectorLags, ",")
+}
+
+// AutocorrelationLagStatisticName is the key of the statistic of a sector lag in the results,
+// it has no calibration in the profiles and does not vote
+func AutocorrelationLagStatisticName(lag int) string {
+	return fmt.Sprintf("%s_lag_%d", AutocorrelationTestName, lag)
+}
+
+// ParseLags reads a lag set such as "1-49,512,4096"
+func ParseLags(list string) ([]int, error) {
+	var lags []int
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		low, err := strconv.Atoi(first)
+		high := low
+		if err == nil && isRange {
+			high, err = strconv.Atoi(last)
 		}
-	}(file)
+		if err != nil || low < 1 || high < low {
+			return nil, fmt.Errorf("неправильний лаг або діапазон лагів %q", part)
+		}
+		lags = append(lags, lagRange(low, high)...)
+	}
+	if len(lags) == 0 {
+		return nil, fmt.Errorf("не вказано жодного лагу")
+	}
+	slices.Sort(lags)
+	return slices.Compact(lags), nil
+}
 
-	buffer := make([]byte, blockSize)
-	var totalAutocorr []float64
+// fft transforms the data in place, its length must be a power of two
+func fft(data []complex128, inverse bool) {
+	n := len(data)
+	shift := 64 - bits.TrailingZeros(uint(n))
+	for idx := range data {
+		if swapped := int(bits.Reverse64(uint64(idx)) >> shift); idx < swapped {
+			data[idx], data[swapped] = data[swapped], data[idx]
+		}
+	}
 
-	var readBytesCount int
-	for {
-		bytesRead, err := file.Read(buffer)
-		if bytesRead == 0 || err != nil {
-			break
+	sign := -1.0
+	if inverse {
+		sign = 1.0
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			twiddle := complex(1, 0)
+			for idx := start; idx < start+size/2; idx++ {
+				odd := twiddle * data[idx+size/2]
+				data[idx+size/2] = data[idx] - odd
+				data[idx] += odd
+				twiddle *= step
+			}
+		}
+	}
+	if inverse {
+		for idx := range data {
+			data[idx] /= complex(float64(n), 0)
 		}
-		var results []float64
-		readBytesCount += bytesRead
-		fmt.Printf("%.1f \r", float32(readBytesCount)/1048576)
+	}
+}
+
+func nextPowerOfTwo(value int) int {
+	return 1 << bits.Len(uint(value-1))
+}
 
-		if len(buffer) > bytesRead {
-			break
+// laggedProducts returns sum(x[i]*x[i+lag]) for every lag up to maxLag. The sums are computed
+// by FFT over overlapping segments, so the cost grows with the block length and not with the lags.
+func laggedProducts(centered []float64, maxLag int) []float64 {
+	size := nextPowerOfTwo(4 * (maxLag + 1))
+	segmentLength := size - maxLag
+	products := make([]float64, maxLag+1)
+	segment := make([]complex128, size)
+	extended := make([]complex128, size)
+
+	for start := 0; start < len(centered); start += segmentLength {
+		clear(segment)
+		clear(extended)
+		for idx, value := range centered[start:min(start+segmentLength, len(centered))] {
+			segment[idx] = complex(value, 0)
+		}
+		for idx, value := range centered[start:min(start+segmentLength+maxLag, len(centered))] {
+			extended[idx] = complex(value, 0)
 		}
 
-		var floatBuffer []float64
+		fft(segment, false)
+		fft(extended, false)
+		for idx := range segment {
+			segment[idx] = cmplx.Conj(segment[idx]) * extended[idx]
+		}
+		fft(segment, true)
+		for lag := range products {
+			products[lag] += real(segment[lag])
+		}
+	}
+	return products
+}
 
-		inputMean := meanBytes(buffer)
+// welchSpectrum averages the periodograms of Hann-windowed segments of the block
+func welchSpectrum(block []byte) []float64 {
+	spectrum := make([]float64, spectrumSegment/2+1)
+	window := make([]float64, spectrumSegment)
+	for idx := range window {
+		window[idx] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(idx)/float64(spectrumSegment))
+	}
 
-		for _, val := range buffer {
-			floatBuffer = append(floatBuffer, float64(val)-inputMean)
+	segment := make([]complex128, spectrumSegment)
+	segments := 0
+	for start := 0; start+spectrumSegment <= len(block); start += spectrumSegment {
+		mean := meanBytes(block[start : start+spectrumSegment])
+		for idx, value := range block[start : start+spectrumSegment] {
+			segment[idx] = complex((float64(value)-mean)*window[idx], 0)
 		}
+		fft(segment, false)
+		for bin := range spectrum {
+			spectrum[bin] += real(segment[bin])*real(segment[bin]) + imag(segment[bin])*imag(segment[bin])
+		}
+		segments++
+	}
+	if segments == 0 {
+		return nil
+	}
+	for bin := range spectrum {
+		spectrum[bin] /= float64(segments)
+	}
+	return spectrum
+}
+
+// SpectrumPeak is a periodic structure of the data, Period is in bytes
+type SpectrumPeak struct {
+	Period float64
+	// Power relative to the mean power of the spectrum
+	Power float64
+}
+
+// SpectrumPeaks returns the strongest bins well above the mean power, the constant component is ignored
+func SpectrumPeaks(spectrum []float64) []SpectrumPeak {
+	if len(spectrum) < 2 {
+		return nil
+	}
+	meanPower := meanFloats(spectrum[1:])
+	if meanPower == 0 {
+		return nil
+	}
 
-		var maxLag int
-		if len(floatBuffer) < 50 {
-			maxLag = len(floatBuffer)
-		} else {
-			maxLag = 50
+	var peaks []SpectrumPeak
+	for bin := 1; bin < len(spectrum); bin++ {
+		if spectrum[bin] >= spectrumPeakFactor*meanPower {
+			peaks = append(peaks, SpectrumPeak{Period: float64(spectrumSegment) / float64(bin), Power: spectrum[bin] / meanPower})
 		}
+	}
+	slices.SortFunc(peaks, func(first SpectrumPeak, second SpectrumPeak) int {
+		return -cmp.Compare(first.Power, second.Power)
+	})
+	return peaks[:min(len(peaks), spectrumMaxPeaks)]
+}
 
-		for lag := 1; lag < maxLag; lag++ {
-			correlation, autocorrErr := stats.Correlation(floatBuffer[lag:], floatBuffer[:len(floatBuffer)-lag])
-			if autocorrErr != nil {
-				log.Println("Autocorrelation calc error: ", autocorrErr)
-			}
-			results = append(results, math.Abs(correlation))
+type autocorrelationBlockResult struct {
+	mean     float64
+	lags     []float64
+	spectrum []float64
+}
+
+// AutocorrelationSink computes the autocorrelation of every full block at the configured lags.
+// The result is the standard deviation of the per-block means of the absolute autocorrelation
+// at the test lags, the per-lag means and the averaged power spectrum describe the structure behind it.
+type AutocorrelationSink struct {
+	BlockSize int
+	// The test lags followed by the sector lags
+	Lags []int
+	// Number of the test lags at the start of Lags
+	TestLags int
+	// Mean absolute autocorrelation of every full block, in stream order
+	BlockMeans []float64
+	// Autocorrelation at every lag, averaged over the blocks
+	LagMeans []float64
+	// Power spectrum averaged over the blocks, bin k is the frequency k/spectrumSegment per byte
+	Spectrum    []float64
+	Result      float64
+	lagSums     []float64
+	spectrumSum []float64
+}
+
+func NewAutocorrelationSink(blockSize int, options AutocorrelationOptions) *AutocorrelationSink {
+	var lags []int
+	var testLags int
+	for _, lag := range options.Lags {
+		if lag > 0 && lag < blockSize {
+			lags = append(lags, lag)
+			testLags++
+		}
+	}
+	for _, lag := range options.SectorLags {
+		if lag > 0 && lag < blockSize && !slices.Contains(lags, lag) {
+			lags = append(lags, lag)
+		}
+	}
+	return &AutocorrelationSink{BlockSize: blockSize, Lags: lags, TestLags: testLags, lagSums: make([]float64, len(lags))}
+}
+
+// ProcessBlock returns the autocorrelation of the block, or nil for a partial block
+func (a *AutocorrelationSink) ProcessBlock(block []byte) any {
+	if len(block) < a.BlockSize || a.TestLags == 0 {
+		return nil
+	}
+
+	inputMean := meanBytes(block)
+	centered := make([]float64, len(block))
+	for idx, val := range block {
+		centered[idx] = float64(val) - inputMean
+	}
+
+	products := laggedProducts(centered, slices.Max(a.Lags))
+	partial := autocorrelationBlockResult{lags: make([]float64, len(a.Lags)), spectrum: welchSpectrum(block)}
+	var absolute []float64
+	for idx, lag := range a.Lags {
+		// A constant block has no defined autocorrelation, it counts as uncorrelated
+		if products[0] > 0 {
+			partial.lags[idx] = products[lag] / products[0]
 		}
+		if idx < a.TestLags {
+			absolute = append(absolute, math.Abs(partial.lags[idx]))
+		}
+	}
+	partial.mean = meanFloats(absolute)
+	return partial
+}
 
-		totalAutocorr = append(totalAutocorr, meanFloats(results))
+func (a *AutocorrelationSink) Merge(partial any) {
+	blockResult, ok := partial.(autocorrelationBlockResult)
+	if !ok {
+		return
 	}
-	std, err := stats.StandardDeviation(totalAutocorr)
+	a.BlockMeans = append(a.BlockMeans, blockResult.mean)
+	for idx, value := range blockResult.lags {
+		a.lagSums[idx] += value
+	}
+	if a.spectrumSum == nil {
+		a.spectrumSum = make([]float64, len(blockResult.spectrum))
+	}
+	for bin, power := range blockResult.spectrum {
+		a.spectrumSum[bin] += power
+	}
+}
+
+func (a *AutocorrelationSink) Update(block []byte) {
+	a.Merge(a.ProcessBlock(block))
+}
+
+func (a *AutocorrelationSink) Finalize() {
+	if blocks := float64(len(a.BlockMeans)); blocks > 0 {
+		a.LagMeans = make([]float64, len(a.lagSums))
+		for idx, sum := range a.lagSums {
+			a.LagMeans[idx] = sum / blocks
+		}
+		a.Spectrum = make([]float64, len(a.spectrumSum))
+		for bin, sum := range a.spectrumSum {
+			a.Spectrum[bin] = sum / blocks
+		}
+	}
+
+	// The spread of a single block says nothing about the structure, the test is left out then
+	if len(a.BlockMeans) < 2 {
+		a.Result = math.NaN()
+		return
+	}
+	std, err := stats.StandardDeviation(a.BlockMeans)
 	if err != nil {
 		log.Println("Standard deviation calc error: ", err)
-		return 0.0
+		a.Result = math.NaN()
+		return
+	}
+	a.Result = std
+}
+
+type autocorrelationState struct {
+	BlockMeans  []float64 `json:"block_means"`
+	LagSums     []float64 `json:"lag_sums"`
+	SpectrumSum []float64 `json:"spectrum_sum"`
+}
+
+func (a *AutocorrelationSink) SaveState() ([]byte, error) {
+	return json.Marshal(autocorrelationState{BlockMeans: a.BlockMeans, LagSums: a.lagSums, SpectrumSum: a.spectrumSum})
+}
+
+func (a *AutocorrelationSink) RestoreState(state []byte) error {
+	var restored autocorrelationState
+	if err := json.Unmarshal(state, &restored); err != nil {
+		return err
+	}
+	if len(restored.LagSums) != len(a.Lags) {
+		return fmt.Errorf("контрольна точка має %d лагів замість %d", len(restored.LagSums), len(a.Lags))
+	}
+	a.BlockMeans, a.lagSums, a.spectrumSum = restored.BlockMeans, restored.LagSums, restored.SpectrumSum
+	return nil
+}
+
+// AutocorrelationProfile is the autocorrelation structure of the image kept in the analysis result
+type AutocorrelationProfile struct {
+	// The test lags followed by the sector lags
+	Lags       []int
+	LagMeans   []float64
+	BlockMeans []float64
+	// Averaged power spectrum, bin k is the frequency k/SpectrumSegment per byte
+	Spectrum        []float64
+	SpectrumSegment int
+}
+
+func (a *AutocorrelationSink) Profile() *AutocorrelationProfile {
+	return &AutocorrelationProfile{
+		Lags:            a.Lags,
+		LagMeans:        a.LagMeans,
+		BlockMeans:      a.BlockMeans,
+		Spectrum:        a.Spectrum,
+		SpectrumSegment: spectrumSegment,
+	}
+}
+
+type autocorrelationAnalyzer struct {
+	analyzerInfo
+	*AutocorrelationSink
+}
+
+func newAutocorrelationAnalyzer(info analyzerInfo, run AnalyzerRun) Analyzer {
+	return &autocorrelationAnalyzer{info, NewAutocorrelationSink(run.Options.BlockSize, run.Options.Autocorrelation)}
+}
+
+func (a *autocorrelationAnalyzer) Finalize(counts *StreamCounts) float64 {
+	a.AutocorrelationSink.Finalize()
+	return a.Result
+}
+
+func (a *autocorrelationAnalyzer) Details() string {
+	text := fmt.Sprintf("Стандартне відхилення середньої автокореляції %d повних блоків.", len(a.BlockMeans))
+	if len(a.LagMeans) > 0 {
+		strongest := 0
+		for idx, value := range a.LagMeans[:a.TestLags] {
+			if math.Abs(value) > math.Abs(a.LagMeans[strongest]) {
+				strongest = idx
+			}
+		}
+		text += fmt.Sprintf(" Найбільша середня автокореляція %f на лагу %d.", a.LagMeans[strongest], a.Lags[strongest])
+		for idx, lag := range a.Lags[a.TestLags:] {
+			text += fmt.Sprintf(" Лаг сектора %d: %f.", lag, a.LagMeans[a.TestLags+idx])
+		}
+	}
+
+	if peaks := SpectrumPeaks(a.Spectrum); len(peaks) > 0 {
+		var readable []string
+		for _, peak := range peaks {
+			readable = append(readable, fmt.Sprintf("%.1f байтів (%.1f×)", peak.Period, peak.Power))
+		}
+		text += " Періодичні структури у спектрі: " + strings.Join(readable, ", ") + "."
+	} else if a.Spectrum != nil {
+		text += " Періодичних структур у спектрі не виявлено."
 	}
-	return std
+	return text
 }
@@ -0,0 +1,99 @@
+/*
+* Autocorrelation test tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestLaggedProducts(t *testing.T) {
+	rng := rand.New(rand.NewPCG(5, 6))
+	tests := []struct {
+		name   string
+		length int
+		maxLag int
+	}{
+		{"single segment", 100, 49},
+		{"many segments", 65536, 49},
+		{"sector lags", 16384, 4096},
+		{"block shorter than the lag", 10, 20},
+	}
+	for _, test := range tests {
+		centered := make([]float64, test.length)
+		for idx := range centered {
+			centered[idx] = rng.Float64() - 0.5
+		}
+		products := laggedProducts(centered, test.maxLag)
+		for lag := 0; lag <= test.maxLag; lag++ {
+			var direct float64
+			for idx := 0; idx+lag < len(centered); idx++ {
+				direct += centered[idx] * centered[idx+lag]
+			}
+			if math.Abs(products[lag]-direct) > 1e-9*float64(test.length) {
+				t.Errorf("%s: lag %d sum %f, direct sum %f", test.name, lag, products[lag], direct)
+				break
+			}
+		}
+	}
+}
+
+func TestAutocorrelationSinkLags(t *testing.T) {
+	sink := NewAutocorrelationSink(8192, AutocorrelationOptions{Lags: lagRange(1, 3), SectorLags: []int{2, 512, 8192}})
+	// The sector lags already tested and the ones beyond the block are dropped
+	want := []int{1, 2, 3, 512}
+	if len(sink.Lags) != len(want) || sink.TestLags != 3 {
+		t.Fatalf("lags %v with %d test lags, want %v with 3", sink.Lags, sink.TestLags, want)
+	}
+	for idx := range want {
+		if sink.Lags[idx] != want[idx] {
+			t.Fatalf("lags %v, want %v", sink.Lags, want)
+		}
+	}
+}
+
+func TestAutocorrelationSinkTooFewBlocks(t *testing.T) {
+	const blockSize = 4096
+	rng := rand.New(rand.NewPCG(7, 8))
+	tests := []struct {
+		blocks  int
+		missing bool
+	}{
+		{0, true},
+		{1, true},
+		{3, false},
+	}
+	for _, test := range tests {
+		sink := NewAutocorrelationSink(blockSize, DefaultAutocorrelationOptions())
+		for range test.blocks {
+			block := make([]byte, blockSize)
+			for idx := range block {
+				block[idx] = byte(rng.UintN(256))
+			}
+			sink.Update(block)
+		}
+		// A block shorter than the block size is not counted
+		sink.Update(make([]byte, blockSize/2))
+		sink.Finalize()
+		if math.IsNaN(sink.Result) != test.missing {
+			t.Errorf("%d blocks: result %f, missing %v", test.blocks, sink.Result, test.missing)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+/*
+* Byte pair distribution tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+const bigramBins = 65536
+
+// BigramHistogram counts the pairs of adjacent bytes, the pair (a, b) is at index a<<8 | b
+type BigramHistogram [bigramBins]int
+
+// BigramSink counts the pairs of adjacent bytes of the stream, including the pairs across blocks
+type BigramSink struct {
+	Pairs *BigramHistogram
+	// Number of counted pairs
+	PairsRead int
+	// Last byte of the previous block, -1 before the first one
+	last int
+}
+
+func NewBigramSink() *BigramSink {
+	return &BigramSink{Pairs: new(BigramHistogram), last: -1}
+}
+
+type bigramPartial struct {
+	pairs       *BigramHistogram
+	first, last byte
+	length      int
+}
+
+func (b *BigramSink) ProcessBlock(block []byte) any {
+	partial := &bigramPartial{pairs: new(BigramHistogram), length: len(block)}
+	if len(block) == 0 {
+		return partial
+	}
+	partial.first, partial.last = block[0], block[len(block)-1]
+	for idx := 1; idx < len(block); idx++ {
+		partial.pairs[int(block[idx-1])<<8|int(block[idx])]++
+	}
+	return partial
+}
+
+func (b *BigramSink) Merge(partial any) {
+	blockResult := partial.(*bigramPartial)
+	if blockResult.length == 0 {
+		return
+	}
+	for pair, count := range blockResult.pairs {
+		b.Pairs[pair] += count
+	}
+	b.PairsRead += blockResult.length - 1
+	if b.last >= 0 {
+		b.Pairs[b.last<<8|int(blockResult.first)]++
+		b.PairsRead++
+	}
+	b.last = int(blockResult.last)
+}
+
+func (b *BigramSink) Update(block []byte) {
+	b.Merge(b.ProcessBlock(block))
+}
+
+func (b *BigramSink) Finalize() {
+}
+
+type bigramState struct {
+	Pairs     *BigramHistogram `json:"pairs"`
+	PairsRead int              `json:"pairs_read"`
+	Last      int              `json:"last"`
+}
+
+func (b *BigramSink) SaveState() ([]byte, error) {
+	return json.Marshal(bigramState{Pairs: b.Pairs, PairsRead: b.PairsRead, Last: b.last})
+}
+
+func (b *BigramSink) RestoreState(state []byte) error {
+	restored := bigramState{Pairs: new(BigramHistogram)}
+	if err := json.Unmarshal(state, &restored); err != nil {
+		return err
+	}
+	b.Pairs, b.PairsRead, b.last = restored.Pairs, restored.PairsRead, restored.Last
+	return nil
+}
+
+// BigramSerialTest returns the serial statistic of the byte pairs, the chi-squared statistic of the
+// pairs less the one of the first bytes, and its p-value for 65536-256 degrees of freedom. Overlapping
+// pairs are not independent, so the statistic of the pairs alone does not follow the chi-squared
+// distribution while the difference does.
+func BigramSerialTest(pairs *BigramHistogram, pairsCount int) (float64, float64) {
+	n := float64(pairsCount)
+	var singles [256]int
+	var pairsSum float64
+	for pair, count := range pairs {
+		singles[pair>>8] += count
+		pairsSum += float64(count) * float64(count)
+	}
+	var singlesSum float64
+	for _, count := range singles {
+		singlesSum += float64(count) * float64(count)
+	}
+
+	psiSquared1 := 256/n*singlesSum - n
+	psiSquared2 := bigramBins/n*pairsSum - n
+	return psiSquared2 - psiSquared1, ChiSqPValue(psiSquared2-psiSquared1, bigramBins-256)
+}
+
+// BigramKsTest returns the largest distance between the cumulative distribution of the byte pairs
+// and the uniform one, the pair where it is reached and the critical value at the 0.05 level
+func BigramKsTest(pairs *BigramHistogram, pairsCount int) (float64, int, float64) {
+	var empiricalCumSum, statistic float64
+	maxDiffPosition := 0
+	for pair, count := range pairs {
+		empiricalCumSum += float64(count) / float64(pairsCount)
+		if difference := math.Abs(empiricalCumSum - float64(pair+1)/bigramBins); difference > statistic {
+			statistic = difference
+			maxDiffPosition = pair
+		}
+	}
+	return statistic, maxDiffPosition, 1.36 / math.Sqrt(float64(pairsCount))
+}
+
+// MutualInformation returns the information in bits one byte gives about the next one, and the same
+// value less the Miller-Madow bias, the expected mutual information of independent bytes
+func MutualInformation(pairs *BigramHistogram, pairsCount int) (float64, float64) {
+	var firstCounts, secondCounts [256]int
+	for pair, count := range pairs {
+		firstCounts[pair>>8] += count
+		secondCounts[pair&0xff] += count
+	}
+
+	total := float64(pairsCount)
+	var information float64
+	var observedPairs int
+	for pair, count := range pairs {
+		if count == 0 {
+			continue
+		}
+		observedPairs++
+		p := float64(count) / total
+		information += p * math.Log2(p*total*total/float64(firstCounts[pair>>8])/float64(secondCounts[pair&0xff]))
+	}
+
+	var firstValues, secondValues int
+	for value := range firstCounts {
+		if firstCounts[value] > 0 {
+			firstValues++
+		}
+		if secondCounts[value] > 0 {
+			secondValues++
+		}
+	}
+	bias := float64(observedPairs-firstValues-secondValues+1) / (2 * total * math.Ln2)
+	return information, information - bias
+}
+
+type bigramAnalyzer struct {
+	analyzerInfo
+	bigramInput
+	chiSquare       float64
+	ksStatistic     float64
+	ksCriticalValue float64
+	maxDiffPosition int
+	pairsRead       int
+}
+
+func newBigramAnalyzer(info analyzerInfo, run AnalyzerRun) Analyzer {
+	return &bigramAnalyzer{analyzerInfo: info}
+}
+
+// Finalize returns the p-value of the serial test, the Kolmogorov-Smirnov test is reported in the details
+func (b *bigramAnalyzer) Finalize(counts *StreamCounts) float64 {
+	b.pairsRead = counts.PairsRead
+	if counts.Bigrams == nil || b.pairsRead == 0 {
+		return math.NaN()
+	}
+	var pValue float64
+	b.chiSquare, pValue = BigramSerialTest(counts.Bigrams, counts.PairsRead)
+	b.ksStatistic, b.maxDiffPosition, b.ksCriticalValue = BigramKsTest(counts.Bigrams, counts.PairsRead)
+	return pValue
+}
+
+func (b *bigramAnalyzer) Details() string {
+	verdict := "не відхиляє рівномірність"
+	if b.ksStatistic > b.ksCriticalValue {
+		verdict = "відхиляє рівномірність"
+	}
+	return fmt.Sprintf("Послідовна статистика %f, 65280 ступенів свободи, %d пар байтів. Критерій Колмогорова для пар: відхилення %f у парі %02x %02x, критичне значення %f (0,05), %s.",
+		b.chiSquare, b.pairsRead, b.ksStatistic, b.maxDiffPosition>>8, b.maxDiffPosition&0xff, b.ksCriticalValue, verdict)
+}
+
+type mutualInformationAnalyzer struct {
+	analyzerInfo
+	bigramInput
+	information float64
+	pairsRead   int
+}
+
+func newMutualInformationAnalyzer(info analyzerInfo, run AnalyzerRun) Analyzer {
+	return &mutualInformationAnalyzer{analyzerInfo: info}
+}
+
+// Finalize returns the bias-corrected mutual information, which stays near zero for independent bytes
+func (m *mutualInformationAnalyzer) Finalize(counts *StreamCounts) float64 {
+	m.pairsRead = counts.PairsRead
+	if counts.Bigrams == nil || m.pairsRead == 0 {
+		return math.NaN()
+	}
+	var corrected float64
+	m.information, corrected = MutualInformation(counts.Bigrams, counts.PairsRead)
+	return corrected
+}
+
+func (m *mutualInformationAnalyzer) Details() string {
+	return fmt.Sprintf("Взаємна інформація сусідніх байтів без поправки на зміщення %f біт, %d пар байтів.", m.information, m.pairsRead)
+}
@@ -0,0 +1,127 @@
+/*
+* Byte pair tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func randomBytes(size int, seed uint64) []byte {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	data := make([]byte, size)
+	for idx := range data {
+		data[idx] = byte(rng.Uint32())
+	}
+	return data
+}
+
+func countPairs(data []byte) (*BigramHistogram, int) {
+	pairs := new(BigramHistogram)
+	for idx := 1; idx < len(data); idx++ {
+		pairs[int(data[idx-1])<<8|int(data[idx])]++
+	}
+	return pairs, len(data) - 1
+}
+
+func TestBigramSinkBlocks(t *testing.T) {
+	data := randomBytes(300, 1)
+	want, wantCount := countPairs(data)
+	for _, blockSize := range []int{1, 7, 64, len(data)} {
+		sink := NewBigramSink()
+		for start := 0; start < len(data); start += blockSize {
+			sink.Update(data[start:min(start+blockSize, len(data))])
+		}
+		if sink.PairsRead != wantCount || *sink.Pairs != *want {
+			t.Errorf("block size %d: %d pairs, the counts differ from the pairs of the whole data (%d)", blockSize, sink.PairsRead, wantCount)
+		}
+	}
+}
+
+func TestBigramSerialTest(t *testing.T) {
+	periodic := make([]byte, 1<<20)
+	for idx := range periodic {
+		periodic[idx] = byte(idx * 7)
+	}
+	// Uniform bytes that each depend on the previous one, the test of the single bytes passes them
+	walk := make([]byte, 1<<20)
+	rng := rand.New(rand.NewPCG(2, 2))
+	for idx := 1; idx < len(walk); idx++ {
+		walk[idx] = walk[idx-1] + byte(rng.IntN(16))
+	}
+	tests := []struct {
+		name       string
+		data       []byte
+		minP, maxP float64
+	}{
+		{"random", randomBytes(1<<21, 3), 0.001, 1},
+		{"periodic", periodic, 0, 1e-10},
+		{"dependent pairs", walk, 0, 1e-10},
+	}
+	for _, test := range tests {
+		pairs, count := countPairs(test.data)
+		statistic, pValue := BigramSerialTest(pairs, count)
+		if pValue < test.minP || pValue > test.maxP {
+			t.Errorf("%s: statistic %f, p-value %g outside [%g; %g]", test.name, statistic, pValue, test.minP, test.maxP)
+		}
+		if wrapped := BigramTest(test.data); math.Abs(wrapped-pValue) > 0.05 {
+			t.Errorf("%s: p-value %g of the wrapped sequence, %g of the stream", test.name, wrapped, pValue)
+		}
+	}
+}
+
+// The serial statistic of random data follows the chi-squared distribution with 65280 degrees of freedom,
+// so its mean over the samples is close to them
+func TestBigramSerialStatisticMean(t *testing.T) {
+	const samples = 20
+	var mean float64
+	for seed := range uint64(samples) {
+		pairs, count := countPairs(randomBytes(1<<19, 10+seed))
+		statistic, _ := BigramSerialTest(pairs, count)
+		mean += statistic / samples
+	}
+	// The standard deviation of the mean is sqrt(2·65280/20), about 81
+	if math.Abs(mean-65280) > 400 {
+		t.Errorf("mean statistic %f, want about 65280", mean)
+	}
+}
+
+func TestMutualInformation(t *testing.T) {
+	successor := make([]byte, 1<<20)
+	for idx := range successor {
+		successor[idx] = byte(idx)
+	}
+	tests := []struct {
+		name      string
+		data      []byte
+		want, tol float64
+	}{
+		{"independent bytes", randomBytes(1<<20, 4), 0, 0.002},
+		// The next byte is determined by the previous one
+		{"successor", successor, 8, 0.01},
+	}
+	for _, test := range tests {
+		pairs, count := countPairs(test.data)
+		_, corrected := MutualInformation(pairs, count)
+		if math.Abs(corrected-test.want) > test.tol {
+			t.Errorf("%s: mutual information %f, want %f", test.name, corrected, test.want)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+/*
+* Profile calibration on a labelled corpus module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/montanaflynn/stats"
+)
+
+var encryptionLabels = map[string]int{
+	"none": NoEncryption,
+	"fde":  FullDiskEncryption,
+	"fbe":  FileBasedEncryption,
+}
+
+var encryptionNames = []string{"none", "FDE", "FBE"}
+
+type ManifestEntry struct {
+	Path  string `json:"path"`
+	Label string `json:"label"`
+	// Optional description of synthetic images
+	FileSystem string `json:"filesystem,omitempty"`
+	Mix        string `json:"mix,omitempty"`
+}
+
+type ThresholdChoice struct {
+	Threshold        float64
+	BalancedAccuracy float64
+	// Rows are the actual classes (not encrypted, encrypted), columns are the votes
+	Confusion [2][2]int
+}
+
+// LoadManifest reads a JSON list of labelled images, relative paths are resolved against the manifest directory
+func LoadManifest(fileName string) ([]ManifestEntry, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ManifestEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, fmt.Errorf("не вдалося розібрати маніфест %s: %v", fileName, err)
+	}
+
+	for idx, entry := range entries {
+		if _, ok := encryptionLabels[entry.Label]; !ok {
+			return nil, fmt.Errorf("маніфест %s: невідома мітка %q для %s (очікується none, fde або fbe)", fileName, entry.Label, entry.Path)
+		}
+		if !filepath.IsAbs(entry.Path) {
+			entries[idx].Path = filepath.Join(filepath.Dir(fileName), entry.Path)
+		}
+	}
+	return entries, nil
+}
+
+func testVote(direction float64, statistic float64, threshold float64) bool {
+	return direction*(statistic-threshold) >= 0
+}
+
+func thresholdConfusion(values []float64, positives []bool, direction float64, threshold float64) ThresholdChoice {
+	choice := ThresholdChoice{Threshold: threshold}
+	for idx, value := range values {
+		actual, vote := 0, 0
+		if positives[idx] {
+			actual = 1
+		}
+		if testVote(direction, value, threshold) {
+			vote = 1
+		}
+		choice.Confusion[actual][vote]++
+	}
+
+	var recalls []float64
+	for actual := 0; actual < 2; actual++ {
+		total := choice.Confusion[actual][0] + choice.Confusion[actual][1]
+		if total > 0 {
+			recalls = append(recalls, float64(choice.Confusion[actual][actual])/float64(total))
+		}
+	}
+	choice.BalancedAccuracy = meanFloats(recalls)
+	return choice
+}
+
+// chooseThreshold tries the midpoints between neighbouring observed statistics
+// and keeps the one with the highest balanced accuracy
+func chooseThreshold(values []float64, positives []bool, direction float64) ThresholdChoice {
+	sorted := slices.Clone(values)
+	sort.Float64s(sorted)
+	sorted = slices.Compact(sorted)
+
+	candidates := []float64{sorted[0] - 1, sorted[len(sorted)-1] + 1}
+	for idx := 1; idx < len(sorted); idx++ {
+		candidates = append(candidates, (sorted[idx-1]+sorted[idx])/2)
+	}
+
+	best := thresholdConfusion(values, positives, direction, candidates[0])
+	for _, threshold := range candidates[1:] {
+		choice := thresholdConfusion(values, positives, direction, threshold)
+		if choice.BalancedAccuracy > best.BalancedAccuracy {
+			best = choice
+		}
+	}
+	return best
+}
+
+// rocAUC returns the area under the ROC curve, the probability that an encrypted image
+// scores higher than a non-encrypted one (ties count as one half)
+func rocAUC(values []float64, positives []bool, direction float64) float64 {
+	var pairs, wins float64
+	for i, positive := range values {
+		if !positives[i] {
+			continue
+		}
+		for j, negative := range values {
+			if positives[j] {
+				continue
+			}
+			pairs++
+			if direction*positive > direction*negative {
+				wins++
+			} else if positive == negative {
+				wins += 0.5
+			}
+		}
+	}
+	if pairs == 0 {
+		return math.NaN()
+	}
+	return wins / pairs
+}
+
+// rocCurve returns the (false positive rate, true positive rate) points of the test
+// for every threshold between the observed statistics
+func rocCurve(values []float64, positives []bool, direction float64) [][2]float64 {
+	sorted := slices.Clone(values)
+	sort.Float64s(sorted)
+	sorted = slices.Compact(sorted)
+	if direction > 0 {
+		slices.Reverse(sorted)
+	}
+
+	curve := [][2]float64{{0, 0}}
+	for _, threshold := range sorted {
+		confusion := thresholdConfusion(values, positives, direction, threshold).Confusion
+		var fpr, tpr float64
+		if negatives := confusion[0][0] + confusion[0][1]; negatives > 0 {
+			fpr = float64(confusion[0][1]) / float64(negatives)
+		}
+		if encrypted := confusion[1][0] + confusion[1][1]; encrypted > 0 {
+			tpr = float64(confusion[1][1]) / float64(encrypted)
+		}
+		curve = append(curve, [2]float64{fpr, tpr})
+	}
+	return curve
+}
+
+// pooledStdDev returns the within-class standard deviation of the statistic
+func pooledStdDev(values []float64, positives []bool) float64 {
+	var encrypted, plain []float64
+	for idx, value := range values {
+		if positives[idx] {
+			encrypted = append(encrypted, value)
+		} else {
+			plain = append(plain, value)
+		}
+	}
+	encryptedVariance, _ := stats.Variance(encrypted)
+	plainVariance, _ := stats.Variance(plain)
+	return math.Sqrt((encryptedVariance*float64(len(encrypted)) + plainVariance*float64(len(plain))) / float64(len(values)))
+}
+
+// presentStatistics returns the statistics of the test with the labels of the results
+// it was computed for, the results without it are left out
+func presentStatistics(name string, results []AnalysisResult, positives []bool) ([]float64, []bool) {
+	var values []float64
+	var present []bool
+	for idx, result := range results {
+		if statistic, ok := result.Statistics[name]; ok && !math.IsNaN(statistic) {
+			values = append(values, statistic)
+			present = append(present, positives[idx])
+		}
+	}
+	return values, present
+}
+
+// CalibrateProfile chooses the test thresholds maximising balanced accuracy on the collected
+// results and then fits the classifier weights to the results that reach Stage 2
+func CalibrateProfile(base Profile, results []AnalysisResult, labels []int) (Profile, map[string]ThresholdChoice, error) {
+	profile := base
+	profile.Tests = maps.Clone(base.Tests)
+	choices := make(map[string]ThresholdChoice)
+
+	allPositives := make([]bool, len(labels))
+	for idx, label := range labels {
+		allPositives[idx] = label != NoEncryption
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(profile.Tests)) {
+		values, positives := presentStatistics(name, results, allPositives)
+		if len(values) == 0 {
+			continue
+		}
+
+		calibration := profile.Tests[name]
+		choice := chooseThreshold(values, positives, testDirections[name])
+		if choice.BalancedAccuracy <= 0.5 {
+			// The test does not separate the classes on this corpus, its threshold is kept
+			choice = thresholdConfusion(values, positives, testDirections[name], calibration.Threshold)
+		}
+		choices[name] = choice
+
+		calibration.Threshold = choice.Threshold
+		scaled := make([]float64, len(values))
+		for idx, value := range values {
+			scaled[idx] = evidenceValue(name, value)
+		}
+		if scale := pooledStdDev(scaled, positives); scale > 0 {
+			calibration.Scale = scale
+		}
+		profile.Tests[name] = calibration
+	}
+
+	// The classifier only sees the images without an encryption tool and a file system,
+	// the file based encryption is decided by Stage 1
+	var samples []LabelledSample
+	for idx, result := range results {
+		if !result.EncToolFound() && !result.HasFileSystem() {
+			samples = append(samples, LabelledSample{Statistics: result.Statistics, Encrypted: allPositives[idx]})
+		}
+	}
+	fitted, err := FitClassifierWeights(profile, samples)
+	return fitted, choices, err
+}
+
+// samePath reports whether the two paths name the same file
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
+
+func printBinaryConfusion(confusion [2][2]int) {
+	fmt.Printf("                  голос: ні  голос: так\n")
+	fmt.Printf("  без шифрування  %9d  %10d\n", confusion[0][0], confusion[0][1])
+	fmt.Printf("  шифрування      %9d  %10d\n", confusion[1][0], confusion[1][1])
+}
+
+func printClassConfusion(confusion [3][3]int) {
+	fmt.Printf("  факт \\ прогноз %8s %8s %8s\n", encryptionNames[0], encryptionNames[1], encryptionNames[2])
+	for actual, row := range confusion {
+		fmt.Printf("  %-14s %8d %8d %8d\n", encryptionNames[actual], row[0], row[1], row[2])
+	}
+}
+
+func runCalibrateCommand(args []string) int {
+	flags := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	manifestFile := flags.String("manifest", "", "JSON-маніфест образів з мітками none/fde/fbe")
+	baseProfileFile := flags.String("profile", defaultProfileFile, "профіль, з якого беруться початкові значення")
+	outputFile := flags.String("o", defaultProfileFile, "файл для збереження нового профілю")
+	force := flags.Bool("force", false, "перезаписати базовий профіль, якщо -o вказує на нього")
+	profileName := flags.String("name", "calibrated", "назва нового профілю")
+	analysis := addAnalysisFlags(flags)
+	_ = flags.Parse(args)
+
+	options, err := analysis.Options()
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	if options.Sampling.Enabled() {
+		fmt.Println("Калібрування потребує аналізу усього образу, вибірка (-sample) не підтримується.")
+		return 2
+	}
+
+	if *manifestFile == "" {
+		fmt.Println("Не вказано маніфест (-manifest).")
+		return 2
+	}
+	if samePath(*baseProfileFile, *outputFile) && !*force {
+		if _, err := os.Stat(*outputFile); err == nil {
+			fmt.Printf("Новий профіль замінив би базовий %s. Вкажіть інший файл (-o) або -force.\n", *outputFile)
+			return 2
+		}
+	}
+
+	entries, err := LoadManifest(*manifestFile)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if len(entries) == 0 {
+		fmt.Println("Маніфест порожній.")
+		return 1
+	}
+
+	base, err := LoadProfile(*baseProfileFile)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Не вдалося завантажити профіль, використано типовий: %s\n", err)
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	errorLogger := log.New(os.Stderr, "", log.LstdFlags)
+	results := make([]AnalysisResult, len(entries))
+	labels := make([]int, len(entries))
+	for idx, entry := range entries {
+		fmt.Printf("[%d/%d] %s (%s)\n", idx+1, len(entries), entry.Path, entry.Label)
+		results[idx], err = CollectAllStatistics(ctx, entry.Path, options, errorLogger)
+		if err != nil {
+			fmt.Printf("Помилка аналізу %s: %s\n", entry.Path, err)
+			return 1
+		}
+		labels[idx] = encryptionLabels[entry.Label]
+	}
+
+	profile, choices, err := CalibrateProfile(base, results, labels)
+	if err != nil {
+		fmt.Printf("Помилка навчання класифікатора: %s\n", err)
+		return 1
+	}
+	profile.Name = *profileName
+
+	allPositives := make([]bool, len(labels))
+	for idx, label := range labels {
+		allPositives[idx] = label != NoEncryption
+	}
+	for _, name := range slices.Sorted(maps.Keys(choices)) {
+		choice := choices[name]
+		values, positives := presentStatistics(name, results, allPositives)
+		fmt.Printf("\nТест %s: поріг %f, збалансована точність %f, AUC %f, вага %f ± %f\n", name, choice.Threshold, choice.BalancedAccuracy, rocAUC(values, positives, testDirections[name]), profile.Tests[name].Weight, profile.Tests[name].WeightStdErr)
+		printBinaryConfusion(choice.Confusion)
+		fmt.Print("  ROC (FPR; TPR):")
+		for _, point := range rocCurve(values, positives, testDirections[name]) {
+			fmt.Printf(" (%.3f; %.3f)", point[0], point[1])
+		}
+		fmt.Println()
+	}
+
+	var confusion [3][3]int
+	var recalls []float64
+	for idx := range results {
+		DecideEncryption(&results[idx], profile)
+		confusion[labels[idx]][results[idx].Encryption]++
+	}
+	for actual, row := range confusion {
+		if total := row[0] + row[1] + row[2]; total > 0 {
+			recalls = append(recalls, float64(row[actual])/float64(total))
+		}
+	}
+	fmt.Printf("\nМетод з новим профілем: збалансована точність %f\n", meanFloats(recalls))
+	printClassConfusion(confusion)
+
+	if err := SaveProfile(profile, *outputFile); err != nil {
+		fmt.Printf("Не вдалося зберегти профіль: %s\n", err)
+		return 1
+	}
+	fmt.Printf("\nПрофіль %s збережено у %s\n", profile.Name, *outputFile)
+	return 0
+}
@@ -0,0 +1,280 @@
+/*
+* Analysis result charts module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// ChartSeries is a line or a set of bars of a chart
+type ChartSeries struct {
+	Name string
+	X    []float64
+	Y    []float64
+	Bars bool
+	// Step lines hold their value up to the next point, as a distribution function does
+	Step bool
+}
+
+// ChartMarker highlights a position on the X axis
+type ChartMarker struct {
+	X     float64
+	Label string
+}
+
+// Chart is a plot of the analysis result, drawn by the GUI and exported to PNG or SVG
+type Chart struct {
+	// Name is used for the exported file names
+	Name    string
+	Title   string
+	XLabel  string
+	YLabel  string
+	Series  []ChartSeries
+	Markers []ChartMarker
+	// Logarithmic Y axis, the non-positive values are left out
+	LogY bool
+	// Labels of the points of the first series, drawn on the X axis instead of the numbers
+	Categories []string
+}
+
+// Bounds returns the ranges of the axes covering all the series and markers
+func (c Chart) Bounds() (minX, maxX, minY, maxY float64) {
+	minX, minY = math.Inf(1), math.Inf(1)
+	maxX, maxY = math.Inf(-1), math.Inf(-1)
+	for _, series := range c.Series {
+		for idx, x := range series.X {
+			y := series.Y[idx]
+			if math.IsNaN(y) || c.LogY && y <= 0 {
+				continue
+			}
+			minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+			minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+			if series.Bars && !c.LogY {
+				minY, maxY = math.Min(minY, 0), math.Max(maxY, 0)
+			}
+		}
+	}
+	for _, marker := range c.Markers {
+		minX, maxX = math.Min(minX, marker.X), math.Max(maxX, marker.X)
+	}
+
+	if math.IsInf(minX, 1) {
+		return 0, 1, 0, 1
+	}
+	if maxX == minX {
+		minX, maxX = minX-0.5, maxX+0.5
+	}
+	if maxY == minY {
+		minY, maxY = minY-0.5, maxY+0.5
+	}
+	return minX, maxX, minY, maxY
+}
+
+// Empty reports whether the chart has nothing to draw
+func (c Chart) Empty() bool {
+	for _, series := range c.Series {
+		if len(series.X) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ChartTicks returns the round values between low and high, about count of them
+func ChartTicks(low, high float64, count int) []float64 {
+	if !(high > low) || count < 1 {
+		return []float64{low}
+	}
+	rawStep := (high - low) / float64(count)
+	magnitude := math.Pow(10, math.Floor(math.Log10(rawStep)))
+	step := magnitude
+	for _, factor := range []float64{2, 5, 10} {
+		if step >= rawStep {
+			break
+		}
+		step = factor * magnitude
+	}
+
+	var ticks []float64
+	for tick := math.Ceil(low/step) * step; tick <= high+step*1e-9; tick += step {
+		// Rounding keeps the zero tick from becoming -1e-17
+		ticks = append(ticks, math.Round(tick/step)*step)
+	}
+	return ticks
+}
+
+// FormatChartValue prints a tick value without the insignificant digits
+func FormatChartValue(value float64) string {
+	if value != 0 && (math.Abs(value) >= 1e6 || math.Abs(value) < 1e-3) {
+		return fmt.Sprintf("%.0e", value)
+	}
+	return fmt.Sprintf("%.4g", value)
+}
+
+// ByteHistogramChart shows the counts of the byte values against the uniform expectation
+func ByteHistogramChart(histogram ByteHistogram) Chart {
+	var total int
+	x := make([]float64, 256)
+	y := make([]float64, 256)
+	for value, count := range histogram {
+		x[value] = float64(value)
+		y[value] = float64(count)
+		total += count
+	}
+	expected := float64(total) / 256
+	return Chart{
+		Name:   "histogram",
+		Title:  fmt.Sprintf("Гістограма значень байтів (%d байтів)", total),
+		XLabel: "Значення байта",
+		YLabel: "Кількість",
+		Series: []ChartSeries{
+			{Name: "Частота", X: x, Y: y, Bars: true},
+			{Name: "Рівномірний розподіл", X: []float64{0, 255}, Y: []float64{expected, expected}},
+		},
+	}
+}
+
+// ByteCdfChart compares the empirical distribution function of the bytes with the uniform one
+// and marks the position of the Kolmogorov-Smirnov statistic
+func ByteCdfChart(histogram ByteHistogram) Chart {
+	var total int
+	for _, count := range histogram {
+		total += count
+	}
+	chart := Chart{
+		Name:   "cdf",
+		Title:  "Емпірична функція розподілу байтів",
+		XLabel: "Значення байта",
+		YLabel: "F(x)",
+	}
+	if total == 0 {
+		return chart
+	}
+
+	x := make([]float64, 256)
+	empirical := make([]float64, 256)
+	uniform := make([]float64, 256)
+	var cumulative int
+	for value, count := range histogram {
+		cumulative += count
+		x[value] = float64(value)
+		empirical[value] = float64(cumulative) / float64(total)
+		uniform[value] = float64(value+1) / 256
+	}
+	ks := KsTest(histogram, total)
+	chart.Series = []ChartSeries{
+		{Name: "Емпірична", X: x, Y: empirical, Step: true},
+		{Name: "Рівномірна", X: x, Y: uniform, Step: true},
+	}
+	chart.Markers = []ChartMarker{{X: float64(ks.MaxDiffPosition), Label: fmt.Sprintf("D = %.5f, асимптотичне p = %.4g", ks.Statistic, ks.PValue)}}
+	return chart
+}
+
+// EntropyProfileChart shows the entropy of the consecutive windows of the optimized image
+func EntropyProfileChart(entropies []float64, windowSize int) Chart {
+	x := make([]float64, len(entropies))
+	for idx := range entropies {
+		x[idx] = float64(idx) * float64(windowSize) / (1 << 20)
+	}
+	return Chart{
+		Name:   "entropy_profile",
+		Title:  fmt.Sprintf("Профіль ентропії (вікна по %d байтів)", windowSize),
+		XLabel: "Зміщення в оптимізованому образі, МіБ",
+		YLabel: "Ентропія, біт на байт",
+		Series: []ChartSeries{{Name: "Ентропія", X: x, Y: entropies}},
+	}
+}
+
+// AutocorrelationProfileChart shows the mean autocorrelation of the consecutive full blocks
+func AutocorrelationProfileChart(profile *AutocorrelationProfile, blockSize int) Chart {
+	x := make([]float64, len(profile.BlockMeans))
+	for idx := range profile.BlockMeans {
+		x[idx] = float64(idx) * float64(blockSize) / (1 << 20)
+	}
+	return Chart{
+		Name:   "autocorrelation_profile",
+		Title:  fmt.Sprintf("Профіль автокореляції (блоки по %d байтів)", blockSize),
+		XLabel: "Зміщення в оптимізованому образі, МіБ",
+		YLabel: "Середня автокореляція",
+		Series: []ChartSeries{{Name: "Автокореляція", X: x, Y: profile.BlockMeans}},
+	}
+}
+
+// AutocorrelationLagsChart shows the mean autocorrelation by lag, the lags are categories
+// because the long ones would squeeze the short ones on a linear axis
+func AutocorrelationLagsChart(profile *AutocorrelationProfile) Chart {
+	x := make([]float64, len(profile.Lags))
+	categories := make([]string, len(profile.Lags))
+	for idx, lag := range profile.Lags {
+		x[idx] = float64(idx)
+		categories[idx] = fmt.Sprint(lag)
+	}
+	return Chart{
+		Name:       "autocorrelation_lags",
+		Title:      "Середня автокореляція за лагами",
+		XLabel:     "Лаг, байтів",
+		YLabel:     "Автокореляція",
+		Series:     []ChartSeries{{Name: "Автокореляція", X: x, Y: profile.LagMeans, Bars: true}},
+		Categories: categories,
+	}
+}
+
+// SpectrumChart shows the averaged power spectrum of the bytes
+func SpectrumChart(profile *AutocorrelationProfile) Chart {
+	x := make([]float64, len(profile.Spectrum))
+	for bin := range profile.Spectrum {
+		x[bin] = float64(bin) / float64(profile.SpectrumSegment)
+	}
+	chart := Chart{
+		Name:   "spectrum",
+		Title:  "Спектр потужності",
+		XLabel: "Частота, циклів на байт",
+		YLabel: "Потужність",
+		LogY:   true,
+		Series: []ChartSeries{{Name: "Потужність", X: x, Y: profile.Spectrum}},
+	}
+	for _, peak := range SpectrumPeaks(profile.Spectrum) {
+		chart.Markers = append(chart.Markers, ChartMarker{X: 1 / peak.Period, Label: fmt.Sprintf("%.1f байтів", peak.Period)})
+	}
+	return chart
+}
+
+// ResultCharts returns the charts of the data the analysis collected
+func ResultCharts(result AnalysisResult) []Chart {
+	var charts []Chart
+	if result.Histogram != nil {
+		charts = append(charts, ByteHistogramChart(*result.Histogram), ByteCdfChart(*result.Histogram))
+	}
+	if len(result.EntropyProfile) > 0 {
+		charts = append(charts, EntropyProfileChart(result.EntropyProfile, result.Options.BlockSize))
+	}
+	if profile := result.Autocorrelation; profile != nil {
+		if len(profile.BlockMeans) > 0 {
+			charts = append(charts, AutocorrelationProfileChart(profile, result.Options.BlockSize))
+		}
+		if len(profile.LagMeans) > 0 {
+			charts = append(charts, AutocorrelationLagsChart(profile))
+		}
+		if len(profile.Spectrum) > 0 {
+			charts = append(charts, SpectrumChart(profile))
+		}
+	}
+	return charts
+}
@@ -0,0 +1,352 @@
+/*
+* Chart panel of the GUI module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/mappu/miqt/qt"
+	"github.com/mappu/miqt/qt/svg"
+)
+
+const (
+	chartExportWidth  = 1200
+	chartExportHeight = 700
+)
+
+var chartColors = [][3]int{{31, 119, 180}, {255, 127, 14}, {44, 160, 44}, {148, 103, 189}}
+
+func chartColor(idx int) *qt.QColor {
+	color := chartColors[idx%len(chartColors)]
+	return qt.NewQColor3(color[0], color[1], color[2])
+}
+
+// chartScale maps the values of the chart to the pixels of the plot area
+type chartScale struct {
+	left, top, width, height float64
+	minX, maxX, minY, maxY   float64
+	logY                     bool
+}
+
+func (s chartScale) x(value float64) float64 {
+	return s.left + (value-s.minX)/(s.maxX-s.minX)*s.width
+}
+
+func (s chartScale) y(value float64) float64 {
+	if s.logY {
+		value = math.Log10(value)
+	}
+	return s.top + s.height - (value-s.minY)/(s.maxY-s.minY)*s.height
+}
+
+// decimateSeries keeps the first, lowest and highest points of every pixel column,
+// so that a long profile keeps its peaks without drawing a line per block
+func decimateSeries(x, y []float64, columns int) ([]float64, []float64) {
+	if len(x) <= 2*columns {
+		return x, y
+	}
+	var keptX, keptY []float64
+	perColumn := float64(len(x)) / float64(columns)
+	for column := 0; column < columns; column++ {
+		start, end := int(float64(column)*perColumn), min(len(x), int(float64(column+1)*perColumn))
+		if start >= end {
+			continue
+		}
+		low, high := start, start
+		for idx := start; idx < end; idx++ {
+			if y[idx] < y[low] {
+				low = idx
+			}
+			if y[idx] > y[high] {
+				high = idx
+			}
+		}
+		for _, idx := range []int{min(low, high), max(low, high)} {
+			keptX = append(keptX, x[idx])
+			keptY = append(keptY, y[idx])
+		}
+	}
+	return keptX, keptY
+}
+
+// paintChart draws the chart on any paint device, the widget, an image or an SVG file
+func paintChart(painter *qt.QPainter, chart Chart, width, height int) {
+	painter.SetRenderHint(qt.QPainter__Antialiasing)
+	painter.FillRect7(0, 0, width, height, qt.White)
+	painter.SetPen(qt.NewQColor2(qt.Black))
+	painter.DrawText7(0, 4, width, 24, int(qt.AlignHCenter|qt.AlignVCenter), chart.Title)
+	if chart.Empty() {
+		painter.DrawText7(0, 0, width, height, int(qt.AlignHCenter|qt.AlignVCenter), "Немає даних")
+		return
+	}
+
+	minX, maxX, minY, maxY := chart.Bounds()
+	if chart.LogY {
+		minY, maxY = math.Floor(math.Log10(minY)), math.Ceil(math.Log10(maxY))
+		if minY == maxY {
+			maxY++
+		}
+	} else {
+		margin := 0.05 * (maxY - minY)
+		if minY != 0 {
+			minY -= margin
+		}
+		maxY += margin
+	}
+	for _, series := range chart.Series {
+		if series.Bars {
+			minX, maxX = minX-0.5, maxX+0.5
+			break
+		}
+	}
+	scale := chartScale{left: 80, top: 32, width: float64(width) - 100, height: float64(height) - 84, minX: minX, maxX: maxX, minY: minY, maxY: maxY, logY: chart.LogY}
+	if scale.width < 10 || scale.height < 10 {
+		return
+	}
+	bottom := scale.top + scale.height
+
+	// Grid and the tick labels
+	gridPen := qt.NewQPen3(qt.NewQColor3(220, 220, 220))
+	textColor := qt.NewQColor2(qt.Black)
+	var yTicks []float64
+	if chart.LogY {
+		for power := minY; power <= maxY; power++ {
+			yTicks = append(yTicks, math.Pow(10, power))
+		}
+	} else {
+		yTicks = ChartTicks(minY, maxY, 6)
+	}
+	for _, tick := range yTicks {
+		y := scale.y(tick)
+		painter.SetPenWithPen(gridPen)
+		painter.DrawLine4(qt.NewQPointF3(scale.left, y), qt.NewQPointF3(scale.left+scale.width, y))
+		painter.SetPen(textColor)
+		painter.DrawText7(0, int(y)-10, int(scale.left)-6, 20, int(qt.AlignRight|qt.AlignVCenter), FormatChartValue(tick))
+	}
+
+	type xTick struct {
+		value float64
+		label string
+	}
+	var xTicks []xTick
+	if len(chart.Categories) > 0 {
+		every := (len(chart.Categories) + 11) / 12
+		for idx, category := range chart.Categories {
+			// The last category is always labelled, the long lags are put at the end
+			if idx%every == 0 || idx == len(chart.Categories)-1 {
+				xTicks = append(xTicks, xTick{float64(idx), category})
+			}
+		}
+	} else {
+		for _, tick := range ChartTicks(minX, maxX, 8) {
+			xTicks = append(xTicks, xTick{tick, FormatChartValue(tick)})
+		}
+	}
+	for _, tick := range xTicks {
+		x := scale.x(tick.value)
+		painter.SetPenWithPen(gridPen)
+		painter.DrawLine4(qt.NewQPointF3(x, scale.top), qt.NewQPointF3(x, bottom))
+		painter.SetPen(textColor)
+		painter.DrawText7(int(x)-40, int(bottom)+4, 80, 18, int(qt.AlignHCenter|qt.AlignTop), tick.label)
+	}
+
+	painter.SetPen(textColor)
+	painter.DrawRect(qt.NewQRectF4(scale.left, scale.top, scale.width, scale.height))
+	painter.DrawText7(int(scale.left), height-26, int(scale.width), 22, int(qt.AlignHCenter|qt.AlignVCenter), chart.XLabel)
+	painter.Save()
+	painter.Translate2(4, scale.top+scale.height)
+	painter.Rotate(-90)
+	painter.DrawText7(0, 0, int(scale.height), 20, int(qt.AlignHCenter|qt.AlignVCenter), chart.YLabel)
+	painter.Restore()
+
+	painter.SetClipRect(qt.NewQRectF4(scale.left, scale.top, scale.width, scale.height))
+	for idx, series := range chart.Series {
+		color := chartColor(idx)
+		pen := qt.NewQPen3(color)
+		pen.SetWidthF(1.5)
+		painter.SetPenWithPen(pen)
+
+		if series.Bars {
+			barWidth := 0.8 * scale.width / (maxX - minX)
+			base := bottom
+			if !chart.LogY {
+				base = scale.y(math.Max(minY, 0))
+			}
+			for point, x := range series.X {
+				value := series.Y[point]
+				if math.IsNaN(value) || chart.LogY && value <= 0 {
+					continue
+				}
+				y := scale.y(value)
+				painter.FillRect4(qt.NewQRectF4(scale.x(x)-barWidth/2, math.Min(y, base), barWidth, math.Abs(base-y)), color)
+			}
+			continue
+		}
+
+		x, y := series.X, series.Y
+		if !series.Step {
+			x, y = decimateSeries(x, y, int(scale.width))
+		}
+		for point := 1; point < len(x); point++ {
+			if math.IsNaN(y[point-1]) || math.IsNaN(y[point]) || chart.LogY && (y[point-1] <= 0 || y[point] <= 0) {
+				continue
+			}
+			from := qt.NewQPointF3(scale.x(x[point-1]), scale.y(y[point-1]))
+			to := qt.NewQPointF3(scale.x(x[point]), scale.y(y[point]))
+			if series.Step {
+				corner := qt.NewQPointF3(scale.x(x[point]), scale.y(y[point-1]))
+				painter.DrawLine4(from, corner)
+				painter.DrawLine4(corner, to)
+			} else {
+				painter.DrawLine4(from, to)
+			}
+		}
+	}
+
+	markerPen := qt.NewQPen3(qt.NewQColor3(214, 39, 40))
+	markerPen.SetStyle(qt.DashLine)
+	for idx, marker := range chart.Markers {
+		x := scale.x(marker.X)
+		painter.SetPenWithPen(markerPen)
+		painter.DrawLine4(qt.NewQPointF3(x, scale.top), qt.NewQPointF3(x, bottom))
+		// Labels right of the line unless it is close to the right edge, stacked when there are several
+		labelLeft, alignment := int(x)+4, qt.AlignLeft
+		if x > scale.left+scale.width*0.7 {
+			labelLeft, alignment = int(x)-304, qt.AlignRight
+		}
+		painter.DrawText7(labelLeft, int(scale.top)+4+18*idx, 300, 18, int(alignment|qt.AlignVCenter), marker.Label)
+	}
+	painter.SetClipping(false)
+
+	// Legend in the upper right corner, only when there is more than one series
+	if len(chart.Series) > 1 {
+		for idx, series := range chart.Series {
+			top := int(scale.top) + 8 + 18*idx
+			right := int(scale.left+scale.width) - 8
+			painter.FillRect5(right-180, top+6, 14, 6, chartColor(idx))
+			painter.SetPen(textColor)
+			painter.DrawText7(right-160, top, 160, 18, int(qt.AlignLeft|qt.AlignVCenter), series.Name)
+		}
+	}
+}
+
+// ExportChart writes the chart to a PNG or an SVG file, chosen by the extension
+func ExportChart(chart Chart, fileName string) error {
+	painter := qt.NewQPainter()
+	defer painter.Delete()
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".svg":
+		generator := svg.NewQSvgGenerator()
+		defer generator.Delete()
+		generator.SetFileName(fileName)
+		generator.SetSize(qt.NewQSize2(chartExportWidth, chartExportHeight))
+		generator.SetViewBox(qt.NewQRect4(0, 0, chartExportWidth, chartExportHeight))
+		generator.SetTitle(chart.Title)
+		if !painter.Begin(generator.QPaintDevice) {
+			return fmt.Errorf("не вдалося створити файл %s", fileName)
+		}
+		paintChart(painter, chart, chartExportWidth, chartExportHeight)
+		painter.End()
+		return nil
+	case ".png":
+		image := qt.NewQImage3(chartExportWidth, chartExportHeight, qt.QImage__Format_ARGB32)
+		defer image.Delete()
+		painter.Begin(image.QPaintDevice)
+		paintChart(painter, chart, chartExportWidth, chartExportHeight)
+		painter.End()
+		if !image.Save(fileName) {
+			return fmt.Errorf("не вдалося записати файл %s", fileName)
+		}
+		return nil
+	default:
+		return errors.New("графік можна зберегти лише у форматі PNG або SVG")
+	}
+}
+
+// newChartWidget creates a widget that draws the chart at its current size
+func newChartWidget(chart Chart) *qt.QWidget {
+	widget := qt.NewQWidget2()
+	widget.SetMinimumHeight(320)
+	widget.OnPaintEvent(func(super func(event *qt.QPaintEvent), event *qt.QPaintEvent) {
+		painter := qt.NewQPainter2(widget.QPaintDevice)
+		defer painter.Delete()
+		paintChart(painter, chart, widget.Width(), widget.Height())
+		painter.End()
+	})
+	return widget
+}
+
+// ChartPanel shows the charts of the last analysis result in tabs
+type ChartPanel struct {
+	Widget       *qt.QWidget
+	tabs         *qt.QTabWidget
+	exportButton *qt.QPushButton
+	charts       []Chart
+}
+
+func NewChartPanel(parent *qt.QWidget) *ChartPanel {
+	panel := &ChartPanel{Widget: qt.NewQWidget(parent)}
+	layout := qt.NewQVBoxLayout(panel.Widget)
+	panel.tabs = qt.NewQTabWidget(panel.Widget)
+	panel.exportButton = qt.NewQPushButton4(qt.QIcon_FromTheme("document-save-as"), "Зберегти графік")
+	panel.exportButton.SetEnabled(false)
+	panel.exportButton.OnClicked(panel.exportCurrent)
+	layout.AddWidget(panel.tabs.QWidget)
+	layout.AddWidget(panel.exportButton.QWidget)
+	return panel
+}
+
+// SetCharts replaces the shown charts, nil clears the panel
+func (p *ChartPanel) SetCharts(charts []Chart) {
+	// Clear only removes the pages from the tabs, they are still owned by the panel
+	var pages []*qt.QWidget
+	for index := range p.tabs.Count() {
+		pages = append(pages, p.tabs.Widget(index))
+	}
+	p.tabs.Clear()
+	for _, page := range pages {
+		page.DeleteLater()
+	}
+	p.charts = charts
+	for _, chart := range charts {
+		p.tabs.AddTab(newChartWidget(chart), chart.Title)
+	}
+	p.exportButton.SetEnabled(len(charts) > 0)
+}
+
+func (p *ChartPanel) exportCurrent() {
+	index := p.tabs.CurrentIndex()
+	if index < 0 || index >= len(p.charts) {
+		return
+	}
+	chart := p.charts[index]
+	fileName := qt.QFileDialog_GetSaveFileName4(p.Widget, "Збереження графіка", chart.Name+".png", "Зображення PNG (*.png);;Векторне зображення SVG (*.svg)")
+	if fileName == "" {
+		return
+	}
+	if err := ExportChart(chart, fileName); err != nil {
+		errorWindow := qt.NewQErrorMessage(p.Widget)
+		errorWindow.ShowMessage(err.Error())
+	}
+}
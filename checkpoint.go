@@ -0,0 +1,250 @@
+/*
+* Checkpointing of the streaming pass module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	defaultCheckpointInterval = time.Minute
+	// Bytes hashed at the start and at the end of the image for its fingerprint
+	fingerprintHashedBytes = 1048576
+)
+
+// StateSaver is implemented by the sinks and analysers whose state can be saved between blocks
+type StateSaver interface {
+	SaveState() ([]byte, error)
+	RestoreState(state []byte) error
+}
+
+// CheckpointSink is a BlockSink that can be resumed from a checkpoint
+type CheckpointSink interface {
+	BlockSink
+	StateSaver
+}
+
+// ImageFingerprint identifies the image a checkpoint belongs to without reading all of it
+type ImageFingerprint struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// SHA-256 of the first and the last fingerprintHashedBytes of the image
+	PartialHash string `json:"partial_hash"`
+}
+
+func FingerprintImage(fileName string) (ImageFingerprint, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return ImageFingerprint{}, err
+	}
+	defer file.Close()
+
+	fileStat, err := file.Stat()
+	if err != nil {
+		return ImageFingerprint{}, err
+	}
+
+	hash := sha256.New()
+	headSize := min(fileStat.Size(), fingerprintHashedBytes)
+	if _, err := io.Copy(hash, io.NewSectionReader(file, 0, headSize)); err != nil {
+		return ImageFingerprint{}, err
+	}
+	tailStart := max(headSize, fileStat.Size()-fingerprintHashedBytes)
+	if _, err := io.Copy(hash, io.NewSectionReader(file, tailStart, fileStat.Size()-tailStart)); err != nil {
+		return ImageFingerprint{}, err
+	}
+
+	return ImageFingerprint{
+		Size:        fileStat.Size(),
+		ModTime:     fileStat.ModTime().UTC(),
+		PartialHash: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+func (f ImageFingerprint) Equal(other ImageFingerprint) bool {
+	return f.Size == other.Size && f.ModTime.Equal(other.ModTime) && f.PartialHash == other.PartialHash
+}
+
+type checkpointFile struct {
+	Fingerprint ImageFingerprint `json:"fingerprint"`
+	BlockSize   int              `json:"block_size"`
+	Settings    string           `json:"settings"`
+	// Names of the sinks in stream order, a checkpoint only fits the same set of sinks
+	Layout []string          `json:"layout"`
+	Offset int64             `json:"offset"`
+	States []json.RawMessage `json:"states"`
+	Saved  time.Time         `json:"saved"`
+}
+
+// Checkpointer saves the state of the sinks of a streaming pass at intervals,
+// so that a pass interrupted by a crash or a cancellation resumes from the last checkpoint
+type Checkpointer struct {
+	FileName    string
+	Interval    time.Duration
+	Fingerprint ImageFingerprint
+	Layout      []string
+	// Options of the sinks that change their state, a checkpoint only fits the same settings
+	Settings string
+	// Receives the errors of the saves made during the pass, may be nil
+	ErrorLogger *log.Logger
+	lastSave    time.Time
+	disabled    error
+}
+
+func checkpointFileName(imageFileName string) string {
+	return imageFileName + ".checkpoint"
+}
+
+// NewCheckpointer prepares the checkpoints of the pass over the image, layout names the sinks in stream order
+func NewCheckpointer(imageFileName string, interval time.Duration, layout []string, errorLogger *log.Logger) (*Checkpointer, error) {
+	fingerprint, err := FingerprintImage(imageFileName)
+	if err != nil {
+		return nil, err
+	}
+	return &Checkpointer{
+		FileName:    checkpointFileName(imageFileName),
+		Interval:    interval,
+		Fingerprint: fingerprint,
+		Layout:      layout,
+		ErrorLogger: errorLogger,
+		lastSave:    time.Now(),
+	}, nil
+}
+
+func equalLayouts(first []string, second []string) bool {
+	if len(first) != len(second) {
+		return false
+	}
+	for idx := range first {
+		if first[idx] != second[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// Restore loads the last checkpoint into the sinks and returns the offset to continue from.
+// A missing or damaged checkpoint, or one made for another image or other settings, gives offset zero.
+func (c *Checkpointer) Restore(blockSize int, sinks []BlockSink) (int64, error) {
+	content, err := os.ReadFile(c.FileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	var checkpoint checkpointFile
+	if err := json.Unmarshal(content, &checkpoint); err != nil {
+		if c.ErrorLogger != nil {
+			c.ErrorLogger.Printf("Контрольну точку %s пошкоджено, аналіз почнеться спочатку: %s", c.FileName, err)
+		}
+		return 0, nil
+	}
+	if !checkpoint.Fingerprint.Equal(c.Fingerprint) || checkpoint.BlockSize != blockSize || checkpoint.Settings != c.Settings ||
+		!equalLayouts(checkpoint.Layout, c.Layout) || len(checkpoint.States) != len(sinks) {
+		return 0, nil
+	}
+
+	for idx, sink := range sinks {
+		checkpointSink, ok := sink.(CheckpointSink)
+		if !ok {
+			return 0, fmt.Errorf("аналізатор %s не підтримує контрольні точки", c.Layout[idx])
+		}
+		if err := checkpointSink.RestoreState(checkpoint.States[idx]); err != nil {
+			return 0, fmt.Errorf("не вдалося відновити стан аналізатора %s: %v", c.Layout[idx], err)
+		}
+	}
+	if c.ErrorLogger != nil {
+		c.ErrorLogger.Printf("Аналіз продовжено з контрольної точки %s від %s, оброблено %d байтів.",
+			c.FileName, checkpoint.Saved.Local().Format(time.DateTime), checkpoint.Offset)
+	}
+	return checkpoint.Offset, nil
+}
+
+// Due reports whether the interval since the last checkpoint has passed
+func (c *Checkpointer) Due() bool {
+	return c.disabled == nil && time.Since(c.lastSave) >= c.Interval
+}
+
+// Save writes the state of the sinks after offset bytes of the image, the previous checkpoint
+// is replaced atomically so that a crash during the save keeps it intact
+func (c *Checkpointer) Save(blockSize int, offset int64, sinks []BlockSink) error {
+	if c.disabled != nil {
+		return c.disabled
+	}
+	c.lastSave = time.Now()
+
+	checkpoint := checkpointFile{
+		Fingerprint: c.Fingerprint,
+		BlockSize:   blockSize,
+		Settings:    c.Settings,
+		Layout:      c.Layout,
+		Offset:      offset,
+		Saved:       c.lastSave.UTC(),
+	}
+	for idx, sink := range sinks {
+		checkpointSink, ok := sink.(CheckpointSink)
+		if !ok {
+			c.disabled = fmt.Errorf("аналізатор %s не підтримує контрольні точки", c.Layout[idx])
+			return c.disabled
+		}
+		state, err := checkpointSink.SaveState()
+		if err != nil {
+			c.disabled = fmt.Errorf("не вдалося зберегти стан аналізатора %s: %v", c.Layout[idx], err)
+			return c.disabled
+		}
+		checkpoint.States = append(checkpoint.States, state)
+	}
+
+	content, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	temporaryFileName := c.FileName + ".tmp"
+	if err := os.WriteFile(temporaryFileName, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temporaryFileName, c.FileName)
+}
+
+// saveLogged saves a checkpoint during the pass, a failed save is logged and does not stop the pass
+func (c *Checkpointer) saveLogged(blockSize int, offset int64, sinks []BlockSink) {
+	if c.disabled != nil {
+		return
+	}
+	if err := c.Save(blockSize, offset, sinks); err != nil && c.ErrorLogger != nil {
+		c.ErrorLogger.Printf("Не вдалося зберегти контрольну точку %s: %s", c.FileName, err)
+	}
+}
+
+// Remove deletes the checkpoint once the pass is complete
+func (c *Checkpointer) Remove() error {
+	if err := os.Remove(c.FileName); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
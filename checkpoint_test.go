@@ -0,0 +1,138 @@
+/*
+* Analysis checkpoint tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+var checkpointTestLayout = []string{"histogram", "bigrams"}
+
+// feedBlocks passes the blocks of the data to the sinks as StreamFile does
+func feedBlocks(data []byte, blockSize int, sinks ...ParallelSink) {
+	for start := 0; start < len(data); start += blockSize {
+		block := data[start:min(start+blockSize, len(data))]
+		for _, sink := range sinks {
+			sink.Merge(sink.ProcessBlock(block))
+		}
+	}
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	const blockSize = 4096
+	fileName, data := writeTestImage(t, 100*blockSize+17, 9)
+
+	checkpointer, err := NewCheckpointer(fileName, time.Hour, checkpointTestLayout, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The pass was interrupted after 40 blocks
+	offset := int64(40 * blockSize)
+	histogram, bigrams := NewHistogramSink(), NewBigramSink()
+	feedBlocks(data[:offset], blockSize, histogram, bigrams)
+	if err := checkpointer.Save(blockSize, offset, []BlockSink{histogram, bigrams}); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := NewCheckpointer(fileName, time.Hour, checkpointTestLayout, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	histogram, bigrams = NewHistogramSink(), NewBigramSink()
+	bytesRead, err := StreamFile(context.Background(), fileName, StreamOptions{BlockSize: blockSize, Checkpoint: resumed}, histogram, bigrams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytesRead != len(data) {
+		t.Errorf("%d bytes read with the resumed pass, want %d", bytesRead, len(data))
+	}
+
+	wholeHistogram, wholeBigrams := NewHistogramSink(), NewBigramSink()
+	feedBlocks(data, blockSize, wholeHistogram, wholeBigrams)
+	if histogram.Counter != wholeHistogram.Counter || histogram.BytesRead != wholeHistogram.BytesRead {
+		t.Error("the resumed histogram differs from the one of the whole pass")
+	}
+	if *bigrams.Pairs != *wholeBigrams.Pairs || bigrams.PairsRead != wholeBigrams.PairsRead {
+		t.Error("the resumed byte pairs differ from the ones of the whole pass")
+	}
+}
+
+func TestCheckpointRestore(t *testing.T) {
+	const blockSize = 4096
+	tests := []struct {
+		name   string
+		change func(t *testing.T, fileName string, data []byte, checkpointer *Checkpointer)
+	}{
+		{"same image", nil},
+		{"image changed", func(t *testing.T, fileName string, data []byte, checkpointer *Checkpointer) {
+			data[0] ^= 0xff
+			if err := os.WriteFile(fileName, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			modified := time.Now().Add(time.Hour)
+			if err := os.Chtimes(fileName, modified, modified); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"other settings", func(t *testing.T, fileName string, data []byte, checkpointer *Checkpointer) {
+			checkpointer.Settings = "other"
+		}},
+		{"other sinks", func(t *testing.T, fileName string, data []byte, checkpointer *Checkpointer) {
+			checkpointer.Layout = []string{"bigrams", "histogram"}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName, data := writeTestImage(t, 10*blockSize, 10)
+			checkpointer, err := NewCheckpointer(fileName, time.Hour, checkpointTestLayout, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			histogram, bigrams := NewHistogramSink(), NewBigramSink()
+			feedBlocks(data[:4*blockSize], blockSize, histogram, bigrams)
+			if err := checkpointer.Save(blockSize, 4*blockSize, []BlockSink{histogram, bigrams}); err != nil {
+				t.Fatal(err)
+			}
+
+			if test.change != nil {
+				test.change(t, fileName, data, checkpointer)
+			}
+			restored, err := NewCheckpointer(fileName, time.Hour, checkpointer.Layout, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored.Settings = checkpointer.Settings
+			histogram, bigrams = NewHistogramSink(), NewBigramSink()
+			offset, err := restored.Restore(blockSize, []BlockSink{histogram, bigrams})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.change == nil {
+				if offset != 4*blockSize || histogram.BytesRead != 4*blockSize {
+					t.Errorf("checkpoint restored at offset %d with %d bytes counted, want %d", offset, histogram.BytesRead, 4*blockSize)
+				}
+			} else if offset != 0 || histogram.BytesRead != 0 {
+				t.Errorf("checkpoint restored at offset %d", offset)
+			}
+		})
+	}
+}
@@ -19,20 +19,45 @@
 package main
 
 import (
+	"fmt"
 	"math"
 )
 
-func ChiSqTest(totalCounter map[byte]int, readBytesCount int) float64 {
-	theoreticalDistribution := map[byte]float64{}
-	for i := 0; i < 256; i++ {
-		theoreticalDistribution[byte(i)] = float64(readBytesCount) / 256
-	}
+// ChiSqTest returns Pearson's chi-squared statistic of the byte distribution against the uniform one
+// and its p-value for 255 degrees of freedom
+func ChiSqTest(totalCounter ByteHistogram, readBytesCount int) (float64, float64) {
+	expected := float64(readBytesCount) / 256
 
-	var chiSquare, observed, expected float64
-	for i := 0; i < 256; i++ {
-		observed = float64(totalCounter[byte(i)])
-		expected = theoreticalDistribution[byte(i)]
-		chiSquare += math.Pow(observed-expected, 2) / expected
+	var chiSquare float64
+	for _, count := range totalCounter {
+		chiSquare += math.Pow(float64(count)-expected, 2) / expected
 	}
-	return chiSquare
+	return chiSquare, ChiSqPValue(chiSquare, 255)
+}
+
+// ChiSqPValue returns the probability of observing a chi-square statistic at least this large
+// for the given number of degrees of freedom
+func ChiSqPValue(chiSquare float64, degreesOfFreedom int) float64 {
+	return igamc(float64(degreesOfFreedom)/2, chiSquare/2)
+}
+
+type chiSqAnalyzer struct {
+	analyzerInfo
+	histogramInput
+	chiSquare float64
+}
+
+func newChiSqAnalyzer(info analyzerInfo, run AnalyzerRun) Analyzer {
+	return &chiSqAnalyzer{analyzerInfo: info}
+}
+
+// Finalize returns the p-value, the statistic itself is reported in the details
+func (c *chiSqAnalyzer) Finalize(counts *StreamCounts) float64 {
+	var pValue float64
+	c.chiSquare, pValue = ChiSqTest(counts.Histogram, counts.BytesRead)
+	return pValue
+}
+
+func (c *chiSqAnalyzer) Details() string {
+	return fmt.Sprintf("Статистика %f, 255 ступенів свободи.", c.chiSquare)
 }
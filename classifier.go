@@ -0,0 +1,267 @@
+/*
+* Weighted probabilistic classifier module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+)
+
+const (
+	// Evidence of a single test is capped so that one extreme statistic cannot outvote the others
+	maxEvidence = 4.0
+	// Two-sided 95% quantile of the standard normal distribution
+	confidenceZ = 1.959964
+)
+
+// Direction of each test: +1 if larger statistics indicate encryption, -1 otherwise
+var testDirections = registeredDirections()
+
+type Classification struct {
+	Probability float64
+	// 95% confidence interval of the probability
+	LowerBound float64
+	UpperBound float64
+	LogOdds    float64
+	// Log-likelihood ratio contributed by every test
+	Contributions map[string]float64
+	Encrypted     bool
+}
+
+func sigmoid(x float64) float64 {
+	return 1.0 / (1.0 + math.Exp(-x))
+}
+
+// The p-values are measured in decades: on a linear scale a pass (p about 0.5) gives the full evidence
+// for encryption while a rejection (p near 0) can only give a fraction of a unit against it
+var pValueTests = registeredPValues()
+
+// evidenceValue maps a statistic onto the scale its evidence is measured in
+func evidenceValue(name string, statistic float64) float64 {
+	if pValueTests[name] {
+		return math.Log10(math.Max(statistic, math.SmallestNonzeroFloat64))
+	}
+	return statistic
+}
+
+// testEvidence converts a statistic into signed evidence for encryption in units of the test scale
+func testEvidence(name string, calibration TestCalibration, statistic float64) float64 {
+	distance := evidenceValue(name, statistic) - evidenceValue(name, calibration.Threshold)
+	evidence := testDirections[name] * distance / calibration.Scale
+	return math.Max(-maxEvidence, math.Min(maxEvidence, evidence))
+}
+
+// Classify sums the weighted log-likelihood ratios of the tests present in statistics
+// into the log-odds of encryption. The confidence interval follows from the standard
+// errors of the weights, treated as independent.
+func Classify(profile Profile, statistics map[string]float64) Classification {
+	logOdds := profile.Bias
+	variance := profile.BiasStdErr * profile.BiasStdErr
+	contributions := make(map[string]float64)
+
+	for name, statistic := range statistics {
+		calibration, ok := profile.Tests[name]
+		if !ok {
+			continue
+		}
+		evidence := testEvidence(name, calibration, statistic)
+		contributions[name] = calibration.Weight * evidence
+		logOdds += contributions[name]
+		variance += math.Pow(evidence*calibration.WeightStdErr, 2)
+	}
+
+	margin := confidenceZ * math.Sqrt(variance)
+	probability := sigmoid(logOdds)
+	return Classification{
+		Probability:   probability,
+		LowerBound:    sigmoid(logOdds - margin),
+		UpperBound:    sigmoid(logOdds + margin),
+		LogOdds:       logOdds,
+		Contributions: contributions,
+		Encrypted:     probability >= profile.DecisionThreshold,
+	}
+}
+
+func contributionsToReadable(contributions map[string]float64) string {
+	var readable string
+	for _, name := range slices.Sorted(maps.Keys(contributions)) {
+		readable = readable + fmt.Sprintf("%s - %+.3f, ", name, contributions[name])
+	}
+	return readable
+}
+
+type LabelledSample struct {
+	Statistics map[string]float64
+	Encrypted  bool
+}
+
+const (
+	fitMaxIterations = 100
+	fitTolerance     = 1e-8
+	// Ridge penalty (a standard normal prior on the weights) keeping them and their standard errors
+	// finite when the training set is perfectly separable
+	fitRidgePenalty = 1.0
+)
+
+// invertMatrix inverts a square matrix by Gauss-Jordan elimination with partial pivoting
+func invertMatrix(matrix [][]float64) ([][]float64, error) {
+	n := len(matrix)
+	augmented := make([][]float64, n)
+	for i := range matrix {
+		augmented[i] = make([]float64, 2*n)
+		copy(augmented[i], matrix[i])
+		augmented[i][n+i] = 1.0
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(augmented[row][col]) > math.Abs(augmented[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(augmented[pivot][col]) < 1e-12 {
+			return nil, fmt.Errorf("матриця вироджена")
+		}
+		augmented[col], augmented[pivot] = augmented[pivot], augmented[col]
+
+		pivotValue := augmented[col][col]
+		for j := range augmented[col] {
+			augmented[col][j] /= pivotValue
+		}
+		for row := 0; row < n; row++ {
+			if row == col {
+				continue
+			}
+			factor := augmented[row][col]
+			for j := range augmented[row] {
+				augmented[row][j] -= factor * augmented[col][j]
+			}
+		}
+	}
+
+	inverse := make([][]float64, n)
+	for i := range augmented {
+		inverse[i] = augmented[i][n:]
+	}
+	return inverse, nil
+}
+
+// FitClassifierWeights learns the bias and test weights of the profile from labelled samples
+// by logistic regression (iteratively reweighted least squares). Thresholds and scales are kept,
+// the standard errors are taken from the inverse Fisher information. Only the tests computed
+// for some of the samples are fitted, and the samples missing one of them are left out.
+func FitClassifierWeights(profile Profile, samples []LabelledSample) (Profile, error) {
+	var names []string
+	for _, name := range slices.Sorted(maps.Keys(profile.Tests)) {
+		if slices.ContainsFunc(samples, func(sample LabelledSample) bool {
+			_, ok := sample.Statistics[name]
+			return ok
+		}) {
+			names = append(names, name)
+		}
+	}
+	dimension := len(names) + 1
+
+	var features [][]float64
+	var complete []LabelledSample
+	for _, sample := range samples {
+		row := make([]float64, dimension)
+		row[0] = 1.0
+		for col, name := range names {
+			statistic, ok := sample.Statistics[name]
+			if !ok || math.IsNaN(statistic) {
+				row = nil
+				break
+			}
+			row[col+1] = testEvidence(name, profile.Tests[name], statistic)
+		}
+		if row != nil {
+			features = append(features, row)
+			complete = append(complete, sample)
+		}
+	}
+	if len(complete) == 0 {
+		return profile, fmt.Errorf("немає навчальних зразків з усіма статистиками тестів")
+	}
+	samples = complete
+
+	coefficients := make([]float64, dimension)
+	var covariance [][]float64
+	for iteration := 0; iteration < fitMaxIterations; iteration++ {
+		gradient := make([]float64, dimension)
+		hessian := make([][]float64, dimension)
+		for i := range hessian {
+			hessian[i] = make([]float64, dimension)
+			hessian[i][i] = fitRidgePenalty
+			gradient[i] = -fitRidgePenalty * coefficients[i]
+		}
+
+		for idx, row := range features {
+			var logOdds float64
+			for col, value := range row {
+				logOdds += coefficients[col] * value
+			}
+			predicted := sigmoid(logOdds)
+			var label float64
+			if samples[idx].Encrypted {
+				label = 1.0
+			}
+			for i := range row {
+				gradient[i] += (label - predicted) * row[i]
+				for j := range row {
+					hessian[i][j] += predicted * (1 - predicted) * row[i] * row[j]
+				}
+			}
+		}
+
+		inverse, err := invertMatrix(hessian)
+		if err != nil {
+			return profile, err
+		}
+		covariance = inverse
+
+		var change float64
+		for i := range coefficients {
+			var step float64
+			for j := range gradient {
+				step += inverse[i][j] * gradient[j]
+			}
+			coefficients[i] += step
+			change = math.Max(change, math.Abs(step))
+		}
+		if change < fitTolerance {
+			break
+		}
+	}
+
+	fitted := profile
+	fitted.Tests = maps.Clone(profile.Tests)
+	fitted.Bias = coefficients[0]
+	fitted.BiasStdErr = math.Sqrt(covariance[0][0])
+	for col, name := range names {
+		calibration := fitted.Tests[name]
+		calibration.Weight = coefficients[col+1]
+		calibration.WeightStdErr = math.Sqrt(covariance[col+1][col+1])
+		fitted.Tests[name] = calibration
+	}
+	return fitted, nil
+}
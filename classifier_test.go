@@ -0,0 +1,108 @@
+/*
+* Weighted classifier tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestFitClassifierWeights(t *testing.T) {
+	profile := Profile{
+		Name:              "test",
+		DecisionThreshold: 0.5,
+		Tests: map[string]TestCalibration{
+			EntropyTestName: {Threshold: 7.5, Scale: 0.1, Weight: 1},
+			ChiSqTestName:   {Threshold: 0.5, Scale: 0.25, Weight: 1},
+		},
+	}
+	rng := rand.New(rand.NewPCG(3, 4))
+	var samples []LabelledSample
+	for idx := range 40 {
+		encrypted := idx%2 == 0
+		entropy := 7.0 + rng.Float64()*0.4
+		if encrypted {
+			entropy = 7.6 + rng.Float64()*0.4
+		}
+		// The entropy separates the classes, the p-value carries no information
+		samples = append(samples, LabelledSample{
+			Statistics: map[string]float64{EntropyTestName: entropy, ChiSqTestName: rng.Float64()},
+			Encrypted:  encrypted,
+		})
+	}
+	// Left out of the fit, it misses one of the statistics
+	samples = append(samples, LabelledSample{Statistics: map[string]float64{EntropyTestName: 7.0}, Encrypted: true})
+
+	fitted, err := FitClassifierWeights(profile, samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entropyWeight, chiSqWeight := fitted.Tests[EntropyTestName].Weight, fitted.Tests[ChiSqTestName].Weight
+	if entropyWeight <= 0 || entropyWeight < 4*max(chiSqWeight, -chiSqWeight) {
+		t.Errorf("entropy weight %f, p-value weight %f: the separating test should dominate", entropyWeight, chiSqWeight)
+	}
+	if fitted.Tests[EntropyTestName].Threshold != 7.5 || fitted.Tests[EntropyTestName].Scale != 0.1 {
+		t.Errorf("threshold and scale changed: %+v", fitted.Tests[EntropyTestName])
+	}
+	for _, sample := range samples[:40] {
+		if classification := Classify(fitted, sample.Statistics); classification.Encrypted != sample.Encrypted {
+			t.Errorf("sample %v classified as encrypted=%v with probability %f", sample.Statistics, classification.Encrypted, classification.Probability)
+		}
+	}
+}
+
+func TestFitClassifierWeightsWithoutCompleteSamples(t *testing.T) {
+	profile := Profile{Tests: map[string]TestCalibration{
+		EntropyTestName: {Threshold: 7.5, Scale: 0.1},
+		ChiSqTestName:   {Threshold: 0.5, Scale: 0.25},
+	}}
+	samples := []LabelledSample{
+		{Statistics: map[string]float64{EntropyTestName: 7.9}, Encrypted: true},
+		{Statistics: map[string]float64{ChiSqTestName: 0.1}},
+	}
+	if _, err := FitClassifierWeights(profile, samples); err == nil {
+		t.Error("the fit without a sample having all the statistics succeeded")
+	}
+}
+
+func TestTestEvidence(t *testing.T) {
+	pValue := DefaultProfile().Tests[ChiSqTestName]
+	entropy := TestCalibration{Threshold: 7.5, Scale: 0.1}
+	tests := []struct {
+		name        string
+		calibration TestCalibration
+		statistic   float64
+		want        float64
+	}{
+		{EntropyTestName, entropy, 7.6, 1},
+		{EntropyTestName, entropy, 6.0, -maxEvidence},
+		{ChiSqTestName, pValue, pValue.Threshold, 0},
+		// A typical pass of random data and a clear rejection weigh alike
+		{ChiSqTestName, pValue, 0.5, 3.397940},
+		{ChiSqTestName, pValue, 1e-4, -maxEvidence},
+		{ChiSqTestName, pValue, 0, -maxEvidence},
+		{ChiSqTestName, pValue, 1, maxEvidence},
+	}
+	for _, test := range tests {
+		if evidence := testEvidence(test.name, test.calibration, test.statistic); math.Abs(evidence-test.want) > 1e-6 {
+			t.Errorf("%s at %g: evidence %f, want %f", test.name, test.statistic, evidence, test.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+* Console subcommands module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var consoleCommands = map[string]func(args []string) int{
+	"analyze":   runAnalyzeCommand,
+	"calibrate": runCalibrateCommand,
+	"generate":  runGenerateCommand,
+	"evaluate":  runEvaluateCommand,
+	"compare":   runCompareCommand,
+}
+
+// runConsoleCommand runs the subcommand named by the first argument,
+// handled is false when there is none and the GUI should be started
+func runConsoleCommand(args []string) (exitCode int, handled bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	command, ok := consoleCommands[args[0]]
+	if !ok {
+		return 0, false
+	}
+	return command(args[1:]), true
+}
+
+type analysisFlags struct {
+	options      AnalysisOptions
+	progressMode string
+}
+
+// addAnalysisFlags registers the options of the analysis shared by the subcommands
+func addAnalysisFlags(flags *flag.FlagSet) *analysisFlags {
+	analysis := &analysisFlags{options: DefaultAnalysisOptions()}
+	flags.IntVar(&analysis.options.BlockSize, "block-size", analysis.options.BlockSize, "розмір блоку читання, байтів")
+	flags.IntVar(&analysis.options.Workers, "workers", analysis.options.Workers, "кількість потоків обробки блоків (0 - усі ядра процесора)")
+	flags.DurationVar(&analysis.options.CheckpointInterval, "checkpoint-interval", analysis.options.CheckpointInterval, "інтервал збереження контрольних точок аналізу блоків (0 - без контрольних точок)")
+	flags.IntVar(&analysis.options.Sampling.Blocks, "sample", analysis.options.Sampling.Blocks, "кількість блоків вибірки для швидкого аналізу (0 - аналіз усього образу)")
+	flags.StringVar(&analysis.options.Sampling.Strategy, "sample-strategy", analysis.options.Sampling.Strategy, "спосіб вибірки: stratified (по блоку з кожної частини образу) або random")
+	flags.Uint64Var(&analysis.options.Sampling.Seed, "sample-seed", analysis.options.Sampling.Seed, "зерно генератора вибірки, те саме зерно дає ті самі блоки")
+	flags.BoolVar(&analysis.options.Sampling.Escalate, "escalate", analysis.options.Sampling.Escalate, "виконувати повний аналіз, коли результат вибірки на межі порогу")
+	flags.Func("compression", "алгоритми тесту стиснення через кому: flate, lz4, zstd (типово), bzip2, xz (повільні)", func(value string) error {
+		algorithms, err := ParseCompressionAlgorithms(value)
+		analysis.options.Compression.Algorithms = algorithms
+		return err
+	})
+	flags.IntVar(&analysis.options.Compression.Stride, "compression-stride", analysis.options.Compression.Stride, "стискати лише кожен N-й блок для швидшого тесту стиснення")
+	flags.Func("autocorr-lags", "лаги тесту автокореляції, окремі та діапазони через кому (типово 1-49)", func(value string) error {
+		lags, err := ParseLags(value)
+		analysis.options.Autocorrelation.Lags = lags
+		return err
+	})
+	flags.Func("sector-lags", "лаги секторів, що звітуються окремо від тесту автокореляції (типово 512,4096)", func(value string) error {
+		lags, err := ParseLags(value)
+		analysis.options.Autocorrelation.SectorLags = lags
+		return err
+	})
+	flags.Float64Var(&analysis.options.RandomnessSignificance, "significance", analysis.options.RandomnessSignificance, "рівень значущості тестів випадковості NIST SP 800-22")
+	flags.Float64Var(&analysis.options.ChiSqSignificance, "chisq-significance", analysis.options.ChiSqSignificance, "рівень значущості критерію Пірсона на Етапі 2 (0 - поріг профілю)")
+	flags.StringVar(&analysis.progressMode, "progress", "auto", "виведення прогресу у stderr: tty (рядок прогресу), json (події JSON по рядку), none або auto (tty для терміналу)")
+	return analysis
+}
+
+// Options returns the parsed analysis options with the requested progress output
+func (f *analysisFlags) Options() (AnalysisOptions, error) {
+	options := f.options
+	switch f.progressMode {
+	case "auto":
+		if isTerminal(os.Stderr) {
+			options.Progress = ttyProgress
+		}
+	case "tty":
+		options.Progress = ttyProgress
+	case "json":
+		options.Progress = jsonProgress(os.Stderr)
+	case "none":
+	default:
+		return options, fmt.Errorf("невідомий режим виведення прогресу %q", f.progressMode)
+	}
+	return options, nil
+}
+
+func isTerminal(file *os.File) bool {
+	stat, err := file.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// ttyProgress redraws a single progress line of the terminal
+func ttyProgress(progress Progress) {
+	fmt.Fprintf(os.Stderr, "\r\033[K%s", progress)
+	if progress.Done {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+type progressEvent struct {
+	Phase          string  `json:"phase"`
+	BytesDone      int64   `json:"bytes_done"`
+	BytesTotal     int64   `json:"bytes_total"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	ETASeconds     float64 `json:"eta_seconds"`
+	Done           bool    `json:"done"`
+}
+
+// jsonProgress writes every progress report as a JSON object on its own line
+func jsonProgress(writer io.Writer) ProgressFunc {
+	encoder := json.NewEncoder(writer)
+	var mutex sync.Mutex
+	return func(progress Progress) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		_ = encoder.Encode(progressEvent{
+			Phase:          progress.Phase,
+			BytesDone:      progress.BytesDone,
+			BytesTotal:     progress.BytesTotal,
+			BytesPerSecond: progress.Throughput,
+			ETASeconds:     progress.ETA.Seconds(),
+			Done:           progress.Done,
+		})
+	}
+}
+
+// commandContext is cancelled on Ctrl+C or SIGTERM, so that an interrupted subcommand cleans up after itself
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
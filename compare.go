@@ -0,0 +1,323 @@
+/*
+* Comparison of analysis results module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// TestComparison is one row of the comparison, the statistic is NaN for the side the test did not run on
+type TestComparison struct {
+	Name       string
+	Title      string
+	StatisticA float64
+	StatisticB float64
+	// Thresholds of the profiles the results were analysed with
+	ThresholdA float64
+	ThresholdB float64
+	VoteA      bool
+	VoteB      bool
+}
+
+// Delta is the change of the statistic from the first result to the second one
+func (c TestComparison) Delta() float64 {
+	return c.StatisticB - c.StatisticA
+}
+
+// VoteChanged reports whether the test voted differently on the two results
+func (c TestComparison) VoteChanged() bool {
+	return !math.IsNaN(c.Delta()) && c.VoteA != c.VoteB
+}
+
+// CountComparison is the number of findings of one kind in the two results
+type CountComparison struct {
+	Name string
+	A    int
+	B    int
+}
+
+func (c CountComparison) Delta() int {
+	return c.B - c.A
+}
+
+// ReportComparison puts two analysis reports side by side, the first one is the reference
+type ReportComparison struct {
+	A     AnalysisReport
+	B     AnalysisReport
+	Tests []TestComparison
+	// Signature types whose counts differ
+	Signatures []CountComparison
+	// Encryption tool headers whose counts differ
+	EncTools       []CountComparison
+	VerdictChanged bool
+}
+
+// reportThreshold returns the threshold of the test the report was analysed with,
+// the reports of the first version have none and take the one of the fallback profile
+func reportThreshold(report AnalysisReport, name string, fallback Profile) float64 {
+	if threshold, ok := report.Thresholds[name]; ok {
+		return threshold
+	}
+	return fallback.Tests[name].Threshold
+}
+
+// CompareReports compares the statistics of the tests in registry order,
+// every result votes at the thresholds of its own profile
+func CompareReports(a, b AnalysisReport, profile Profile) ReportComparison {
+	comparison := ReportComparison{
+		A:              a,
+		B:              b,
+		Signatures:     compareCounts(a.Result.SignatureCounts, b.Result.SignatureCounts),
+		EncTools:       compareCounts(a.Result.EncToolResult, b.Result.EncToolResult),
+		VerdictChanged: a.Result.Encryption != b.Result.Encryption,
+	}
+	for _, registration := range analyzerRegistry {
+		statisticA, okA := a.Result.Statistics[registration.name]
+		statisticB, okB := b.Result.Statistics[registration.name]
+		if !okA && !okB {
+			continue
+		}
+		row := TestComparison{
+			Name:       registration.name,
+			Title:      registration.title,
+			StatisticA: math.NaN(),
+			StatisticB: math.NaN(),
+			ThresholdA: reportThreshold(a, registration.name, profile),
+			ThresholdB: reportThreshold(b, registration.name, profile),
+		}
+		if okA {
+			row.StatisticA = statisticA
+			row.VoteA = registration.Vote(statisticA, row.ThresholdA)
+		}
+		if okB {
+			row.StatisticB = statisticB
+			row.VoteB = registration.Vote(statisticB, row.ThresholdB)
+		}
+		comparison.Tests = append(comparison.Tests, row)
+	}
+	return comparison
+}
+
+// compareCounts returns the kinds whose counts differ, the largest changes first
+func compareCounts(a, b map[string]int) []CountComparison {
+	var counts []CountComparison
+	for name, count := range a {
+		if b[name] != count {
+			counts = append(counts, CountComparison{Name: name, A: count, B: b[name]})
+		}
+	}
+	for name, count := range b {
+		if _, ok := a[name]; !ok && count != 0 {
+			counts = append(counts, CountComparison{Name: name, B: count})
+		}
+	}
+	slices.SortFunc(counts, func(x, y CountComparison) int {
+		if order := cmp.Compare(abs(y.Delta()), abs(x.Delta())); order != 0 {
+			return order
+		}
+		return cmp.Compare(x.Name, y.Name)
+	})
+	return counts
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+// formatComparedValue leaves the statistic of a test that did not run blank
+func formatComparedValue(value float64) string {
+	if math.IsNaN(value) {
+		return "—"
+	}
+	return fmt.Sprintf("%.6g", value)
+}
+
+func formatComparedDelta(value float64) string {
+	if math.IsNaN(value) {
+		return "—"
+	}
+	return fmt.Sprintf("%+.6g", value)
+}
+
+// VerdictNote describes the change of the verdict and of the probability of encryption
+func (c ReportComparison) VerdictNote() string {
+	verdictA, verdictB := encryptionNames[c.A.Result.Encryption], encryptionNames[c.B.Result.Encryption]
+	note := fmt.Sprintf("Вердикт не змінився: %s.", verdictA)
+	if c.VerdictChanged {
+		note = fmt.Sprintf("Вердикт змінився: %s → %s.", verdictA, verdictB)
+	}
+	if c.A.Result.Classification != nil && c.B.Result.Classification != nil {
+		probabilityA, probabilityB := c.A.Result.Classification.Probability, c.B.Result.Classification.Probability
+		note += fmt.Sprintf(" Ймовірність шифрування: %.4f → %.4f (%+.4f).", probabilityA, probabilityB, probabilityB-probabilityA)
+	}
+	return note
+}
+
+// Lines returns the text of the comparison for the console and the protocol
+func (c ReportComparison) Lines() []string {
+	lines := []string{
+		fmt.Sprintf("A: %s, %s, профіль %s, звіт %s", c.A.Result.FileName, c.A.Created.Format("2006-01-02 15:04:05"), c.A.Profile, c.A.ID),
+		fmt.Sprintf("B: %s, %s, профіль %s, звіт %s", c.B.Result.FileName, c.B.Created.Format("2006-01-02 15:04:05"), c.B.Profile, c.B.ID),
+		c.VerdictNote(),
+		"",
+		fmt.Sprintf("%-50s %14s %14s %14s", "Тест", "A", "B", "B - A"),
+	}
+	for _, row := range c.Tests {
+		line := fmt.Sprintf("%-50s %14s %14s %14s", row.Title, formatComparedValue(row.StatisticA), formatComparedValue(row.StatisticB), formatComparedDelta(row.Delta()))
+		if row.VoteChanged() {
+			line += "  голос тесту змінився"
+		}
+		if row.ThresholdA != row.ThresholdB {
+			line += fmt.Sprintf("  поріг %.6g → %.6g", row.ThresholdA, row.ThresholdB)
+		}
+		lines = append(lines, line)
+	}
+	if c.A.Result.FileSystem != c.B.Result.FileSystem {
+		lines = append(lines, "", fmt.Sprintf("Файлова система: %s → %s", c.A.Result.FileSystem, c.B.Result.FileSystem))
+	}
+	if len(c.EncTools) > 0 {
+		lines = append(lines, "", "Заголовки засобів шифрування, що відрізняються:")
+		for _, count := range c.EncTools {
+			lines = append(lines, fmt.Sprintf("  %-48s %14d %14d %+14d", count.Name, count.A, count.B, count.Delta()))
+		}
+	}
+	if c.A.Result.SignatureCounts == nil || c.B.Result.SignatureCounts == nil {
+		lines = append(lines, "", "Кількості сигнатур за типами є лише у звітах повного аналізу.")
+	} else {
+		lines = append(lines, "", fmt.Sprintf("Знайдено сигнатур: %d → %d (%+d).", sum(c.A.Result.SignatureCounts), sum(c.B.Result.SignatureCounts), sum(c.B.Result.SignatureCounts)-sum(c.A.Result.SignatureCounts)))
+		for _, count := range c.Signatures {
+			lines = append(lines, fmt.Sprintf("  %-48s %14d %14d %+14d", count.Name, count.A, count.B, count.Delta()))
+		}
+	}
+	return lines
+}
+
+// HistogramOverlayChart overlays the byte distributions of the two results,
+// normalised to fractions of their bytes since the images may differ in size
+func (c ReportComparison) HistogramOverlayChart() Chart {
+	chart := Chart{
+		Name:   "histogram_overlay",
+		Title:  "Порівняння гістограм значень байтів",
+		XLabel: "Значення байта",
+		YLabel: "Частка байтів",
+	}
+	for _, side := range []struct {
+		label  string
+		report AnalysisReport
+	}{{"A", c.A}, {"B", c.B}} {
+		if side.report.Result.Histogram == nil {
+			continue
+		}
+		var total int
+		for _, count := range side.report.Result.Histogram {
+			total += count
+		}
+		if total == 0 {
+			continue
+		}
+		x := make([]float64, 256)
+		y := make([]float64, 256)
+		for value, count := range side.report.Result.Histogram {
+			x[value] = float64(value)
+			y[value] = float64(count) / float64(total)
+		}
+		name := fmt.Sprintf("%s: %s", side.label, filepath.Base(side.report.Result.FileName))
+		chart.Series = append(chart.Series, ChartSeries{Name: name, X: x, Y: y, Step: true})
+	}
+	if len(chart.Series) > 0 {
+		chart.Series = append(chart.Series, ChartSeries{Name: "Рівномірний розподіл", X: []float64{0, 255}, Y: []float64{1.0 / 256, 1.0 / 256}})
+	}
+	return chart
+}
+
+// loadComparedReport reads a report file, or the report of the history entry with the ID
+func loadComparedReport(history *History, reference string) (AnalysisReport, error) {
+	if _, err := os.Stat(reference); err == nil {
+		return LoadAnalysisReport(reference)
+	}
+	if history == nil {
+		return AnalysisReport{}, fmt.Errorf("звіт %s не знайдено", reference)
+	}
+	entry, err := history.Find(reference)
+	if err != nil {
+		return AnalysisReport{}, err
+	}
+	return history.Load(entry)
+}
+
+func runCompareCommand(args []string) int {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	profileFile := flags.String("profile", defaultProfileFile, "профіль, за порогами якого голосують тести у звітах без власних порогів")
+	list := flags.Bool("list", false, "вивести аналізи журналу з їхніми ідентифікаторами")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Використання: compare [параметри] <звіт A> <звіт B>")
+		fmt.Fprintln(flags.Output(), "Звіт задається шляхом до JSON-файлу або ідентифікатором аналізу в журналі.")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	history, historyErr := OpenHistory()
+	if *list {
+		if historyErr != nil {
+			fmt.Printf("Не вдалося відкрити журнал аналізів: %s\n", historyErr)
+			return 1
+		}
+		entries, err := history.Entries()
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		for _, entry := range entries {
+			fmt.Printf("%s  %s  %-20s %s\n", entry.ID, entry.Created.Format("2006-01-02 15:04:05"), entry.Verdict, entry.FileName)
+		}
+		return 0
+	}
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	profile, err := LoadProfile(*profileFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("Не вдалося завантажити профіль, використано типовий: %s\n", err)
+	}
+
+	var reports [2]AnalysisReport
+	for idx, reference := range flags.Args() {
+		if reports[idx], err = loadComparedReport(history, reference); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+	for _, line := range CompareReports(reports[0], reports[1], profile).Lines() {
+		fmt.Println(line)
+	}
+	return 0
+}
@@ -0,0 +1,125 @@
+/*
+* Report comparison tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func comparedReport(statistics map[string]float64, thresholds map[string]float64, encryption int, signatures map[string]int) AnalysisReport {
+	return AnalysisReport{
+		Thresholds: thresholds,
+		Result: AnalysisResult{
+			FileName:        "image.img",
+			Statistics:      statistics,
+			Encryption:      encryption,
+			SignatureCounts: signatures,
+			EncToolResult:   map[string]int{"LUKSv1": 0},
+		},
+	}
+}
+
+func TestCompareReports(t *testing.T) {
+	profile := DefaultProfile()
+	entropyThreshold := profile.Tests[EntropyTestName].Threshold
+	a := comparedReport(
+		map[string]float64{EntropyTestName: 7.99, ChiSqTestName: 0.5, KsTestName: 0.3},
+		nil, FullDiskEncryption, map[string]int{"JPEG": 10, "PDF": 2})
+	b := comparedReport(
+		map[string]float64{EntropyTestName: 7.2, ChiSqTestName: 0.5, CompressionTestName: 1.5},
+		map[string]float64{ChiSqTestName: 0.6}, NoEncryption, map[string]int{"JPEG": 10, "PDF": 7, "ZIP": 1})
+
+	comparison := CompareReports(a, b, profile)
+	if !comparison.VerdictChanged {
+		t.Error("the verdict change is not reported")
+	}
+
+	rows := make(map[string]TestComparison)
+	var names []string
+	for _, row := range comparison.Tests {
+		rows[row.Name] = row
+		names = append(names, row.Name)
+	}
+	// The rows follow the registry and only cover the tests run on either image
+	if want := []string{KsTestName, ChiSqTestName, CompressionTestName, EntropyTestName}; !slices.Equal(names, want) {
+		t.Errorf("rows %v, want %v", names, want)
+	}
+	tests := []struct {
+		name                   string
+		delta                  float64
+		thresholdA, thresholdB float64
+		voteChanged            bool
+	}{
+		{EntropyTestName, 7.2 - 7.99, entropyThreshold, entropyThreshold, true},
+		// The same p-value votes differently at the threshold recorded in the second report
+		{ChiSqTestName, 0, profile.Tests[ChiSqTestName].Threshold, 0.6, true},
+		// A test missing on one side has no delta and no vote change
+		{KsTestName, math.NaN(), profile.Tests[KsTestName].Threshold, profile.Tests[KsTestName].Threshold, false},
+		{CompressionTestName, math.NaN(), profile.Tests[CompressionTestName].Threshold, profile.Tests[CompressionTestName].Threshold, false},
+	}
+	for _, test := range tests {
+		row := rows[test.name]
+		if delta := row.Delta(); math.IsNaN(delta) != math.IsNaN(test.delta) || math.Abs(delta-test.delta) > 1e-12 {
+			t.Errorf("%s: delta %f, want %f", test.name, delta, test.delta)
+		}
+		if row.ThresholdA != test.thresholdA || row.ThresholdB != test.thresholdB {
+			t.Errorf("%s: thresholds %f and %f, want %f and %f", test.name, row.ThresholdA, row.ThresholdB, test.thresholdA, test.thresholdB)
+		}
+		if row.VoteChanged() != test.voteChanged {
+			t.Errorf("%s: vote changed %v, want %v", test.name, row.VoteChanged(), test.voteChanged)
+		}
+	}
+
+	want := []CountComparison{{Name: "PDF", A: 2, B: 7}, {Name: "ZIP", B: 1}}
+	if !slices.Equal(comparison.Signatures, want) {
+		t.Errorf("signature changes %+v, want %+v", comparison.Signatures, want)
+	}
+	if len(comparison.EncTools) != 0 {
+		t.Errorf("encryption tool changes %+v, want none", comparison.EncTools)
+	}
+
+	lines := strings.Join(comparison.Lines(), "\n")
+	for _, fragment := range []string{"Вердикт змінився", "поріг 0.01 → 0.6", "Знайдено сигнатур: 12 → 18 (+6)."} {
+		if !strings.Contains(lines, fragment) {
+			t.Errorf("the comparison text has no %q:\n%s", fragment, lines)
+		}
+	}
+}
+
+func TestCompareCounts(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b map[string]int
+		want []CountComparison
+	}{
+		{"equal", map[string]int{"JPEG": 1}, map[string]int{"JPEG": 1}, nil},
+		{"new zero count", map[string]int{}, map[string]int{"JPEG": 0}, nil},
+		{"largest change first", map[string]int{"JPEG": 1, "PNG": 5}, map[string]int{"JPEG": 9, "PNG": 4, "GIF": 2},
+			[]CountComparison{{"JPEG", 1, 9}, {"GIF", 0, 2}, {"PNG", 5, 4}}},
+		{"removed kind", map[string]int{"ZIP": 3}, nil, []CountComparison{{"ZIP", 3, 0}}},
+	}
+	for _, test := range tests {
+		if got := compareCounts(test.a, test.b); !slices.Equal(got, test.want) {
+			t.Errorf("%s: %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
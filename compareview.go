@@ -0,0 +1,135 @@
+/*
+* Comparison view module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/mappu/miqt/qt"
+)
+
+var comparisonColumns = []string{"Тест", "A", "B", "B - A", "Голос A", "Голос B"}
+
+// ComparisonPanel shows two analysis results side by side
+type ComparisonPanel struct {
+	Widget     *qt.QWidget
+	title      *qt.QLabel
+	verdict    *qt.QLabel
+	table      *qt.QTableWidget
+	signatures *qt.QTableWidget
+	charts     *ChartPanel
+}
+
+func NewComparisonPanel(parent *qt.QWidget) *ComparisonPanel {
+	panel := &ComparisonPanel{Widget: qt.NewQWidget(parent)}
+	layout := qt.NewQVBoxLayout(panel.Widget)
+
+	panel.title = qt.NewQLabel3("Оберіть два аналізи в журналі та натисніть «Порівняти».")
+	panel.title.SetWordWrap(true)
+	panel.verdict = qt.NewQLabel2()
+	panel.verdict.SetWordWrap(true)
+
+	panel.table = qt.NewQTableWidget3(0, len(comparisonColumns))
+	panel.table.SetHorizontalHeaderLabels(comparisonColumns)
+	panel.table.SetEditTriggers(qt.QAbstractItemView__NoEditTriggers)
+	panel.table.HorizontalHeader().SetStretchLastSection(true)
+
+	panel.signatures = qt.NewQTableWidget3(0, 4)
+	panel.signatures.SetHorizontalHeaderLabels([]string{"Сигнатура", "A", "B", "B - A"})
+	panel.signatures.SetEditTriggers(qt.QAbstractItemView__NoEditTriggers)
+	panel.signatures.HorizontalHeader().SetStretchLastSection(true)
+
+	panel.charts = NewChartPanel(panel.Widget)
+
+	splitter := qt.NewQSplitter3(qt.Vertical)
+	splitter.AddWidget(panel.table.QWidget)
+	splitter.AddWidget(panel.signatures.QWidget)
+	splitter.AddWidget(panel.charts.Widget)
+	layout.AddWidget(panel.title.QWidget)
+	layout.AddWidget(panel.verdict.QWidget)
+	layout.AddWidget(splitter.QWidget)
+	return panel
+}
+
+func setComparisonRow(table *qt.QTableWidget, row int, cells []string) {
+	for column, text := range cells {
+		table.SetItem(row, column, qt.NewQTableWidgetItem2(text))
+	}
+}
+
+func voteName(vote bool, statistic float64) string {
+	if math.IsNaN(statistic) {
+		return "—"
+	}
+	if vote {
+		return "шифрування"
+	}
+	return "ні"
+}
+
+// SetComparison fills the panel, it runs on the Qt thread
+func (p *ComparisonPanel) SetComparison(comparison ReportComparison) {
+	p.title.SetText(fmt.Sprintf("A: %s (%s)\nB: %s (%s)",
+		comparison.A.Result.FileName, comparison.A.Created.Format("2006-01-02 15:04:05"),
+		comparison.B.Result.FileName, comparison.B.Created.Format("2006-01-02 15:04:05")))
+	p.verdict.SetText(comparison.VerdictNote())
+	if comparison.VerdictChanged {
+		p.verdict.SetStyleSheet("font-weight: bold; color: #d62728;")
+	} else {
+		p.verdict.SetStyleSheet("")
+	}
+
+	p.table.SetRowCount(len(comparison.Tests))
+	for row, test := range comparison.Tests {
+		setComparisonRow(p.table, row, []string{
+			test.Title,
+			formatComparedValue(test.StatisticA),
+			formatComparedValue(test.StatisticB),
+			formatComparedDelta(test.Delta()),
+			voteName(test.VoteA, test.StatisticA),
+			voteName(test.VoteB, test.StatisticB),
+		})
+		if test.VoteChanged() {
+			for column := range comparisonColumns {
+				p.table.Item(row, column).SetBackground(qt.NewQBrush3(qt.NewQColor3(255, 230, 200)))
+			}
+		}
+	}
+	p.table.ResizeColumnsToContents()
+
+	counts := append(append([]CountComparison{}, comparison.EncTools...), comparison.Signatures...)
+	p.signatures.SetRowCount(len(counts))
+	for row, count := range counts {
+		setComparisonRow(p.signatures, row, []string{
+			count.Name,
+			strconv.Itoa(count.A),
+			strconv.Itoa(count.B),
+			fmt.Sprintf("%+d", count.Delta()),
+		})
+	}
+	p.signatures.ResizeColumnsToContents()
+
+	var charts []Chart
+	if chart := comparison.HistogramOverlayChart(); !chart.Empty() {
+		charts = append(charts, chart)
+	}
+	p.charts.SetCharts(charts)
+}
@@ -19,69 +19,384 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	"os"
-	"os/exec"
+	"io"
+	"maps"
+	"math"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
 )
 
-func performCompression(execCommand string) float64 {
-	gzipOutput, err := exec.Command("bash", "-c", execCommand).CombinedOutput()
-	if err != nil {
-		fmt.Println(err)
+// compressionAlgorithms creates the streaming compressors by name, they stand in for
+// the pigz, lz4, lbzip2, zstd and pixz tools the test used to run
+var compressionAlgorithms = map[string]func(output io.Writer) (io.WriteCloser, error){
+	"flate": func(output io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(output, flate.DefaultCompression)
+	},
+	"lz4": func(output io.Writer) (io.WriteCloser, error) {
+		return lz4.NewWriter(output), nil
+	},
+	"bzip2": func(output io.Writer) (io.WriteCloser, error) {
+		return bzip2.NewWriter(output, &bzip2.WriterConfig{Level: bzip2.DefaultCompression})
+	},
+	"zstd": func(output io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(output, zstd.WithEncoderConcurrency(1))
+	},
+	"xz": func(output io.Writer) (io.WriteCloser, error) {
+		return xz.NewWriter(output)
+	},
+}
+
+// bzip2 and xz compress a few MB/s at most and would bound the throughput of the whole pass,
+// so they are only run on request. The default threshold of the test is set for these three,
+// see the registration of the test.
+var defaultCompressionAlgorithms = []string{"flate", "lz4", "zstd"}
+
+type CompressionOptions struct {
+	Algorithms []string
+	// Only every Stride-th block is compressed, all of them when it is 0 or 1
+	Stride int
+}
+
+func DefaultCompressionOptions() CompressionOptions {
+	return CompressionOptions{Algorithms: slices.Clone(defaultCompressionAlgorithms), Stride: 1}
+}
+
+func (o CompressionOptions) String() string {
+	return fmt.Sprintf("%s/%d", strings.Join(o.Algorithms, ","), max(o.Stride, 1))
+}
+
+// ParseCompressionAlgorithms checks a comma separated list of algorithm names
+func ParseCompressionAlgorithms(list string) ([]string, error) {
+	var algorithms []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := compressionAlgorithms[name]; !ok {
+			return nil, fmt.Errorf("невідомий алгоритм стиснення %q", name)
+		}
+		algorithms = append(algorithms, name)
+	}
+	if len(algorithms) == 0 {
+		return nil, fmt.Errorf("не вказано жодного алгоритму стиснення")
+	}
+	return algorithms, nil
+}
+
+type countingWriter struct {
+	count int
+}
+
+func (w *countingWriter) Write(data []byte) (int, error) {
+	w.count += len(data)
+	return len(data), nil
+}
+
+// Compressors that can start over on a new output are reused for every block
+type resetWriter interface {
+	Reset(output io.Writer)
+}
+
+// BlockRatio is the compression ratio of a block, Offset is the position of the block in the stream
+// (in the sampling mode, in the sample)
+type BlockRatio struct {
+	Offset int64
+	Ratio  float64
+}
+
+// Upper edges of the bins of the block ratio histogram, the last bin is open
+var blockRatioBins = []float64{1.05, 1.1, 1.25, 1.5, 2, 3, 5, 10}
+
+// Blocks below this ratio are counted as incompressible
+const incompressibleRatio = 1.05
+
+// BlockRatioHistogram counts the blocks in the blockRatioBins, it has one more bin than the edges
+func BlockRatioHistogram(ratios []BlockRatio) []int {
+	histogram := make([]int, len(blockRatioBins)+1)
+	for _, ratio := range ratios {
+		bin, onEdge := slices.BinarySearch(blockRatioBins, ratio.Ratio)
+		if onEdge {
+			bin++
+		}
+		histogram[bin]++
+	}
+	return histogram
+}
+
+func blockRatioHistogramToReadable(histogram []int) string {
+	var bins []string
+	low := "0"
+	for idx, count := range histogram {
+		high := "∞"
+		if idx < len(blockRatioBins) {
+			high = strconv.FormatFloat(blockRatioBins[idx], 'f', -1, 64)
+		}
+		bins = append(bins, fmt.Sprintf("[%s; %s) - %d", low, high, count))
+		low = high
 	}
+	return strings.Join(bins, ", ")
+}
 
-	gzipCompression, err := strconv.Atoi(strings.Split(string(gzipOutput), "\n")[0])
+// blockCompressor compresses every block on its own, so that the size of a block does not depend
+// on the history of the compressor
+type blockCompressor struct {
+	algorithm string
+	writer    io.WriteCloser
+	output    countingWriter
+	// Total output of the compressed blocks
+	compressed int
+	err        error
+}
+
+func newBlockCompressor(algorithm string) (*blockCompressor, error) {
+	compressor := &blockCompressor{algorithm: algorithm}
+	writer, err := compressionAlgorithms[algorithm](&compressor.output)
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
+	}
+	compressor.writer = writer
+	return compressor, nil
+}
+
+// compress returns the size of the compressed block, the compressor stays failed after an error
+func (c *blockCompressor) compress(block []byte) int {
+	c.output.count = 0
+	if resetter, ok := c.writer.(resetWriter); ok {
+		resetter.Reset(&c.output)
+	} else if c.writer, c.err = compressionAlgorithms[c.algorithm](&c.output); c.err != nil {
+		return 0
+	}
+	if _, c.err = c.writer.Write(block); c.err != nil {
+		return 0
 	}
-	return float64(gzipCompression)
+	if c.err = c.writer.Close(); c.err != nil {
+		return 0
+	}
+	c.compressed += c.output.count
+	return c.output.count
+}
+
+// CompressionSink compresses the blocks with every algorithm at once, counting the compressed bytes,
+// the result is the mean compression ratio over all of them
+type CompressionSink struct {
+	Options   CompressionOptions
+	BytesRead int
+	Ratios    map[string]float64
+	Result    float64
+	// Algorithms that failed, they are left out of the result
+	Errors map[string]error
+	// Mean ratio of every compressed block over the compressors
+	BlockRatios []BlockRatio
+	compressors []*blockCompressor
+	blockIndex  int
+	offset      int64
 }
 
-func checkCompressionToolAvailability() error {
-	tools := []string{"pigz", "lz4", "lbzip2", "zstd", "pixz"}
-	for _, tool := range tools {
-		_, err := exec.LookPath(tool)
+func NewCompressionSink(options CompressionOptions) (*CompressionSink, error) {
+	if len(options.Algorithms) == 0 {
+		return nil, fmt.Errorf("не вказано жодного алгоритму стиснення")
+	}
+
+	sink := &CompressionSink{Options: options, Ratios: make(map[string]float64), Errors: make(map[string]error), Result: math.NaN()}
+	for _, algorithm := range options.Algorithms {
+		if _, ok := compressionAlgorithms[algorithm]; !ok {
+			return nil, fmt.Errorf("невідомий алгоритм стиснення %q", algorithm)
+		}
+		compressor, err := newBlockCompressor(algorithm)
 		if err != nil {
-			return fmt.Errorf("Інструмент %s не знайдено в PATH", tool)
+			return nil, fmt.Errorf("не вдалося створити компресор %s: %v", algorithm, err)
 		}
+		sink.compressors = append(sink.compressors, compressor)
 	}
-	return nil
+	return sink, nil
 }
 
-func CompressionTest(fileName string) float64 {
+func (c *CompressionSink) Update(block []byte) {
+	c.blockIndex++
+	offset := c.offset
+	c.offset += int64(len(block))
+	if c.Options.Stride > 1 && (c.blockIndex-1)%c.Options.Stride != 0 {
+		return
+	}
+	c.BytesRead += len(block)
 
-	var CompressionTestToolErr error = checkCompressionToolAvailability()
-	if CompressionTestToolErr != nil {
-		fmt.Println(CompressionTestToolErr)
-		return 0.0
+	blockOutputs := make([]int, len(c.compressors))
+	var wg sync.WaitGroup
+	for idx, compressor := range c.compressors {
+		if compressor.err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(idx int, compressor *blockCompressor) {
+			defer wg.Done()
+			blockOutputs[idx] = compressor.compress(block)
+		}(idx, compressor)
 	}
+	wg.Wait()
 
-	var gzipCompression, lz4Compression, bz2Compression, zstdCompression, xzCompression float64
-	var gzipExec, lz4Exec, bz2Exec, zstdExec, xzExec string
+	var ratios []float64
+	for idx, compressor := range c.compressors {
+		if compressor.err == nil && blockOutputs[idx] > 0 {
+			ratios = append(ratios, float64(len(block))/float64(blockOutputs[idx]))
+		}
+	}
+	if len(ratios) > 0 {
+		c.BlockRatios = append(c.BlockRatios, BlockRatio{Offset: offset, Ratio: meanFloats(ratios)})
+	}
+}
+
+// IncompressibleFraction is the part of the blocks with a ratio below incompressibleRatio
+func (c *CompressionSink) IncompressibleFraction() float64 {
+	if len(c.BlockRatios) == 0 {
+		return math.NaN()
+	}
+	var incompressible int
+	for _, ratio := range c.BlockRatios {
+		if ratio.Ratio < incompressibleRatio {
+			incompressible++
+		}
+	}
+	return float64(incompressible) / float64(len(c.BlockRatios))
+}
+
+// Finalize computes the ratios, the result stays NaN when no algorithm produced one
+func (c *CompressionSink) Finalize() {
+	var ratios []float64
+	for _, compressor := range c.compressors {
+		if compressor.err != nil {
+			c.Errors[compressor.algorithm] = compressor.err
+			continue
+		}
+		if compressor.compressed == 0 || c.BytesRead == 0 {
+			continue
+		}
 
-	gzipExec = fmt.Sprintf("pigz < \"%s\" | wc -c", fileName)
-	lz4Exec = fmt.Sprintf("lz4 < \"%s\" | wc -c", fileName)
-	bz2Exec = fmt.Sprintf("lbzip2 < \"%s\" | wc -c", fileName)
-	zstdExec = fmt.Sprintf("zstd < \"%s\" | wc -c", fileName)
-	xzExec = fmt.Sprintf("pixz < \"%s\" | wc -c", fileName)
+		c.Ratios[compressor.algorithm] = float64(c.BytesRead) / float64(compressor.compressed)
+		ratios = append(ratios, c.Ratios[compressor.algorithm])
+	}
+
+	if len(ratios) > 0 {
+		c.Result = meanFloats(ratios)
+	}
+}
+
+type compressionState struct {
+	BytesRead       int            `json:"bytes_read"`
+	BlockIndex      int            `json:"block_index"`
+	Offset          int64          `json:"offset"`
+	CompressedBytes map[string]int `json:"compressed_bytes"`
+	BlockRatios     []BlockRatio   `json:"block_ratios"`
+}
 
-	stat, err := os.Stat(fileName)
+func (c *CompressionSink) SaveState() ([]byte, error) {
+	state := compressionState{
+		BytesRead:       c.BytesRead,
+		BlockIndex:      c.blockIndex,
+		Offset:          c.offset,
+		CompressedBytes: make(map[string]int),
+		BlockRatios:     c.BlockRatios,
+	}
+	for _, compressor := range c.compressors {
+		if compressor.err != nil {
+			return nil, fmt.Errorf("помилка стиснення %s: %v", compressor.algorithm, compressor.err)
+		}
+		state.CompressedBytes[compressor.algorithm] = compressor.compressed
+	}
+	return json.Marshal(state)
+}
+
+func (c *CompressionSink) RestoreState(state []byte) error {
+	var restored compressionState
+	if err := json.Unmarshal(state, &restored); err != nil {
+		return err
+	}
+	c.BytesRead, c.blockIndex, c.offset = restored.BytesRead, restored.BlockIndex, restored.Offset
+	c.BlockRatios = restored.BlockRatios
+	for _, compressor := range c.compressors {
+		compressor.compressed = restored.CompressedBytes[compressor.algorithm]
+	}
+	return nil
+}
+
+// compressionAnalyzer has no statistic (NaN) when the compressors cannot be created,
+// so that a missing test does not vote for encryption
+type compressionAnalyzer struct {
+	analyzerInfo
+	sink *CompressionSink
+	err  error
+	run  AnalyzerRun
+}
+
+func newCompressionAnalyzer(info analyzerInfo, run AnalyzerRun) Analyzer {
+	sink, err := NewCompressionSink(run.Options.Compression)
 	if err != nil {
-		fmt.Println(err)
-		return 0.0
+		run.errorf("Тест стиснення пропущено: %s", err)
 	}
+	return &compressionAnalyzer{analyzerInfo: info, sink: sink, err: err, run: run}
+}
 
-	fileSize := float64(stat.Size())
+func (c *compressionAnalyzer) Update(block []byte) {
+	if c.sink != nil {
+		c.sink.Update(block)
+	}
+}
 
-	gzipCompression = fileSize / performCompression(gzipExec)
-	lz4Compression = fileSize / performCompression(lz4Exec)
-	bz2Compression = fileSize / performCompression(bz2Exec)
-	zstdCompression = fileSize / performCompression(zstdExec)
-	xzCompression = fileSize / performCompression(xzExec)
+func (c *compressionAnalyzer) Finalize(counts *StreamCounts) float64 {
+	if c.sink == nil {
+		return math.NaN()
+	}
+	c.sink.Finalize()
+	for _, algorithm := range slices.Sorted(maps.Keys(c.sink.Errors)) {
+		c.run.errorf("Помилка стиснення %s: %s", algorithm, c.sink.Errors[algorithm])
+	}
+	return c.sink.Result
+}
 
-	avgCompression := (gzipCompression + lz4Compression + bz2Compression + zstdCompression + xzCompression) / 5.0
+func (c *compressionAnalyzer) SaveState() ([]byte, error) {
+	if c.sink == nil {
+		return nil, c.err
+	}
+	return c.sink.SaveState()
+}
+
+func (c *compressionAnalyzer) RestoreState(state []byte) error {
+	if c.sink == nil {
+		return c.err
+	}
+	return c.sink.RestoreState(state)
+}
 
-	return avgCompression
+func (c *compressionAnalyzer) Details() string {
+	if c.err != nil {
+		return c.err.Error()
+	}
+
+	var ratios []string
+	for _, algorithm := range c.sink.Options.Algorithms {
+		if ratio, ok := c.sink.Ratios[algorithm]; ok {
+			ratios = append(ratios, fmt.Sprintf("%s - %f", algorithm, ratio))
+		} else if err, ok := c.sink.Errors[algorithm]; ok {
+			ratios = append(ratios, fmt.Sprintf("%s - помилка: %s", algorithm, err))
+		}
+	}
+	text := "Коефіцієнти стиснення: " + strings.Join(ratios, ", ") + "."
+	if c.sink.Options.Stride > 1 {
+		text += fmt.Sprintf(" Стиснуто кожен %d-й блок, %d байтів.", c.sink.Options.Stride, c.sink.BytesRead)
+	}
+	if len(c.sink.BlockRatios) > 0 {
+		text += fmt.Sprintf(" Нестисливих блоків (коефіцієнт < %.2f): %.1f%% з %d. Розподіл коефіцієнтів блоків: %s.",
+			incompressibleRatio, 100*c.sink.IncompressibleFraction(), len(c.sink.BlockRatios), blockRatioHistogramToReadable(BlockRatioHistogram(c.sink.BlockRatios)))
+	}
+	return text
 }
@@ -0,0 +1,84 @@
+/*
+* Compression estimation tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestCompressionBlockRatios(t *testing.T) {
+	const blockSize = 65536
+	random := randomBytes(blockSize, 9)
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog "), blockSize/44+1)[:blockSize]
+	tests := []struct {
+		name   string
+		blocks [][]byte
+		// Range of the ratio of every block
+		minRatio, maxRatio float64
+	}{
+		// A repeated block compresses no better than the first one, bzip2 expands random data by 2%
+		{"identical random blocks", [][]byte{random, random}, 0.97, 1.0},
+		{"text", [][]byte{text, text}, 100, math.Inf(1)},
+	}
+	for _, algorithm := range []string{"flate", "lz4", "zstd", "bzip2", "xz"} {
+		for _, test := range tests {
+			sink, err := NewCompressionSink(CompressionOptions{Algorithms: []string{algorithm}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, block := range test.blocks {
+				sink.Update(block)
+			}
+			sink.Finalize()
+			if len(sink.BlockRatios) != len(test.blocks) {
+				t.Fatalf("%s, %s: %d block ratios, want %d", algorithm, test.name, len(sink.BlockRatios), len(test.blocks))
+			}
+			for idx, ratio := range sink.BlockRatios {
+				if ratio.Offset != int64(idx*blockSize) || ratio.Ratio < test.minRatio || ratio.Ratio > test.maxRatio {
+					t.Errorf("%s, %s: block %d at %d has ratio %f, want [%f; %f]", algorithm, test.name, idx, ratio.Offset, ratio.Ratio, test.minRatio, test.maxRatio)
+				}
+			}
+			if math.Abs(sink.Result-sink.BlockRatios[0].Ratio) > 1e-9 {
+				t.Errorf("%s, %s: ratio %f of the image, %f of its equal blocks", algorithm, test.name, sink.Result, sink.BlockRatios[0].Ratio)
+			}
+		}
+	}
+}
+
+func TestCompressionStride(t *testing.T) {
+	const blockSize = 4096
+	sink, err := NewCompressionSink(CompressionOptions{Algorithms: []string{"zstd"}, Stride: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx := range 7 {
+		sink.Update(randomBytes(blockSize, uint64(idx)))
+	}
+	sink.Finalize()
+	if sink.BytesRead != 3*blockSize || len(sink.BlockRatios) != 3 {
+		t.Fatalf("%d bytes in %d blocks compressed, want blocks 0, 3 and 6", sink.BytesRead, len(sink.BlockRatios))
+	}
+	for idx, ratio := range sink.BlockRatios {
+		if ratio.Offset != int64(3*idx*blockSize) {
+			t.Errorf("block ratio %d at %d, want %d", idx, ratio.Offset, 3*idx*blockSize)
+		}
+	}
+}
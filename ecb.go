@@ -0,0 +1,329 @@
+/*
+* Deterministic encryption mode detection module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// Smallest sector size, a duplicate within it points at ECB and one across them at a weak sector IV
+	duplicateSectorSize = 512
+	// Sampled cipher blocks kept for the search of duplicates across sectors, per block size
+	duplicateSetCapacity = 65536
+	// Share of duplicated blocks above which the duplicates are not a coincidence
+	duplicateFractionThreshold = 0.001
+	duplicateMinCount          = 8
+	// Share of random-looking blocks required, plaintext repeats mostly in structured data
+	randomBlockFractionThreshold = 0.9
+)
+
+// Cipher block sizes checked for duplicates, AES and the 64-bit ciphers (DES, Blowfish, GOST)
+var cipherBlockSizes = []int{16, 8}
+
+// randomLookingBlock reports whether a cipher block could be ciphertext: almost all of its bytes differ
+// and not all of them are text, so zero runs, padding and repeated strings are left out
+func randomLookingBlock(block []byte) bool {
+	var seen [256]bool
+	distinct, text := 0, true
+	for _, value := range block {
+		if !seen[value] {
+			seen[value] = true
+			distinct++
+		}
+		if (value < 0x20 || value > 0x7e) && value != '\t' && value != '\n' && value != '\r' {
+			text = false
+		}
+	}
+	return !text && distinct >= len(block)-len(block)/4
+}
+
+func mixHash(value uint64) uint64 {
+	value ^= value >> 30
+	value *= 0xbf58476d1ce4e5b9
+	value ^= value >> 27
+	value *= 0x94d049bb133111eb
+	return value ^ value>>31
+}
+
+func cipherBlockHash(block []byte) uint64 {
+	hash := mixHash(binary.LittleEndian.Uint64(block))
+	if len(block) > 8 {
+		hash = mixHash(hash ^ binary.LittleEndian.Uint64(block[8:]))
+	}
+	return hash
+}
+
+// DuplicateBlockCounts are the aligned cipher blocks of one size and their duplicates
+type DuplicateBlockCounts struct {
+	BlockSize    int
+	Blocks       int64
+	RandomBlocks int64
+	// Random-looking blocks repeating an earlier block of the same sector
+	WithinSector int64
+	// Estimated random-looking blocks repeating a block of another sector
+	AcrossSectors float64
+}
+
+func (c DuplicateBlockCounts) randomFraction() float64 {
+	if c.Blocks == 0 {
+		return 0
+	}
+	return float64(c.RandomBlocks) / float64(c.Blocks)
+}
+
+func duplicatesSignificant(count float64, blocks int64) bool {
+	return count >= duplicateMinCount && count/float64(blocks) >= duplicateFractionThreshold
+}
+
+func (c DuplicateBlockCounts) withinSignificant() bool {
+	return duplicatesSignificant(float64(c.WithinSector), c.RandomBlocks)
+}
+
+func (c DuplicateBlockCounts) acrossSignificant() bool {
+	return duplicatesSignificant(c.AcrossSectors, c.RandomBlocks)
+}
+
+// DeterministicModeResult tells whether the image is encrypted with a mode that maps equal plaintext
+// to equal ciphertext, which the byte-level tests cannot see
+type DeterministicModeResult struct {
+	Counts []DuplicateBlockCounts
+	// Likely cipher block sizes, the most likely first
+	CandidateBlockSizes []int
+	Detected            bool
+	// ECB when the blocks repeat within sectors, a weak sector IV when only whole sectors repeat
+	Mode string
+}
+
+func (r DeterministicModeResult) String() string {
+	var counts []string
+	for _, count := range r.Counts {
+		counts = append(counts, fmt.Sprintf("%d байтів: %d блоків, %.1f%% схожих на шифротекст, повторів у секторі %d, між секторами ~%.0f",
+			count.BlockSize, count.Blocks, 100*count.randomFraction(), count.WithinSector, count.AcrossSectors))
+	}
+	text := "Повтори блоків шифру (" + strings.Join(counts, "; ") + "). "
+	if !r.Detected {
+		return text + "Ознак детермінованого режиму шифрування не виявлено."
+	}
+	var sizes []string
+	for _, size := range r.CandidateBlockSizes {
+		sizes = append(sizes, fmt.Sprint(size))
+	}
+	return text + fmt.Sprintf("Виявлено шифрування детермінованим режимом (%s), ймовірний розмір блоку шифру %s байтів.", r.Mode, strings.Join(sizes, " або "))
+}
+
+type duplicateBlockPartial struct {
+	counts  []DuplicateBlockCounts
+	sectors int64
+	// First occurrences of the sampled random-looking blocks in each sector, per block size
+	sampled [][]sampledCipherBlock
+}
+
+type sampledCipherBlock struct {
+	Hash   uint64
+	Sector int64
+}
+
+// duplicateSet keeps the first sector of the sampled cipher blocks. A block is sampled by its hash,
+// so every copy of a sampled block is sampled too; the sampling halves when the set is full.
+type duplicateSet struct {
+	level   atomic.Uint32
+	sectors map[uint64]int64
+}
+
+func (s *duplicateSet) sampled(hash uint64, level uint32) bool {
+	return hash&(1<<level-1) == 0
+}
+
+// add records a block and returns the estimated number of duplicates across sectors it stands for
+func (s *duplicateSet) add(block sampledCipherBlock) float64 {
+	level := s.level.Load()
+	if !s.sampled(block.Hash, level) {
+		return 0
+	}
+	if sector, ok := s.sectors[block.Hash]; ok {
+		if sector != block.Sector {
+			return float64(uint64(1) << level)
+		}
+		return 0
+	}
+	s.sectors[block.Hash] = block.Sector
+	for len(s.sectors) > duplicateSetCapacity {
+		level++
+		s.level.Store(level)
+		for hash := range s.sectors {
+			if !s.sampled(hash, level) {
+				delete(s.sectors, hash)
+			}
+		}
+	}
+	return 0
+}
+
+// DuplicateBlockSink counts the repeated aligned cipher blocks of the stream
+type DuplicateBlockSink struct {
+	Counts  []DuplicateBlockCounts
+	Result  DeterministicModeResult
+	sectors int64
+	sets    []*duplicateSet
+}
+
+func NewDuplicateBlockSink() *DuplicateBlockSink {
+	sink := &DuplicateBlockSink{}
+	for _, size := range cipherBlockSizes {
+		sink.Counts = append(sink.Counts, DuplicateBlockCounts{BlockSize: size})
+		sink.sets = append(sink.sets, &duplicateSet{sectors: make(map[uint64]int64)})
+	}
+	return sink
+}
+
+func (d *DuplicateBlockSink) ProcessBlock(block []byte) any {
+	partial := duplicateBlockPartial{
+		counts:  make([]DuplicateBlockCounts, len(cipherBlockSizes)),
+		sectors: int64(len(block) / duplicateSectorSize),
+		sampled: make([][]sampledCipherBlock, len(cipherBlockSizes)),
+	}
+	hashes := make([]uint64, 0, duplicateSectorSize/8)
+	for idx, size := range cipherBlockSizes {
+		counts := &partial.counts[idx]
+		level := d.sets[idx].level.Load()
+		for sector := int64(0); sector < partial.sectors; sector++ {
+			data := block[sector*duplicateSectorSize : (sector+1)*duplicateSectorSize]
+			hashes = hashes[:0]
+			for start := 0; start < len(data); start += size {
+				counts.Blocks++
+				if randomLookingBlock(data[start : start+size]) {
+					hashes = append(hashes, cipherBlockHash(data[start:start+size]))
+				}
+			}
+			counts.RandomBlocks += int64(len(hashes))
+
+			slices.Sort(hashes)
+			for pos, hash := range hashes {
+				if pos > 0 && hash == hashes[pos-1] {
+					counts.WithinSector++
+				} else if d.sets[idx].sampled(hash, level) {
+					partial.sampled[idx] = append(partial.sampled[idx], sampledCipherBlock{Hash: hash, Sector: sector})
+				}
+			}
+		}
+	}
+	return partial
+}
+
+func (d *DuplicateBlockSink) Merge(partial any) {
+	blockResult, ok := partial.(duplicateBlockPartial)
+	if !ok {
+		return
+	}
+	for idx, counts := range blockResult.counts {
+		d.Counts[idx].Blocks += counts.Blocks
+		d.Counts[idx].RandomBlocks += counts.RandomBlocks
+		d.Counts[idx].WithinSector += counts.WithinSector
+		for _, block := range blockResult.sampled[idx] {
+			block.Sector += d.sectors
+			d.Counts[idx].AcrossSectors += d.sets[idx].add(block)
+		}
+	}
+	d.sectors += blockResult.sectors
+}
+
+func (d *DuplicateBlockSink) Update(block []byte) {
+	d.Merge(d.ProcessBlock(block))
+}
+
+func (d *DuplicateBlockSink) Finalize() {
+	d.Result = DeterministicModeResult{Counts: d.Counts}
+	for _, counts := range d.Counts {
+		if counts.randomFraction() < randomBlockFractionThreshold {
+			continue
+		}
+		if counts.withinSignificant() || counts.acrossSignificant() {
+			d.Result.CandidateBlockSizes = append(d.Result.CandidateBlockSizes, counts.BlockSize)
+		}
+	}
+
+	// The halves of a repeated 16-byte block repeat as well, so 8 bytes is only a candidate
+	// when its duplicates are well beyond those of the 16-byte blocks
+	if len(d.Result.CandidateBlockSizes) == 2 {
+		wide, narrow := d.Counts[0], d.Counts[1]
+		wideDuplicates := float64(wide.WithinSector) + wide.AcrossSectors
+		narrowDuplicates := float64(narrow.WithinSector) + narrow.AcrossSectors
+		if narrowDuplicates > 3*wideDuplicates {
+			d.Result.CandidateBlockSizes = []int{8, 16}
+		} else {
+			d.Result.CandidateBlockSizes = []int{16}
+		}
+	}
+	if len(d.Result.CandidateBlockSizes) == 0 {
+		return
+	}
+
+	d.Result.Detected = true
+	d.Result.Mode = "сталий вектор ініціалізації сектора"
+	for _, counts := range d.Counts {
+		if counts.BlockSize == d.Result.CandidateBlockSizes[0] && counts.withinSignificant() {
+			d.Result.Mode = "ECB"
+		}
+	}
+}
+
+type duplicateBlockState struct {
+	Counts  []DuplicateBlockCounts `json:"counts"`
+	Sectors int64                  `json:"sectors"`
+	Levels  []uint32               `json:"levels"`
+	Sampled [][]sampledCipherBlock `json:"sampled"`
+}
+
+func (d *DuplicateBlockSink) SaveState() ([]byte, error) {
+	state := duplicateBlockState{Counts: d.Counts, Sectors: d.sectors}
+	for _, set := range d.sets {
+		var sampled []sampledCipherBlock
+		for hash, sector := range set.sectors {
+			sampled = append(sampled, sampledCipherBlock{Hash: hash, Sector: sector})
+		}
+		state.Levels = append(state.Levels, set.level.Load())
+		state.Sampled = append(state.Sampled, sampled)
+	}
+	return json.Marshal(state)
+}
+
+func (d *DuplicateBlockSink) RestoreState(state []byte) error {
+	var restored duplicateBlockState
+	if err := json.Unmarshal(state, &restored); err != nil {
+		return err
+	}
+	if len(restored.Counts) != len(cipherBlockSizes) || len(restored.Levels) != len(d.sets) || len(restored.Sampled) != len(d.sets) {
+		return fmt.Errorf("контрольна точка має інші розміри блоків шифру")
+	}
+	d.Counts, d.sectors = restored.Counts, restored.Sectors
+	for idx, set := range d.sets {
+		set.level.Store(restored.Levels[idx])
+		set.sectors = make(map[uint64]int64, len(restored.Sampled[idx]))
+		for _, block := range restored.Sampled[idx] {
+			set.sectors[block.Hash] = block.Sector
+		}
+	}
+	return nil
+}
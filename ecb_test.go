@@ -0,0 +1,98 @@
+/*
+* Deterministic encryption mode tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// codebookImage builds sectors from the blocks of a small random codebook, as ECB encrypts
+// plaintext with few distinct blocks
+func codebookImage(sectors, blockSize, codebookSize int, seed uint64) []byte {
+	codebook := randomBytes(codebookSize*blockSize, seed)
+	rng := rand.New(rand.NewPCG(seed, 0))
+	image := make([]byte, 0, sectors*duplicateSectorSize)
+	for len(image) < cap(image) {
+		entry := rng.IntN(codebookSize)
+		image = append(image, codebook[entry*blockSize:(entry+1)*blockSize]...)
+	}
+	return image
+}
+
+// repeatedSectorImage repeats whole random sectors, as a constant sector IV encrypts equal sectors
+func repeatedSectorImage(sectors int, seed uint64) []byte {
+	image := randomBytes(sectors*duplicateSectorSize, seed)
+	for sector := 0; sector+1 < sectors; sector += 4 {
+		copy(image[(sector+1)*duplicateSectorSize:], image[:duplicateSectorSize])
+	}
+	return image
+}
+
+func TestDuplicateBlockSink(t *testing.T) {
+	text := bytes.Repeat([]byte("Lorem ipsum dolor sit amet, consectetur adipiscing. "), 20000)
+	tests := []struct {
+		name      string
+		image     []byte
+		detected  bool
+		mode      string
+		candidate []int
+	}{
+		{"random sectors", randomBytes(2048*duplicateSectorSize, 1), false, "", nil},
+		{"repeated plaintext", text, false, "", nil},
+		{"ECB with 16-byte blocks", codebookImage(2048, 16, 64, 2), true, "ECB", []int{16}},
+		{"ECB with 8-byte blocks", codebookImage(2048, 8, 1024, 3), true, "ECB", []int{8, 16}},
+		{"constant sector IV", repeatedSectorImage(2048, 4), true, "сталий вектор ініціалізації сектора", []int{16}},
+	}
+	for _, test := range tests {
+		sink := NewDuplicateBlockSink()
+		for start := 0; start < len(test.image); start += 65536 {
+			sink.Update(test.image[start:min(start+65536, len(test.image))])
+		}
+		sink.Finalize()
+		result := sink.Result
+		if result.Detected != test.detected || result.Mode != test.mode || !slices.Equal(result.CandidateBlockSizes, test.candidate) {
+			t.Errorf("%s: detected %v, mode %q, block sizes %v; want %v, %q, %v", test.name, result.Detected, result.Mode, result.CandidateBlockSizes, test.detected, test.mode, test.candidate)
+		}
+	}
+}
+
+// The sectors repeated across blocks are found however the stream is split, every copy of the first
+// sector repeats its 32 cipher blocks
+func TestDuplicateBlockSinkBlocks(t *testing.T) {
+	image := repeatedSectorImage(512, 5)
+	var counts [][]DuplicateBlockCounts
+	for _, blockSize := range []int{duplicateSectorSize, 8192, len(image)} {
+		sink := NewDuplicateBlockSink()
+		for start := 0; start < len(image); start += blockSize {
+			sink.Update(image[start:min(start+blockSize, len(image))])
+		}
+		counts = append(counts, sink.Counts)
+	}
+	for idx := 1; idx < len(counts); idx++ {
+		if !slices.Equal(counts[idx], counts[0]) {
+			t.Errorf("counts %+v differ from %+v", counts[idx], counts[0])
+		}
+	}
+	if counts[0][0].AcrossSectors != 128*32 {
+		t.Errorf("%f repeats across sectors, want %d", counts[0][0].AcrossSectors, 128*32)
+	}
+}
@@ -19,15 +19,92 @@
 package main
 
 import (
+	"encoding/json"
 	"math"
 )
 
-func EntropyEstimation(totalCounter map[byte]int, readBytesCount int) float64 {
+func EntropyEstimation(totalCounter ByteHistogram, readBytesCount int) float64 {
 	var p, entropy float64
 
-	for i := 0; i < 256; i++ {
-		p = float64(totalCounter[byte(i)]) / float64(readBytesCount)
+	for _, count := range totalCounter {
+		if count == 0 {
+			// 0 * log2(0) is taken as 0, otherwise a missing byte value turns the estimate into NaN
+			continue
+		}
+		p = float64(count) / float64(readBytesCount)
 		entropy += p * math.Log2(p)
 	}
 	return -entropy
 }
+
+// EntropyWindowSink computes the Shannon entropy of consecutive windows of the stream,
+// the last window may be shorter than the others
+type EntropyWindowSink struct {
+	WindowSize int
+	Entropies  []float64
+	counts     ByteHistogram
+	filled     int
+}
+
+func NewEntropyWindowSink(windowSize int) *EntropyWindowSink {
+	return &EntropyWindowSink{WindowSize: windowSize}
+}
+
+func (e *EntropyWindowSink) Update(block []byte) {
+	for len(block) > 0 {
+		chunk := block[:min(len(block), e.WindowSize-e.filled)]
+		for _, b := range chunk {
+			e.counts[b]++
+		}
+		e.filled += len(chunk)
+		block = block[len(chunk):]
+
+		if e.filled == e.WindowSize {
+			e.closeWindow()
+		}
+	}
+}
+
+func (e *EntropyWindowSink) closeWindow() {
+	e.Entropies = append(e.Entropies, EntropyEstimation(e.counts, e.filled))
+	e.counts = ByteHistogram{}
+	e.filled = 0
+}
+
+func (e *EntropyWindowSink) Finalize() {
+	if e.filled > 0 {
+		e.closeWindow()
+	}
+}
+
+type entropyAnalyzer struct {
+	analyzerInfo
+	histogramInput
+}
+
+func newEntropyAnalyzer(info analyzerInfo, run AnalyzerRun) Analyzer {
+	return &entropyAnalyzer{analyzerInfo: info}
+}
+
+func (e *entropyAnalyzer) Finalize(counts *StreamCounts) float64 {
+	return EntropyEstimation(counts.Histogram, counts.BytesRead)
+}
+
+type entropyWindowState struct {
+	Entropies []float64     `json:"entropies"`
+	Counts    ByteHistogram `json:"counts"`
+	Filled    int           `json:"filled"`
+}
+
+func (e *EntropyWindowSink) SaveState() ([]byte, error) {
+	return json.Marshal(entropyWindowState{Entropies: e.Entropies, Counts: e.counts, Filled: e.filled})
+}
+
+func (e *EntropyWindowSink) RestoreState(state []byte) error {
+	var restored entropyWindowState
+	if err := json.Unmarshal(state, &restored); err != nil {
+		return err
+	}
+	e.Entropies, e.counts, e.filled = restored.Entropies, restored.Counts, restored.Filled
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+* Method evaluation on labelled images module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+type ClassMetrics struct {
+	Precision float64
+	Recall    float64
+	F1        float64
+	Support   int
+}
+
+// computeClassMetrics derives per-class precision and recall from a confusion matrix
+// whose rows are the actual classes and columns the predicted ones
+func computeClassMetrics(confusion [3][3]int) [3]ClassMetrics {
+	var metrics [3]ClassMetrics
+	for class := range confusion {
+		var predicted, actual int
+		for other := range confusion {
+			predicted += confusion[other][class]
+			actual += confusion[class][other]
+		}
+
+		metrics[class].Support = actual
+		if predicted > 0 {
+			metrics[class].Precision = float64(confusion[class][class]) / float64(predicted)
+		}
+		if actual > 0 {
+			metrics[class].Recall = float64(confusion[class][class]) / float64(actual)
+		}
+		if sum := metrics[class].Precision + metrics[class].Recall; sum > 0 {
+			metrics[class].F1 = 2 * metrics[class].Precision * metrics[class].Recall / sum
+		}
+	}
+	return metrics
+}
+
+func printClassMetrics(metrics [3]ClassMetrics) {
+	fmt.Printf("  %-6s %9s %9s %9s %9s\n", "клас", "точність", "повнота", "F1", "образів")
+	for class, classMetrics := range metrics {
+		fmt.Printf("  %-6s %9.3f %9.3f %9.3f %9d\n", encryptionNames[class], classMetrics.Precision, classMetrics.Recall, classMetrics.F1, classMetrics.Support)
+	}
+}
+
+func runEvaluateCommand(args []string) int {
+	flags := flag.NewFlagSet("evaluate", flag.ExitOnError)
+	manifestFile := flags.String("manifest", "", "JSON-маніфест образів з мітками none/fde/fbe")
+	profileFile := flags.String("profile", defaultProfileFile, "профіль класифікатора")
+	analysis := addAnalysisFlags(flags)
+	_ = flags.Parse(args)
+
+	options, err := analysis.Options()
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+
+	if *manifestFile == "" {
+		fmt.Println("Не вказано маніфест (-manifest).")
+		return 2
+	}
+
+	entries, err := LoadManifest(*manifestFile)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	profile, err := LoadProfile(*profileFile)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Не вдалося завантажити профіль, використано типовий: %s\n", err)
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	errorLogger := log.New(os.Stderr, "", log.LstdFlags)
+	var confusion [3][3]int
+	for idx, entry := range entries {
+		result, err := AnalyzeImage(ctx, entry.Path, options, profile, errorLogger)
+		if err != nil {
+			fmt.Printf("Помилка аналізу %s: %s\n", entry.Path, err)
+			return 1
+		}
+		label := encryptionLabels[entry.Label]
+		confusion[label][result.Encryption]++
+
+		verdict := "вірно"
+		if label != result.Encryption {
+			verdict = "ПОМИЛКА"
+		}
+		fmt.Printf("[%d/%d] %s: мітка %s, результат %s (%s)\n", idx+1, len(entries), entry.Path, encryptionNames[label], encryptionNames[result.Encryption], verdict)
+		if result.Sampling != nil {
+			fmt.Println("    " + result.Sampling.String())
+		}
+		if result.DeterministicEncryption {
+			fmt.Println("    " + result.DeterministicMode.String())
+		}
+	}
+
+	fmt.Printf("\nПрофіль %s, %d образів\n", profile.Name, len(entries))
+	printClassConfusion(confusion)
+	fmt.Println()
+	printClassMetrics(computeClassMetrics(confusion))
+	return 0
+}
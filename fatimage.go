@@ -0,0 +1,212 @@
+/*
+* FAT16 image writer module (synthetic test images)
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	fatSectorSize      = 512
+	fatReservedSectors = 1
+	fatCount           = 2
+	fatRootEntries     = 512
+	fatDirEntrySize    = 32
+	fatMinClusters     = 4085
+	fatMaxClusters     = 65524
+	// 2024-01-01 00:00 in FAT date format
+	fatTimestampDate = (2024-1980)<<9 | 1<<5 | 1
+)
+
+type SyntheticFile struct {
+	// 8.3 name, for example FILE0001.TXT
+	Name    string
+	Content []byte
+}
+
+type fatGeometry struct {
+	totalSectors      int
+	sectorsPerCluster int
+	fatSectors        int
+	rootDirSectors    int
+	clusters          int
+}
+
+func (g fatGeometry) dataStart() int {
+	return fatReservedSectors + fatCount*g.fatSectors + g.rootDirSectors
+}
+
+func (g fatGeometry) clusterOffset(cluster int) int64 {
+	return int64(g.dataStart()+(cluster-2)*g.sectorsPerCluster) * fatSectorSize
+}
+
+// computeFATGeometry picks the smallest cluster size that keeps the cluster count within FAT16 limits
+func computeFATGeometry(size int64) (fatGeometry, error) {
+	geometry := fatGeometry{
+		totalSectors:   int(size / fatSectorSize),
+		rootDirSectors: fatRootEntries * fatDirEntrySize / fatSectorSize,
+	}
+
+	for geometry.sectorsPerCluster = 1; geometry.sectorsPerCluster <= 64; geometry.sectorsPerCluster *= 2 {
+		geometry.fatSectors = 1
+		for {
+			dataSectors := geometry.totalSectors - fatReservedSectors - fatCount*geometry.fatSectors - geometry.rootDirSectors
+			geometry.clusters = dataSectors / geometry.sectorsPerCluster
+			needed := ((geometry.clusters+2)*2 + fatSectorSize - 1) / fatSectorSize
+			if needed <= geometry.fatSectors {
+				break
+			}
+			geometry.fatSectors = needed
+		}
+		if geometry.clusters <= fatMaxClusters {
+			break
+		}
+	}
+
+	if geometry.clusters < fatMinClusters || geometry.clusters > fatMaxClusters {
+		return geometry, fmt.Errorf("розмір %d байтів не підходить для FAT16", size)
+	}
+	return geometry, nil
+}
+
+func fatShortName(name string) [11]byte {
+	var shortName [11]byte
+	for idx := range shortName {
+		shortName[idx] = ' '
+	}
+	base, extension, _ := strings.Cut(strings.ToUpper(name), ".")
+	copy(shortName[:8], base)
+	copy(shortName[8:], extension)
+	return shortName
+}
+
+func fatDirEntry(name [11]byte, attributes byte, firstCluster int, size int) []byte {
+	entry := make([]byte, fatDirEntrySize)
+	copy(entry, name[:])
+	entry[11] = attributes
+	binary.LittleEndian.PutUint16(entry[16:], fatTimestampDate)
+	binary.LittleEndian.PutUint16(entry[18:], fatTimestampDate)
+	binary.LittleEndian.PutUint16(entry[24:], fatTimestampDate)
+	binary.LittleEndian.PutUint16(entry[26:], uint16(firstCluster))
+	binary.LittleEndian.PutUint32(entry[28:], uint32(size))
+	return entry
+}
+
+func fatBootSector(geometry fatGeometry, volumeID uint32) []byte {
+	boot := make([]byte, fatSectorSize)
+	copy(boot, []byte{0xeb, 0x3c, 0x90})
+	copy(boot[3:], "MSWIN4.1")
+	binary.LittleEndian.PutUint16(boot[11:], fatSectorSize)
+	boot[13] = byte(geometry.sectorsPerCluster)
+	binary.LittleEndian.PutUint16(boot[14:], fatReservedSectors)
+	boot[16] = fatCount
+	binary.LittleEndian.PutUint16(boot[17:], fatRootEntries)
+	if geometry.totalSectors < 65536 {
+		binary.LittleEndian.PutUint16(boot[19:], uint16(geometry.totalSectors))
+	} else {
+		binary.LittleEndian.PutUint32(boot[32:], uint32(geometry.totalSectors))
+	}
+	boot[21] = 0xf8
+	binary.LittleEndian.PutUint16(boot[22:], uint16(geometry.fatSectors))
+	binary.LittleEndian.PutUint16(boot[24:], 32)
+	binary.LittleEndian.PutUint16(boot[26:], 64)
+	boot[36] = 0x80
+	boot[38] = 0x29
+	binary.LittleEndian.PutUint32(boot[39:], volumeID)
+	copy(boot[43:], "SYNTHETIC  ")
+	copy(boot[54:], "FAT16   ")
+	boot[510], boot[511] = 0x55, 0xaa
+	return boot
+}
+
+// WriteFAT16Image creates a FAT16 file system image of the given size with the files in its root directory
+func WriteFAT16Image(fileName string, size int64, files []SyntheticFile, volumeID uint32) (err error) {
+	geometry, err := computeFATGeometry(size)
+	if err != nil {
+		return err
+	}
+	if len(files) >= fatRootEntries {
+		return fmt.Errorf("забагато файлів для кореневого каталогу FAT16: %d", len(files))
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}(file)
+
+	if err := file.Truncate(size); err != nil {
+		return err
+	}
+
+	clusterSize := geometry.sectorsPerCluster * fatSectorSize
+	fat := make([]byte, geometry.fatSectors*fatSectorSize)
+	binary.LittleEndian.PutUint16(fat[0:], 0xfff8)
+	binary.LittleEndian.PutUint16(fat[2:], 0xffff)
+
+	rootDir := fatDirEntry(fatShortName("SYNTHETIC"), 0x08, 0, 0)
+	nextCluster := 2
+	for _, syntheticFile := range files {
+		clusterCount := (len(syntheticFile.Content) + clusterSize - 1) / clusterSize
+		if nextCluster+clusterCount-2 > geometry.clusters {
+			return fmt.Errorf("файли не вміщуються в образ %s", fileName)
+		}
+
+		firstCluster := 0
+		if clusterCount > 0 {
+			firstCluster = nextCluster
+		}
+		for idx := 0; idx < clusterCount; idx++ {
+			cluster := nextCluster + idx
+			link := uint16(cluster + 1)
+			if idx == clusterCount-1 {
+				link = 0xffff
+			}
+			binary.LittleEndian.PutUint16(fat[cluster*2:], link)
+		}
+
+		if clusterCount > 0 {
+			if _, err := file.WriteAt(syntheticFile.Content, geometry.clusterOffset(firstCluster)); err != nil {
+				return err
+			}
+		}
+		rootDir = append(rootDir, fatDirEntry(fatShortName(syntheticFile.Name), 0x20, firstCluster, len(syntheticFile.Content))...)
+		nextCluster += clusterCount
+	}
+
+	if _, err := file.WriteAt(fatBootSector(geometry, volumeID), 0); err != nil {
+		return err
+	}
+	for copyIdx := 0; copyIdx < fatCount; copyIdx++ {
+		offset := int64(fatReservedSectors+copyIdx*geometry.fatSectors) * fatSectorSize
+		if _, err := file.WriteAt(fat, offset); err != nil {
+			return err
+		}
+	}
+	rootDirOffset := int64(fatReservedSectors+fatCount*geometry.fatSectors) * fatSectorSize
+	_, err = file.WriteAt(rootDir, rootDirOffset)
+	return err
+}
@@ -0,0 +1,353 @@
+/*
+* Encrypted volume geometry inference module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	geometrySectorSize = 512
+	// Byte entropy of a 512-byte sector above which it is taken for ciphertext (random data gives about 7.6)
+	randomSectorEntropy = 7.0
+	// Boundaries needed before the encryption sector size is guessed from their alignment
+	geometryMinBoundaries = 4
+	largeSectorSize       = 4096
+	// Largest alignment of the encrypted area worth reporting
+	maxReportedAlignment = 1048576
+	// The LUKS2 binary header and the largest JSON area searched for the data offset
+	luksHeaderArea = 4096 + 65536
+)
+
+const (
+	zeroSector = iota
+	plainSector
+	randomSector
+)
+
+type volumeMagic struct {
+	name   string
+	offset int
+	magic  []byte
+}
+
+// Plaintext headers and metadata of the disk encryption tools, at their offsets within a sector
+var volumeMagics = []volumeMagic{
+	{"LUKS", 0, []byte("LUKS\xba\xbe")},
+	{"BitLocker", 3, []byte("-FVE-FS-")},
+	{"FreeBSD GELI", 0, []byte("GEOM::ELI")},
+	{"PGP WDE", 3, []byte("PGPGUARD")},
+}
+
+type foundMagic struct {
+	Name   string
+	Offset int64
+	// Start of the encrypted data from the header when the header gives it
+	DataOffset int64
+	// The header runs past the end of its block, its data offset is read from the image
+	truncated bool
+}
+
+var luks2SegmentOffset = regexp.MustCompile(`"segments"\s*:\s*\{\s*"0"\s*:\s*\{[^}]*"offset"\s*:\s*"(\d+)"`)
+
+// luksDataOffset reads the start of the encrypted data from a LUKS header, zero when it is not there
+func luksDataOffset(header []byte) int64 {
+	if len(header) < 112 {
+		return 0
+	}
+	switch binary.BigEndian.Uint16(header[6:]) {
+	case 1:
+		// payload-offset, in 512-byte sectors
+		return int64(binary.BigEndian.Uint32(header[104:])) * geometrySectorSize
+	case 2:
+		// The JSON area follows the 4096-byte binary header
+		jsonArea := header[min(len(header), 4096):min(len(header), 4096+65536)]
+		if match := luks2SegmentOffset.FindSubmatch(jsonArea); match != nil {
+			offset, _ := strconv.ParseInt(string(match[1]), 10, 64)
+			return offset
+		}
+	}
+	return 0
+}
+
+// readLuksDataOffset reads the LUKS header at the offset of the image, zero when it cannot be read
+func readLuksDataOffset(fileName string, offset int64) int64 {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	header := make([]byte, luksHeaderArea)
+	bytesRead, err := file.ReadAt(header, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0
+	}
+	return luksDataOffset(header[:bytesRead])
+}
+
+// VolumeGeometry describes the layout of an encrypted volume in the image
+type VolumeGeometry struct {
+	// Likely encryption sector size, zero when there was not enough evidence
+	SectorSize int
+	// Zero runs and repeated sectors inside the encrypted area, and how many of them are 4096-aligned
+	Boundaries        int
+	AlignedBoundaries int
+	// Bytes of the encrypted area, EncryptedEnd is exclusive
+	EncryptedStart int64
+	EncryptedEnd   int64
+	// Non-zero plaintext before and after the encrypted area
+	HeaderBytes  int64
+	TrailerBytes int64
+	HeaderFormat string
+	// Metadata at the end of the image, as with GELI
+	TrailerFormat string
+	// Plaintext sectors inside the encrypted area, a consistency check of the verdict
+	PlaintextInside int64
+}
+
+func (g VolumeGeometry) Found() bool {
+	return g.EncryptedEnd > g.EncryptedStart
+}
+
+// Alignment returns the largest power of two dividing the start of the encrypted area
+func (g VolumeGeometry) Alignment() int64 {
+	if g.EncryptedStart == 0 {
+		return maxReportedAlignment
+	}
+	return min(int64(1)<<bits.TrailingZeros64(uint64(g.EncryptedStart)), maxReportedAlignment)
+}
+
+func (g VolumeGeometry) String() string {
+	if !g.Found() {
+		return "Геометрія тому: зашифровану область не знайдено."
+	}
+
+	text := fmt.Sprintf("Геометрія тому: зашифрована область [%d; %d) байтів", g.EncryptedStart, g.EncryptedEnd)
+	if g.EncryptedStart == 0 {
+		text += ", з початку образу."
+	} else {
+		text += fmt.Sprintf(", початок вирівняно на %d байтів.", g.Alignment())
+	}
+	if g.SectorSize > 0 {
+		text += fmt.Sprintf(" Ймовірний розмір сектора шифрування %d байтів (%d з %d меж вирівняно на %d).", g.SectorSize, g.AlignedBoundaries, g.Boundaries, largeSectorSize)
+	} else {
+		text += fmt.Sprintf(" Розмір сектора шифрування не визначено, знайдено меж: %d.", g.Boundaries)
+	}
+
+	if g.HeaderBytes > 0 {
+		text += fmt.Sprintf(" Перед зашифрованою областю відкритий заголовок, %d байтів незашифрованих даних", g.HeaderBytes)
+		if g.HeaderFormat != "" {
+			text += " (" + g.HeaderFormat + ")"
+		}
+		text += "."
+	} else {
+		text += " Відкритого заголовка перед зашифрованою областю немає."
+	}
+	if g.TrailerBytes > 0 {
+		text += fmt.Sprintf(" Після зашифрованої області %d байтів незашифрованих метаданих", g.TrailerBytes)
+		if g.TrailerFormat != "" {
+			text += " (" + g.TrailerFormat + ")"
+		}
+		text += "."
+	}
+	if g.PlaintextInside > 0 {
+		text += fmt.Sprintf(" Усередині зашифрованої області %d незашифрованих секторів.", g.PlaintextInside)
+	}
+	return text
+}
+
+func classifySector(sector []byte) int {
+	if isEmptyBlock(sector) {
+		return zeroSector
+	}
+	if EntropyEstimation(countBytes(sector), len(sector)) >= randomSectorEntropy {
+		return randomSector
+	}
+	return plainSector
+}
+
+type geometryPartial struct {
+	classes []byte
+	// Hash of the first cipher block of every random sector, zero for the others
+	firstBlocks []uint64
+	magics      []foundMagic
+}
+
+// GeometrySink classifies the sectors of the image into zero, plaintext and random ones and collects
+// the boundaries inside the encrypted area: the ends of zero runs (discarded sectors) and the sectors
+// repeating an earlier one (weak sector IV). Their alignment points at the encryption sector size.
+type GeometrySink struct {
+	Result VolumeGeometry
+	// Image the blocks come from, the headers cut by the end of a block are read from it
+	fileName          string
+	sectors           int64
+	firstRandom       int64
+	lastRandom        int64
+	plainBeforeArea   int64
+	plainInsideArea   int64
+	plainSinceRandom  int64
+	previousClass     int
+	boundaries        int
+	alignedBoundaries int
+	magics            []foundMagic
+	repeated          *duplicateSet
+}
+
+func NewGeometrySink(fileName string) *GeometrySink {
+	return &GeometrySink{fileName: fileName, firstRandom: -1, lastRandom: -1, previousClass: plainSector, repeated: &duplicateSet{sectors: make(map[uint64]int64)}}
+}
+
+func (g *GeometrySink) ProcessBlock(block []byte) any {
+	sectors := len(block) / geometrySectorSize
+	partial := geometryPartial{classes: make([]byte, sectors), firstBlocks: make([]uint64, sectors)}
+	for idx := range sectors {
+		sector := block[idx*geometrySectorSize : (idx+1)*geometrySectorSize]
+		class := classifySector(sector)
+		for _, magic := range volumeMagics {
+			if !bytes.HasPrefix(sector[magic.offset:], magic.magic) {
+				continue
+			}
+			found := foundMagic{Name: magic.name, Offset: int64(idx * geometrySectorSize)}
+			if magic.name == "LUKS" {
+				header := block[idx*geometrySectorSize:]
+				found.DataOffset = luksDataOffset(header)
+				found.truncated = len(header) < luksHeaderArea
+			}
+			partial.magics = append(partial.magics, found)
+			// Metadata with key material may look random, it still belongs to the plaintext
+			class = plainSector
+		}
+		partial.classes[idx] = byte(class)
+		if class == randomSector && randomLookingBlock(sector[:16]) {
+			partial.firstBlocks[idx] = cipherBlockHash(sector[:16])
+		}
+	}
+	return partial
+}
+
+func (g *GeometrySink) addBoundary(sector int64) {
+	if g.firstRandom < 0 {
+		return
+	}
+	g.boundaries++
+	if (sector-g.firstRandom)*geometrySectorSize%largeSectorSize == 0 {
+		g.alignedBoundaries++
+	}
+}
+
+func (g *GeometrySink) Merge(partial any) {
+	blockResult, ok := partial.(geometryPartial)
+	if !ok {
+		return
+	}
+	for _, magic := range blockResult.magics {
+		magic.Offset += g.sectors * geometrySectorSize
+		g.magics = append(g.magics, magic)
+	}
+
+	for idx, class := range blockResult.classes {
+		sector := g.sectors + int64(idx)
+		switch int(class) {
+		case randomSector:
+			if g.firstRandom < 0 {
+				g.firstRandom = sector
+			}
+			g.lastRandom = sector
+			g.plainInsideArea += g.plainSinceRandom
+			g.plainSinceRandom = 0
+			if g.previousClass == zeroSector {
+				g.addBoundary(sector)
+			}
+			if hash := blockResult.firstBlocks[idx]; hash != 0 && g.repeated.add(sampledCipherBlock{Hash: hash, Sector: sector}) > 0 {
+				g.addBoundary(sector)
+			}
+		case zeroSector:
+			if g.previousClass == randomSector {
+				g.addBoundary(sector)
+			}
+		case plainSector:
+			if g.firstRandom < 0 {
+				g.plainBeforeArea++
+			} else {
+				g.plainSinceRandom++
+			}
+		}
+		g.previousClass = int(class)
+	}
+	g.sectors += int64(len(blockResult.classes))
+}
+
+func (g *GeometrySink) Update(block []byte) {
+	g.Merge(g.ProcessBlock(block))
+}
+
+func (g *GeometrySink) Finalize() {
+	if g.firstRandom < 0 {
+		return
+	}
+	g.Result = VolumeGeometry{
+		Boundaries:        g.boundaries,
+		AlignedBoundaries: g.alignedBoundaries,
+		EncryptedStart:    g.firstRandom * geometrySectorSize,
+		EncryptedEnd:      (g.lastRandom + 1) * geometrySectorSize,
+		HeaderBytes:       g.plainBeforeArea * geometrySectorSize,
+		TrailerBytes:      g.plainSinceRandom * geometrySectorSize,
+		PlaintextInside:   g.plainInsideArea,
+	}
+	if g.boundaries >= geometryMinBoundaries {
+		g.Result.SectorSize = geometrySectorSize
+		if g.alignedBoundaries == g.boundaries {
+			g.Result.SectorSize = largeSectorSize
+		}
+	}
+
+	for _, magic := range g.magics {
+		if magic.truncated && magic.DataOffset == 0 {
+			magic.DataOffset = readLuksDataOffset(g.fileName, magic.Offset)
+		}
+		if magic.DataOffset == 0 {
+			continue
+		}
+		// The header knows where its data starts, the key material before it only looks encrypted
+		if dataStart := magic.Offset + magic.DataOffset; dataStart > g.Result.EncryptedStart && dataStart < g.Result.EncryptedEnd {
+			g.Result.EncryptedStart = dataStart
+			g.Result.HeaderBytes = dataStart
+		}
+	}
+
+	var headerFormats, trailerFormats []string
+	for _, magic := range g.magics {
+		if magic.Offset < g.Result.EncryptedStart {
+			headerFormats = append(headerFormats, fmt.Sprintf("%s за зміщенням %d", magic.Name, magic.Offset))
+		} else if magic.Offset >= g.Result.EncryptedEnd {
+			trailerFormats = append(trailerFormats, fmt.Sprintf("%s за зміщенням %d", magic.Name, magic.Offset))
+		}
+	}
+	g.Result.HeaderFormat = strings.Join(headerFormats, ", ")
+	g.Result.TrailerFormat = strings.Join(trailerFormats, ", ")
+}
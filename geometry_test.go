@@ -0,0 +1,151 @@
+/*
+* Volume geometry tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// luks1Header returns a LUKS1 header sector with the payload offset in sectors
+func luks1Header(payloadSectors uint32) []byte {
+	header := make([]byte, geometrySectorSize)
+	copy(header, "LUKS\xba\xbe")
+	binary.BigEndian.PutUint16(header[6:], 1)
+	copy(header[8:], "aes")
+	binary.BigEndian.PutUint32(header[104:], payloadSectors)
+	return header
+}
+
+// luks2Header returns a LUKS2 binary header followed by its JSON area with the data segment at dataOffset
+func luks2Header(dataOffset string) []byte {
+	header := make([]byte, 16384)
+	copy(header, "LUKS\xba\xbe")
+	binary.BigEndian.PutUint16(header[6:], 2)
+	binary.BigEndian.PutUint64(header[8:], uint64(len(header)))
+	copy(header[4096:], `{"keyslots":{},"tokens":{},"segments":{"0":{"type":"crypt","offset":"`+dataOffset+`","size":"dynamic","iv_tweak":"0","encryption":"aes-xts-plain64","sector_size":4096}},"digests":{},"config":{}}`)
+	return header
+}
+
+// streamGeometry writes the image and feeds it to a geometry sink block by block
+func streamGeometry(t *testing.T, image []byte, blockSize int) VolumeGeometry {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), "volume.img")
+	if err := os.WriteFile(fileName, image, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sink := NewGeometrySink(fileName)
+	for start := 0; start < len(image); start += blockSize {
+		sink.Update(image[start:min(start+blockSize, len(image))])
+	}
+	sink.Finalize()
+	return sink.Result
+}
+
+func TestGeometryLuksDataOffset(t *testing.T) {
+	tests := []struct {
+		name string
+		// Position of the header and the start of the data from it
+		headerOffset, dataOffset int
+		header                   []byte
+		blockSize                int
+		wantHeader               string
+	}{
+		{"LUKS1", 0, 8 * geometrySectorSize, luks1Header(8), 1 << 20, "LUKS за зміщенням 0"},
+		{"LUKS2", 0, 32768, luks2Header("32768"), 1 << 20, "LUKS за зміщенням 0"},
+		// The header sits in the last sector of the first block, its JSON area is in the next one
+		{"LUKS2 in a partition", 7680, 32768, luks2Header("32768"), 8192, "LUKS за зміщенням 7680"},
+		{"LUKS1 at the end of a block", 3584, 8 * geometrySectorSize, luks1Header(8), 4096, "LUKS за зміщенням 3584"},
+	}
+	for _, test := range tests {
+		dataStart := test.headerOffset + test.dataOffset
+		image := make([]byte, dataStart+65536)
+		for idx := range image[:test.headerOffset] {
+			image[idx] = 'x'
+		}
+		// The key material between the header and the data looks random as well
+		copy(image[test.headerOffset+len(test.header):], randomBytes(len(image)-test.headerOffset-len(test.header), 1))
+		copy(image[test.headerOffset:], test.header)
+
+		geometry := streamGeometry(t, image, test.blockSize)
+		if geometry.EncryptedStart != int64(dataStart) || geometry.EncryptedEnd != int64(len(image)) {
+			t.Errorf("%s: encrypted area [%d; %d), want [%d; %d)", test.name, geometry.EncryptedStart, geometry.EncryptedEnd, dataStart, len(image))
+		}
+		if geometry.HeaderFormat != test.wantHeader {
+			t.Errorf("%s: header %q, want %q", test.name, geometry.HeaderFormat, test.wantHeader)
+		}
+	}
+}
+
+func TestGeometrySectorSize(t *testing.T) {
+	tests := []struct {
+		name string
+		// Sector offsets of the discarded (zeroed) 4096-byte runs inside the encrypted area
+		zeroRuns []int
+		want     int
+	}{
+		{"too few boundaries", []int{64}, 0},
+		{"4096-byte sectors", []int{64, 128, 256, 512}, largeSectorSize},
+		{"512-byte sectors", []int{64, 129, 257, 515}, geometrySectorSize},
+	}
+	for _, test := range tests {
+		image := randomBytes(1024*geometrySectorSize, 2)
+		for _, sector := range test.zeroRuns {
+			clear(image[sector*geometrySectorSize : sector*geometrySectorSize+largeSectorSize])
+		}
+		geometry := streamGeometry(t, image, 65536)
+		if geometry.SectorSize != test.want {
+			t.Errorf("%s: sector size %d (%d of %d boundaries aligned), want %d", test.name, geometry.SectorSize, geometry.AlignedBoundaries, geometry.Boundaries, test.want)
+		}
+		if geometry.Boundaries != 2*len(test.zeroRuns) {
+			t.Errorf("%s: %d boundaries, want %d", test.name, geometry.Boundaries, 2*len(test.zeroRuns))
+		}
+	}
+}
+
+func TestGeometryHeaderAndTrailer(t *testing.T) {
+	image := make([]byte, 256*geometrySectorSize)
+	for idx := range image[:16*geometrySectorSize] {
+		image[idx] = byte('a' + idx%26)
+	}
+	copy(image[16*geometrySectorSize:], randomBytes(200*geometrySectorSize, 3))
+	copy(image[len(image)-geometrySectorSize:], "GEOM::ELI")
+
+	geometry := streamGeometry(t, image, 65536)
+	tests := []struct {
+		name      string
+		got, want int64
+	}{
+		{"encrypted start", geometry.EncryptedStart, 16 * geometrySectorSize},
+		{"encrypted end", geometry.EncryptedEnd, 216 * geometrySectorSize},
+		{"header bytes", geometry.HeaderBytes, 16 * geometrySectorSize},
+		{"trailer bytes", geometry.TrailerBytes, geometrySectorSize},
+		{"alignment", geometry.Alignment(), 8192},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s %d, want %d", test.name, test.got, test.want)
+		}
+	}
+	if geometry.TrailerFormat != "FreeBSD GELI за зміщенням 130560" {
+		t.Errorf("trailer %q", geometry.TrailerFormat)
+	}
+}
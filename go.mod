@@ -4,6 +4,11 @@ go 1.25.0
 
 require (
 	github.com/BurntSushi/rure-go v0.0.0-20231211185014-8a0f52724b91
+	github.com/dsnet/compress v0.0.1
+	github.com/klauspost/compress v1.20.1
 	github.com/mappu/miqt v0.12.0
 	github.com/montanaflynn/stats v0.7.1
+	github.com/pierrec/lz4/v4 v4.1.31
+	github.com/ulikunitz/xz v0.5.17
+	golang.org/x/crypto v0.43.0
 )
@@ -0,0 +1,258 @@
+/*
+* Anderson-Darling and Cramér-von Mises goodness-of-fit tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+const (
+	// Grid of the tabulated p-values, the upper tail beyond the grid is below 1e-12
+	quadraticFormGridPoints = 2001
+	imhofIntegrandCutoff    = 1e-11
+)
+
+// cumulativeDeviations returns Z_j = S_j/n - j/256 for the first 255 cells of the byte histogram
+func cumulativeDeviations(histogram ByteHistogram, total int) []float64 {
+	deviations := make([]float64, len(histogram)-1)
+	var cumulative int
+	for idx := range deviations {
+		cumulative += histogram[idx]
+		deviations[idx] = float64(cumulative)/float64(total) - float64(idx+1)/float64(len(histogram))
+	}
+	return deviations
+}
+
+// quadraticFormStatistic returns n·Σ w_j Z_j², the discrete Cramér-von Mises family of Choulakian,
+// Lockhart and Stephens (1994)
+func quadraticFormStatistic(deviations []float64, weights []float64, total int) float64 {
+	var statistic float64
+	for idx, deviation := range deviations {
+		statistic += weights[idx] * deviation * deviation
+	}
+	return float64(total) * statistic
+}
+
+// cramerVonMisesWeights and andersonDarlingWeights are w_j for 256 equally likely byte values
+func cramerVonMisesWeights() []float64 {
+	weights := make([]float64, 255)
+	for idx := range weights {
+		weights[idx] = 1.0 / 256
+	}
+	return weights
+}
+
+func andersonDarlingWeights() []float64 {
+	weights := make([]float64, 255)
+	for idx := range weights {
+		cumulative := float64(idx+1) / 256
+		weights[idx] = 1.0 / 256 / (cumulative * (1 - cumulative))
+	}
+	return weights
+}
+
+// sturmCount returns the number of eigenvalues of the symmetric tridiagonal matrix below x
+func sturmCount(diagonal []float64, offDiagonal []float64, x float64) int {
+	count := 0
+	var q float64
+	for idx := range diagonal {
+		if idx == 0 {
+			q = diagonal[0] - x
+		} else {
+			if q == 0 {
+				q = specFuncEpsilon
+			}
+			q = diagonal[idx] - x - offDiagonal[idx-1]*offDiagonal[idx-1]/q
+		}
+		if q < 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// tridiagonalEigenvalues finds the eigenvalues of a symmetric tridiagonal matrix by Sturm bisection
+func tridiagonalEigenvalues(diagonal []float64, offDiagonal []float64) []float64 {
+	var low, high float64
+	for idx := range diagonal {
+		radius := 0.0
+		if idx > 0 {
+			radius += math.Abs(offDiagonal[idx-1])
+		}
+		if idx < len(offDiagonal) {
+			radius += math.Abs(offDiagonal[idx])
+		}
+		low = math.Min(low, diagonal[idx]-radius)
+		high = math.Max(high, diagonal[idx]+radius)
+	}
+
+	eigenvalues := make([]float64, len(diagonal))
+	for index := range eigenvalues {
+		left, right := low, high
+		for range 200 {
+			middle := (left + right) / 2
+			if middle == left || middle == right {
+				break
+			}
+			if sturmCount(diagonal, offDiagonal, middle) > index {
+				right = middle
+			} else {
+				left = middle
+			}
+		}
+		eigenvalues[index] = (left + right) / 2
+	}
+	return eigenvalues
+}
+
+// quadraticFormDistribution is the large-sample distribution of n·Σ w_j Z_j², a weighted sum
+// Σ λ_i χ²₁ whose upper tail is tabulated by Imhof's inversion of the characteristic function
+type quadraticFormDistribution struct {
+	Eigenvalues []float64
+	gridStep    float64
+	tails       []float64
+}
+
+// newQuadraticFormDistribution tabulates the tail up to maxStatistic. The covariance of the cumulative
+// deviations is that of a Brownian bridge at the cells, whose inverse is tridiagonal, so the weights λ_i
+// are the inverse eigenvalues of a tridiagonal matrix.
+func newQuadraticFormDistribution(weights []float64, maxStatistic float64) *quadraticFormDistribution {
+	cells := float64(len(weights) + 1)
+	diagonal := make([]float64, len(weights))
+	offDiagonal := make([]float64, len(weights)-1)
+	for idx, weight := range weights {
+		diagonal[idx] = 2 * cells / weight
+		if idx > 0 {
+			offDiagonal[idx-1] = -cells / math.Sqrt(weights[idx-1]*weight)
+		}
+	}
+	distribution := &quadraticFormDistribution{
+		gridStep: maxStatistic / (quadraticFormGridPoints - 1),
+		tails:    make([]float64, quadraticFormGridPoints),
+	}
+	var trace float64
+	for _, eigenvalue := range tridiagonalEigenvalues(diagonal, offDiagonal) {
+		distribution.Eigenvalues = append(distribution.Eigenvalues, 1/eigenvalue)
+		trace += 1 / eigenvalue
+	}
+
+	// P(Q > x) = 1/2 + 1/π ∫ sin(θ(u)) / (u ρ(u)) du, θ(u) = ½ Σ atan(λ u) − ½ x u, ρ(u) = Π (1 + λ²u²)^¼
+	integrals := make([]float64, quadraticFormGridPoints)
+	for idx := range integrals {
+		// Limit of the integrand at u = 0, weighted by one half for the trapezoid rule
+		integrals[idx] = (trace - float64(idx)*distribution.gridStep) / 4
+	}
+	step := 2 * math.Pi / ((trace + maxStatistic) / 2) / 20
+	for u := step; ; u += step {
+		var angle, logRho float64
+		for _, eigenvalue := range distribution.Eigenvalues {
+			angle += math.Atan(eigenvalue*u) / 2
+			logRho += math.Log1p(eigenvalue*eigenvalue*u*u) / 4
+		}
+		amplitude := math.Exp(-logRho) / u
+		if amplitude < imhofIntegrandCutoff {
+			break
+		}
+		// sin(θ) for every x of the grid, the angle turns by −½ Δx u from one x to the next
+		sine, cosine := math.Sincos(angle)
+		rotationSine, rotationCosine := math.Sincos(-distribution.gridStep * u / 2)
+		for idx := range integrals {
+			integrals[idx] += amplitude * sine
+			sine, cosine = sine*rotationCosine+cosine*rotationSine, cosine*rotationCosine-sine*rotationSine
+		}
+	}
+
+	tail := 1.0
+	for idx, integral := range integrals {
+		// The tail cannot grow with x, numerical noise far in the tail is cut off
+		tail = math.Max(0, math.Min(tail, 0.5+integral*step/math.Pi))
+		distribution.tails[idx] = tail
+	}
+	return distribution
+}
+
+// Tail returns P(Q > statistic), interpolated between the tabulated points
+func (d *quadraticFormDistribution) Tail(statistic float64) float64 {
+	position := statistic / d.gridStep
+	idx := int(position)
+	if idx >= len(d.tails)-1 {
+		return 0
+	}
+	if idx < 0 {
+		return 1
+	}
+	fraction := position - float64(idx)
+	return d.tails[idx]*(1-fraction) + d.tails[idx+1]*fraction
+}
+
+var (
+	cramerVonMisesDistribution = sync.OnceValue(func() *quadraticFormDistribution {
+		return newQuadraticFormDistribution(cramerVonMisesWeights(), 8)
+	})
+	andersonDarlingDistribution = sync.OnceValue(func() *quadraticFormDistribution {
+		return newQuadraticFormDistribution(andersonDarlingWeights(), 40)
+	})
+)
+
+// CramerVonMisesTest returns the statistic W² of the bytes against the discrete uniform distribution and its p-value
+func CramerVonMisesTest(histogram ByteHistogram, total int) (float64, float64) {
+	statistic := quadraticFormStatistic(cumulativeDeviations(histogram, total), cramerVonMisesWeights(), total)
+	return statistic, cramerVonMisesDistribution().Tail(statistic)
+}
+
+// AndersonDarlingTest returns the statistic A² of the bytes against the discrete uniform distribution and its p-value,
+// it weights the deviations in the tails of the distribution more than the Cramér-von Mises test
+func AndersonDarlingTest(histogram ByteHistogram, total int) (float64, float64) {
+	statistic := quadraticFormStatistic(cumulativeDeviations(histogram, total), andersonDarlingWeights(), total)
+	return statistic, andersonDarlingDistribution().Tail(statistic)
+}
+
+type goodnessOfFitAnalyzer struct {
+	analyzerInfo
+	histogramInput
+	test      func(histogram ByteHistogram, total int) (float64, float64)
+	symbol    string
+	statistic float64
+	bytesRead int
+}
+
+func newCramerVonMisesAnalyzer(info analyzerInfo, run AnalyzerRun) Analyzer {
+	return &goodnessOfFitAnalyzer{analyzerInfo: info, test: CramerVonMisesTest, symbol: "W²"}
+}
+
+func newAndersonDarlingAnalyzer(info analyzerInfo, run AnalyzerRun) Analyzer {
+	return &goodnessOfFitAnalyzer{analyzerInfo: info, test: AndersonDarlingTest, symbol: "A²"}
+}
+
+// Finalize returns the p-value, the statistic itself is reported in the details
+func (g *goodnessOfFitAnalyzer) Finalize(counts *StreamCounts) float64 {
+	g.bytesRead = counts.BytesRead
+	if g.bytesRead == 0 {
+		return math.NaN()
+	}
+	var pValue float64
+	g.statistic, pValue = g.test(counts.Histogram, counts.BytesRead)
+	return pValue
+}
+
+func (g *goodnessOfFitAnalyzer) Details() string {
+	return fmt.Sprintf("Статистика %s %f, прочитано %d байтів.", g.symbol, g.statistic, g.bytesRead)
+}
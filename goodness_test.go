@@ -0,0 +1,90 @@
+/*
+* Cramer-von Mises and Anderson-Darling test tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestQuadraticFormDistribution(t *testing.T) {
+	tests := []struct {
+		name         string
+		distribution *quadraticFormDistribution
+		// Σ λ_i is the mean of the statistic, Σ w_j t_j (1 - t_j) over the cells
+		trace float64
+		// Critical values of the continuous distribution, the 256 cells barely change them
+		critical005 float64
+		critical001 float64
+	}{
+		{"Cramér-von Mises", cramerVonMisesDistribution(), 255.0 * 257 / 6 / 256 / 256, 0.461, 0.743},
+		{"Anderson-Darling", andersonDarlingDistribution(), 255.0 / 256, 2.492, 3.857},
+	}
+	for _, test := range tests {
+		var trace float64
+		for _, eigenvalue := range test.distribution.Eigenvalues {
+			trace += eigenvalue
+		}
+		if math.Abs(trace-test.trace) > 1e-9 {
+			t.Errorf("%s: sum of the weights %f, want %f", test.name, trace, test.trace)
+		}
+		if tail := test.distribution.Tail(test.critical005); math.Abs(tail-0.05) > 0.002 {
+			t.Errorf("%s: tail at %g is %f, want 0.05", test.name, test.critical005, tail)
+		}
+		if tail := test.distribution.Tail(test.critical001); math.Abs(tail-0.01) > 0.001 {
+			t.Errorf("%s: tail at %g is %f, want 0.01", test.name, test.critical001, tail)
+		}
+	}
+}
+
+func TestGoodnessOfFitTests(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	var uniform, random, skewed ByteHistogram
+	for idx := range uniform {
+		uniform[idx] = 64
+	}
+	for range 1 << 20 {
+		random[rng.IntN(256)]++
+		// Low byte values are twice as likely as the high ones
+		skewed[rng.IntN(384)%256]++
+	}
+	tests := []struct {
+		name      string
+		histogram ByteHistogram
+		total     int
+		minP      float64
+		maxP      float64
+	}{
+		{"equal counts", uniform, 256 * 64, 0.999, 1},
+		{"uniform random bytes", random, 1 << 20, 0.001, 1},
+		{"skewed bytes", skewed, 1 << 20, 0, 1e-9},
+	}
+	for _, test := range tests {
+		for name, goodnessTest := range map[string]func(ByteHistogram, int) (float64, float64){
+			"Cramér-von Mises": CramerVonMisesTest,
+			"Anderson-Darling": AndersonDarlingTest,
+		} {
+			statistic, pValue := goodnessTest(test.histogram, test.total)
+			if pValue < test.minP || pValue > test.maxP {
+				t.Errorf("%s, %s: statistic %f, p-value %g outside [%g; %g]", name, test.name, statistic, pValue, test.minP, test.maxP)
+			}
+		}
+	}
+}
@@ -0,0 +1,284 @@
+/*
+* Hex viewer of the GUI module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mappu/miqt/qt"
+)
+
+const (
+	hexRowBytes = 16
+	// The scroll bar range is an int, beyond it a step covers several rows
+	maxHexScrollSteps = 1 << 30
+	hexViewPadding    = 6
+)
+
+// formatHexRow prints a row of the viewer: the offset, the bytes in hex and as ASCII
+func formatHexRow(offset int64, row []byte) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%012x  ", offset)
+	for idx := 0; idx < hexRowBytes; idx++ {
+		if idx == hexRowBytes/2 {
+			builder.WriteByte(' ')
+		}
+		if idx < len(row) {
+			fmt.Fprintf(&builder, "%02x ", row[idx])
+		} else {
+			builder.WriteString("   ")
+		}
+	}
+	builder.WriteString(" |")
+	for _, b := range row {
+		if b >= 0x20 && b < 0x7f {
+			builder.WriteByte(b)
+		} else {
+			builder.WriteByte('.')
+		}
+	}
+	builder.WriteByte('|')
+	return builder.String()
+}
+
+// hexByteColumns returns the character columns of a byte of the row in the hex and the ASCII parts
+func hexByteColumns(idx int) (hexColumn, asciiColumn int) {
+	hexColumn = 14 + 3*idx
+	if idx >= hexRowBytes/2 {
+		hexColumn++
+	}
+	return hexColumn, 14 + 3*hexRowBytes + 3 + idx
+}
+
+// parseOffset reads a decimal offset or a hexadecimal one with the 0x prefix or the h suffix
+func parseOffset(text string) (int64, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	var offset int64
+	var err error
+	if strings.HasPrefix(text, "0x") {
+		offset, err = strconv.ParseInt(text[2:], 16, 64)
+	} else if strings.HasSuffix(text, "h") {
+		offset, err = strconv.ParseInt(strings.TrimSuffix(text, "h"), 16, 64)
+	} else {
+		offset, err = strconv.ParseInt(text, 10, 64)
+	}
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("некоректне зміщення %q", text)
+	}
+	return offset, nil
+}
+
+// HexViewer shows a file in hex and ASCII, reading only the visible rows,
+// and jumps to the findings of the analysis with their bytes highlighted
+type HexViewer struct {
+	Widget        *qt.QWidget
+	fileLabel     *qt.QLabel
+	offsetEdit    *qt.QLineEdit
+	view          *qt.QWidget
+	scroll        *qt.QScrollBar
+	locationsList *qt.QListWidget
+	locations     []FileLocation
+
+	file     *os.File
+	fileName string
+	size     int64
+	// Offset of the first shown row
+	top         int64
+	rowsPerStep int64
+	// Highlighted bytes, the end is exclusive
+	highlightStart int64
+	highlightEnd   int64
+}
+
+func NewHexViewer(parent *qt.QWidget) *HexViewer {
+	v := &HexViewer{Widget: qt.NewQWidget(parent), rowsPerStep: 1}
+	layout := qt.NewQVBoxLayout(v.Widget)
+
+	toolbar := qt.NewQHBoxLayout2()
+	v.fileLabel = qt.NewQLabel3("Файл не відкрито")
+	v.offsetEdit = qt.NewQLineEdit(v.Widget)
+	v.offsetEdit.SetPlaceholderText("Зміщення (4096, 0x1000 або 1000h)")
+	jumpButton := qt.NewQPushButton4(qt.QIcon_FromTheme("go-jump"), "Перейти")
+	jumpToEdit := func() {
+		offset, err := parseOffset(v.offsetEdit.Text())
+		if err != nil {
+			errorWindow := qt.NewQErrorMessage(v.Widget)
+			errorWindow.ShowMessage(err.Error())
+			return
+		}
+		v.JumpTo(FileLocation{FileName: v.fileName, Offset: offset})
+	}
+	jumpButton.OnClicked(jumpToEdit)
+	v.offsetEdit.OnReturnPressed(jumpToEdit)
+	toolbar.AddWidget(v.fileLabel.QWidget)
+	toolbar.AddWidget(v.offsetEdit.QWidget)
+	toolbar.AddWidget(jumpButton.QWidget)
+
+	dump := qt.NewQWidget2()
+	dumpLayout := qt.NewQHBoxLayout(dump)
+	v.view = qt.NewQWidget2()
+	v.view.SetFont(qt.NewQFont2("monospace"))
+	v.view.SetMinimumWidth(2*hexViewPadding + v.view.FontMetrics().HorizontalAdvance(formatHexRow(0, make([]byte, hexRowBytes))))
+	v.view.SetMinimumHeight(200)
+	v.scroll = qt.NewQScrollBar3(qt.Vertical)
+	dumpLayout.AddWidget(v.view)
+	dumpLayout.AddWidget(v.scroll.QWidget)
+
+	v.locationsList = qt.NewQListWidget2()
+	v.locationsList.OnCurrentRowChanged(func(row int) {
+		if row >= 0 && row < len(v.locations) {
+			v.JumpTo(v.locations[row])
+		}
+	})
+
+	splitter := qt.NewQSplitter3(qt.Horizontal)
+	splitter.AddWidget(dump)
+	splitter.AddWidget(v.locationsList.QWidget)
+	layout.AddLayout(toolbar.QLayout)
+	layout.AddWidget(splitter.QWidget)
+
+	v.view.OnPaintEvent(func(super func(event *qt.QPaintEvent), event *qt.QPaintEvent) {
+		v.paint()
+	})
+	v.view.OnResizeEvent(func(super func(event *qt.QResizeEvent), event *qt.QResizeEvent) {
+		super(event)
+		v.updateScroll()
+	})
+	v.view.OnWheelEvent(func(super func(event *qt.QWheelEvent), event *qt.QWheelEvent) {
+		// Three rows per notch of the wheel
+		v.scrollTo(v.top - int64(event.AngleDelta().Y()/40)*hexRowBytes)
+	})
+	v.scroll.OnValueChanged(func(value int) {
+		// The jumps set the value as well, they keep their exact row
+		if int64(value) != v.top/hexRowBytes/v.rowsPerStep {
+			v.scrollTo(int64(value) * v.rowsPerStep * hexRowBytes)
+		}
+	})
+	return v
+}
+
+// Open shows the file from its start, the previous one is closed
+func (v *HexViewer) Open(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	fileStat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if v.file != nil {
+		v.file.Close()
+	}
+	v.file, v.fileName, v.size = file, fileName, fileStat.Size()
+	v.top, v.highlightStart, v.highlightEnd = 0, 0, 0
+	v.fileLabel.SetText(fmt.Sprintf("%s, %d байтів", fileName, v.size))
+	v.updateScroll()
+	v.view.Update()
+	return nil
+}
+
+// SetLocations replaces the list of the findings to jump to
+func (v *HexViewer) SetLocations(locations []FileLocation) {
+	v.locations = nil
+	v.locationsList.Clear()
+	for _, location := range locations {
+		v.locationsList.AddItem(location.String())
+	}
+	v.locations = locations
+}
+
+// JumpTo opens the file of the location if needed and shows its bytes highlighted a few rows from the top
+func (v *HexViewer) JumpTo(location FileLocation) {
+	if location.FileName != v.fileName || v.file == nil {
+		if err := v.Open(location.FileName); err != nil {
+			v.fileLabel.SetText(fmt.Sprintf("Не вдалося відкрити %s: %s", location.FileName, err))
+			return
+		}
+	}
+	v.highlightStart, v.highlightEnd = location.Offset, location.Offset+int64(max(1, location.Length))
+	v.offsetEdit.SetText(fmt.Sprintf("0x%x", location.Offset))
+	v.scrollTo(location.Offset - location.Offset%hexRowBytes - 2*hexRowBytes)
+}
+
+func (v *HexViewer) visibleRows() int {
+	return max(1, (v.view.Height()-2*hexViewPadding)/v.view.FontMetrics().Height())
+}
+
+// maxTop returns the offset of the first row when the last row of the file is at the bottom
+func (v *HexViewer) maxTop() int64 {
+	rows := (v.size + hexRowBytes - 1) / hexRowBytes
+	return max(0, rows-int64(v.visibleRows())) * hexRowBytes
+}
+
+func (v *HexViewer) updateScroll() {
+	maxRow := v.maxTop() / hexRowBytes
+	v.rowsPerStep = max(1, (maxRow+maxHexScrollSteps-1)/maxHexScrollSteps)
+	v.scroll.SetRange(0, int(maxRow/v.rowsPerStep))
+	v.scroll.SetPageStep(max(1, v.visibleRows()/int(v.rowsPerStep)))
+	v.scrollTo(v.top)
+}
+
+func (v *HexViewer) scrollTo(top int64) {
+	v.top = max(0, min(top-top%hexRowBytes, v.maxTop()))
+	v.scroll.SetValue(int(v.top / hexRowBytes / v.rowsPerStep))
+	v.view.Update()
+}
+
+func (v *HexViewer) paint() {
+	painter := qt.NewQPainter2(v.view.QPaintDevice)
+	defer painter.Delete()
+	defer painter.End()
+	painter.FillRect7(0, 0, v.view.Width(), v.view.Height(), qt.White)
+	if v.file == nil {
+		return
+	}
+
+	metrics := v.view.FontMetrics()
+	charWidth, rowHeight, ascent := metrics.HorizontalAdvance("0"), metrics.Height(), metrics.Ascent()
+	buffer := make([]byte, v.visibleRows()*hexRowBytes)
+	bytesRead, err := v.file.ReadAt(buffer, v.top)
+	painter.SetPen(qt.NewQColor2(qt.Black))
+	if err != nil && !errors.Is(err, io.EOF) {
+		painter.DrawText3(hexViewPadding, hexViewPadding+ascent, fmt.Sprintf("Помилка читання: %s", err))
+		return
+	}
+
+	highlight := qt.NewQColor3(255, 225, 110)
+	for start := 0; start < bytesRead; start += hexRowBytes {
+		offset := v.top + int64(start)
+		row := buffer[start:min(bytesRead, start+hexRowBytes)]
+		y := hexViewPadding + start/hexRowBytes*rowHeight
+		for idx := range row {
+			if position := offset + int64(idx); position >= v.highlightStart && position < v.highlightEnd {
+				hexColumn, asciiColumn := hexByteColumns(idx)
+				painter.FillRect5(hexViewPadding+hexColumn*charWidth, y, 2*charWidth, rowHeight, highlight)
+				painter.FillRect5(hexViewPadding+asciiColumn*charWidth, y, charWidth, rowHeight, highlight)
+			}
+		}
+		painter.DrawText3(hexViewPadding, y+ascent, formatHexRow(offset, row))
+	}
+}
@@ -0,0 +1,326 @@
+/*
+* Analysis history module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// Version 2 keeps the thresholds of the profile
+	reportVersion = 2
+	// Directory of the history in the user configuration directory
+	historyDirName = "masters_thesis_code"
+	// Index of the older versions, its entries are moved into historyEntriesDir
+	legacyIndexName   = "history.json"
+	historyEntriesDir = "entries"
+	historyReportsDir = "reports"
+	settingsName      = "settings.json"
+)
+
+// AnalysisReport is the structured report of an analysis, the history keeps one per analysis
+type AnalysisReport struct {
+	Version        int       `json:"version"`
+	ID             string    `json:"id"`
+	Created        time.Time `json:"created"`
+	ElapsedSeconds float64   `json:"elapsed_seconds"`
+	Profile        string    `json:"profile"`
+	// Thresholds of the tests in the profile of the analysis, the votes of the tests are taken at them
+	Thresholds map[string]float64 `json:"thresholds,omitempty"`
+	Verdict    string             `json:"verdict"`
+	Result     AnalysisResult     `json:"result"`
+}
+
+func NewAnalysisReport(result AnalysisResult, profile Profile, elapsed time.Duration) AnalysisReport {
+	created := time.Now()
+	profile = profile.WithOptions(result.Options)
+	thresholds := make(map[string]float64, len(profile.Tests))
+	for name, calibration := range profile.Tests {
+		thresholds[name] = calibration.Threshold
+	}
+	return AnalysisReport{
+		Version:        reportVersion,
+		ID:             created.Format("20060102-150405.000000"),
+		Created:        created,
+		ElapsedSeconds: elapsed.Seconds(),
+		Profile:        profile.Name,
+		Thresholds:     thresholds,
+		Verdict:        encryptionNames[result.Encryption],
+		Result:         result,
+	}
+}
+
+func (r AnalysisReport) Save(fileName string) error {
+	content, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fileName, content)
+}
+
+func LoadAnalysisReport(fileName string) (AnalysisReport, error) {
+	var report AnalysisReport
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return report, err
+	}
+	if err := json.Unmarshal(content, &report); err != nil {
+		return report, fmt.Errorf("не вдалося розібрати звіт %s: %v", fileName, err)
+	}
+	if report.Version > reportVersion {
+		return report, fmt.Errorf("звіт %s має новішу версію формату %d", fileName, report.Version)
+	}
+	return report, nil
+}
+
+// writeFileAtomic replaces the file through a temporary one, so that an interrupted write leaves the old content.
+// The temporary file has a unique name, the processes writing the same file do not mix their content.
+func writeFileAtomic(fileName string, content []byte) error {
+	temporary, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = temporary.Write(content)
+	if closeErr := temporary.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temporary.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(temporary.Name(), fileName)
+	}
+	if err != nil {
+		os.Remove(temporary.Name())
+	}
+	return err
+}
+
+// HistoryEntry is a record of the history index, the full result is in the report file
+type HistoryEntry struct {
+	ID             string          `json:"id"`
+	Created        time.Time       `json:"created"`
+	FileName       string          `json:"file_name"`
+	Options        AnalysisOptions `json:"options"`
+	Profile        string          `json:"profile"`
+	Verdict        string          `json:"verdict"`
+	Probability    *float64        `json:"probability,omitempty"`
+	ElapsedSeconds float64         `json:"elapsed_seconds"`
+	ReportFile     string          `json:"report_file"`
+}
+
+// History stores the reports of the analyses in the user configuration directory.
+// Every analysis has an entry file and a report file and there is no shared index,
+// so that the GUI and the console commands can record analyses at the same time.
+type History struct {
+	Dir string
+}
+
+// OpenHistory opens the history of the user, creating its directory when needed
+func OpenHistory() (*History, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	return openHistoryDir(filepath.Join(configDir, historyDirName))
+}
+
+func openHistoryDir(dir string) (*History, error) {
+	history := &History{Dir: dir}
+	for _, subdir := range []string{historyEntriesDir, historyReportsDir} {
+		if err := os.MkdirAll(filepath.Join(dir, subdir), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return history, history.migrateIndex()
+}
+
+// migrateIndex moves the entries of the index of the older versions into their own files
+func (h *History) migrateIndex() error {
+	indexFile := filepath.Join(h.Dir, legacyIndexName)
+	content, err := os.ReadFile(indexFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var entries []HistoryEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return fmt.Errorf("не вдалося розібрати журнал аналізів %s: %v", indexFile, err)
+	}
+	for _, entry := range entries {
+		if err := h.writeEntry(entry); err != nil {
+			return err
+		}
+	}
+	// Another process may have migrated the index first
+	if err := os.Remove(indexFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (h *History) entryFile(id string) string {
+	return filepath.Join(h.Dir, historyEntriesDir, id+".json")
+}
+
+func (h *History) writeEntry(entry HistoryEntry) error {
+	content, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(h.entryFile(entry.ID), content)
+}
+
+// Entries returns the recorded analyses from the oldest to the newest
+func (h *History) Entries() ([]HistoryEntry, error) {
+	files, err := os.ReadDir(filepath.Join(h.Dir, historyEntriesDir))
+	if err != nil {
+		return nil, err
+	}
+	var entries []HistoryEntry
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		fileName := filepath.Join(h.Dir, historyEntriesDir, file.Name())
+		content, err := os.ReadFile(fileName)
+		if errors.Is(err, os.ErrNotExist) {
+			// Removed by another process after the listing
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var entry HistoryEntry
+		if err := json.Unmarshal(content, &entry); err != nil {
+			return nil, fmt.Errorf("не вдалося розібрати запис журналу аналізів %s: %v", fileName, err)
+		}
+		entries = append(entries, entry)
+	}
+	slices.SortStableFunc(entries, func(a, b HistoryEntry) int {
+		return a.Created.Compare(b.Created)
+	})
+	return entries, nil
+}
+
+// Add saves the report and records its entry, the report is written first
+// so that an entry never points at a missing report
+func (h *History) Add(report AnalysisReport) (HistoryEntry, error) {
+	entry := HistoryEntry{
+		ID:             report.ID,
+		Created:        report.Created,
+		FileName:       report.Result.FileName,
+		Options:        report.Result.Options,
+		Profile:        report.Profile,
+		Verdict:        report.Verdict,
+		ElapsedSeconds: report.ElapsedSeconds,
+		ReportFile:     filepath.Join(historyReportsDir, report.ID+".json"),
+	}
+	if report.Result.Classification != nil {
+		entry.Probability = &report.Result.Classification.Probability
+	}
+	if err := report.Save(filepath.Join(h.Dir, entry.ReportFile)); err != nil {
+		return entry, err
+	}
+	return entry, h.writeEntry(entry)
+}
+
+// ReportPath returns the report file of the entry
+func (h *History) ReportPath(entry HistoryEntry) string {
+	return filepath.Join(h.Dir, entry.ReportFile)
+}
+
+// Load reads the full report of the entry
+func (h *History) Load(entry HistoryEntry) (AnalysisReport, error) {
+	return LoadAnalysisReport(h.ReportPath(entry))
+}
+
+// Find returns the entry with the ID
+func (h *History) Find(id string) (HistoryEntry, error) {
+	entries, err := h.Entries()
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	idx := slices.IndexFunc(entries, func(entry HistoryEntry) bool {
+		return entry.ID == id
+	})
+	if idx < 0 {
+		return HistoryEntry{}, fmt.Errorf("аналіз %s не знайдено в журналі", id)
+	}
+	return entries[idx], nil
+}
+
+// Remove deletes the entry and its report, the entry first so that it never points at a missing report
+func (h *History) Remove(id string) error {
+	content, err := os.ReadFile(h.entryFile(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var entry HistoryEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return fmt.Errorf("не вдалося розібрати запис журналу аналізів %s: %v", h.entryFile(id), err)
+	}
+	if err := os.Remove(h.entryFile(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(filepath.Join(h.Dir, entry.ReportFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// GUISettings are the fields of the GUI restored at the next start
+type GUISettings struct {
+	FileName     string  `json:"file_name"`
+	OutputDir    string  `json:"output_dir"`
+	Workers      int     `json:"workers"`
+	Sample       int     `json:"sample"`
+	Significance float64 `json:"significance,omitempty"`
+	// Significance of the chi-squared test, zero for the threshold of the profile
+	ChiSqSignificance float64 `json:"chi_squared_significance,omitempty"`
+}
+
+// LoadSettings reads the GUI settings, a missing file gives zero values
+func (h *History) LoadSettings() (GUISettings, error) {
+	var settings GUISettings
+	content, err := os.ReadFile(filepath.Join(h.Dir, settingsName))
+	if errors.Is(err, os.ErrNotExist) {
+		return settings, nil
+	} else if err != nil {
+		return settings, err
+	}
+	return settings, json.Unmarshal(content, &settings)
+}
+
+func (h *History) SaveSettings(settings GUISettings) error {
+	content, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(h.Dir, settingsName), content)
+}
@@ -0,0 +1,121 @@
+/*
+* Analysis history tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testReport(fileName string, created time.Time, encryption int) AnalysisReport {
+	result := AnalysisResult{FileName: fileName, Options: DefaultAnalysisOptions(), Statistics: map[string]float64{EntropyTestName: 7.99}, Encryption: encryption}
+	report := NewAnalysisReport(result, DefaultProfile(), time.Second)
+	report.ID = created.Format("20060102-150405.000000")
+	report.Created = created
+	return report
+}
+
+func TestHistoryAddRemove(t *testing.T) {
+	history, err := openHistoryDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	reports := []AnalysisReport{
+		testReport("second.img", start.Add(time.Minute), NoEncryption),
+		testReport("first.img", start, FullDiskEncryption),
+	}
+	for _, report := range reports {
+		if _, err := history.Add(report); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := history.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].FileName != "first.img" || entries[1].FileName != "second.img" {
+		t.Fatalf("entries %+v, want first.img and second.img from the oldest", entries)
+	}
+	if entries[0].Verdict != encryptionNames[FullDiskEncryption] {
+		t.Errorf("verdict %q, want %q", entries[0].Verdict, encryptionNames[FullDiskEncryption])
+	}
+
+	entry, err := history.Find(reports[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := history.Load(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Result.Statistics[EntropyTestName] != 7.99 || loaded.Thresholds[EntropyTestName] != DefaultProfile().Tests[EntropyTestName].Threshold {
+		t.Errorf("loaded report %+v differs from the saved one", loaded)
+	}
+
+	if err := history.Remove(reports[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(history.ReportPath(entry)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the report of the removed entry is left: %v", err)
+	}
+	if _, err := history.Find(reports[1].ID); err == nil {
+		t.Error("the removed entry is still found")
+	}
+	if entries, _ := history.Entries(); len(entries) != 1 || entries[0].ID != reports[0].ID {
+		t.Errorf("entries %+v after the removal", entries)
+	}
+	if err := history.Remove("missing"); err != nil {
+		t.Errorf("removing a missing entry: %v", err)
+	}
+}
+
+func TestHistoryLegacyIndex(t *testing.T) {
+	dir := t.TempDir()
+	legacy := []HistoryEntry{
+		{ID: "20250501-120000.000000", Created: time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC), FileName: "old.img", ReportFile: filepath.Join(historyReportsDir, "20250501-120000.000000.json")},
+	}
+	content, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, legacyIndexName), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := openHistoryDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := history.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].FileName != "old.img" {
+		t.Errorf("entries %+v, want the one of the old index", entries)
+	}
+	if _, err := os.Stat(filepath.Join(dir, legacyIndexName)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the old index is left: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+* Analysis history panel of the GUI module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/mappu/miqt/qt"
+)
+
+var historyColumns = []string{"Дата", "Образ", "Вердикт", "Ймовірність", "Профіль", "Тривалість", "Параметри"}
+
+// HistoryPanel lists the recorded analyses, the newest first
+type HistoryPanel struct {
+	Widget  *qt.QWidget
+	history *History
+	table   *qt.QTableWidget
+	status  *qt.QLabel
+	entries []HistoryEntry
+	// Called with the report the user opens, may be nil
+	OnOpen func(report AnalysisReport)
+	// Called with the analysis the user runs again, may be nil
+	OnRerun func(entry HistoryEntry)
+	// Called with the two reports the user compares, the older one first, may be nil
+	OnCompare func(a, b AnalysisReport)
+}
+
+func NewHistoryPanel(parent *qt.QWidget, history *History) *HistoryPanel {
+	panel := &HistoryPanel{Widget: qt.NewQWidget(parent), history: history}
+	layout := qt.NewQVBoxLayout(panel.Widget)
+
+	panel.table = qt.NewQTableWidget3(0, len(historyColumns))
+	panel.table.SetHorizontalHeaderLabels(historyColumns)
+	panel.table.SetSelectionBehavior(qt.QAbstractItemView__SelectRows)
+	panel.table.SetEditTriggers(qt.QAbstractItemView__NoEditTriggers)
+	panel.table.HorizontalHeader().SetStretchLastSection(true)
+	panel.table.OnCellDoubleClicked(func(row int, column int) {
+		panel.open()
+	})
+
+	openButton := qt.NewQPushButton4(qt.QIcon_FromTheme("document-open"), "Відкрити")
+	openButton.OnClicked(panel.open)
+	rerunButton := qt.NewQPushButton4(qt.QIcon_FromTheme("view-refresh"), "Повторити аналіз")
+	rerunButton.OnClicked(func() {
+		for _, entry := range panel.selected() {
+			if panel.OnRerun != nil {
+				panel.OnRerun(entry)
+			}
+		}
+	})
+	compareButton := qt.NewQPushButton4(qt.QIcon_FromTheme("edit-find"), "Порівняти")
+	compareButton.OnClicked(panel.compare)
+	removeButton := qt.NewQPushButton4(qt.QIcon_FromTheme("edit-delete"), "Видалити із журналу")
+	removeButton.OnClicked(func() {
+		for _, entry := range panel.selected() {
+			if err := history.Remove(entry.ID); err != nil {
+				panel.status.SetText(fmt.Sprintf("Не вдалося видалити запис: %s", err))
+			}
+		}
+		panel.Refresh()
+	})
+
+	buttons := qt.NewQHBoxLayout2()
+	buttons.AddWidget(openButton.QWidget)
+	buttons.AddWidget(rerunButton.QWidget)
+	buttons.AddWidget(compareButton.QWidget)
+	buttons.AddWidget(removeButton.QWidget)
+	buttons.AddStretch()
+	panel.status = qt.NewQLabel3(fmt.Sprintf("Журнал аналізів: %s", history.Dir))
+	layout.AddLayout(buttons.QLayout)
+	layout.AddWidget(panel.table.QWidget)
+	layout.AddWidget(panel.status.QWidget)
+	panel.Refresh()
+	return panel
+}
+
+// Refresh reads the index again, it runs on the Qt thread
+func (p *HistoryPanel) Refresh() {
+	entries, err := p.history.Entries()
+	if err != nil {
+		p.status.SetText(fmt.Sprintf("Не вдалося прочитати журнал аналізів: %s", err))
+		return
+	}
+	slices.Reverse(entries)
+	p.entries = entries
+
+	p.table.SetRowCount(len(entries))
+	for row, entry := range entries {
+		var probability string
+		if entry.Probability != nil {
+			probability = fmt.Sprintf("%.4f", *entry.Probability)
+		}
+		parameters := fmt.Sprintf("блок %d байтів", entry.Options.BlockSize)
+		if entry.Options.Sampling.Enabled() {
+			parameters += fmt.Sprintf(", вибірка %d блоків", entry.Options.Sampling.Blocks)
+		}
+		cells := []string{
+			entry.Created.Format("2006-01-02 15:04:05"),
+			filepath.Base(entry.FileName),
+			entry.Verdict,
+			probability,
+			entry.Profile,
+			time.Duration(entry.ElapsedSeconds * float64(time.Second)).Round(time.Second).String(),
+			parameters,
+		}
+		for column, text := range cells {
+			item := qt.NewQTableWidgetItem2(text)
+			if column == 1 {
+				item.SetToolTip(entry.FileName)
+			}
+			p.table.SetItem(row, column, item)
+		}
+	}
+}
+
+// selected returns the entries of the selected rows, the current one when none is selected
+func (p *HistoryPanel) selected() []HistoryEntry {
+	var entries []HistoryEntry
+	for row := range p.entries {
+		if p.table.SelectionModel().IsRowSelected(row) {
+			entries = append(entries, p.entries[row])
+		}
+	}
+	if row := p.table.CurrentRow(); len(entries) == 0 && row >= 0 && row < len(p.entries) {
+		entries = append(entries, p.entries[row])
+	}
+	return entries
+}
+
+func (p *HistoryPanel) open() {
+	entries := p.selected()
+	if len(entries) == 0 || p.OnOpen == nil {
+		return
+	}
+	report, err := p.history.Load(entries[0])
+	if err != nil {
+		p.status.SetText(fmt.Sprintf("Не вдалося відкрити звіт: %s", err))
+		return
+	}
+	p.OnOpen(report)
+}
+
+// compare loads the reports of the two selected entries, the rows are the newest first
+func (p *HistoryPanel) compare() {
+	entries := p.selected()
+	if len(entries) != 2 {
+		p.status.SetText("Для порівняння оберіть у журналі рівно два аналізи.")
+		return
+	}
+	if p.OnCompare == nil {
+		return
+	}
+	var reports [2]AnalysisReport
+	for idx, entry := range []HistoryEntry{entries[1], entries[0]} {
+		report, err := p.history.Load(entry)
+		if err != nil {
+			p.status.SetText(fmt.Sprintf("Не вдалося відкрити звіт: %s", err))
+			return
+		}
+		reports[idx] = report
+	}
+	p.OnCompare(reports[0], reports[1])
+}
@@ -0,0 +1,286 @@
+/*
+* Analysis job queue module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Default number of images analysed at the same time, each of them uses all the block workers
+const defaultJobLimit = 2
+
+type JobStatus int
+
+const (
+	JobQueued JobStatus = iota
+	JobRunning
+	JobPaused
+	JobDone
+	JobFailed
+)
+
+var jobStatusNames = []string{"у черзі", "виконується", "призупинено", "завершено", "помилка"}
+
+func (s JobStatus) String() string {
+	return jobStatusNames[s]
+}
+
+// AnalysisJob is an image in the queue, the queue hands out copies of it
+type AnalysisJob struct {
+	ID       int
+	FileName string
+	Options  AnalysisOptions
+	Status   JobStatus
+	Progress Progress
+	Result   *AnalysisResult
+	Err      error
+	// Time spent in the finished runs, a paused job is resumed from its checkpoint
+	elapsed time.Duration
+	started time.Time
+	cancel  context.CancelFunc
+}
+
+// Elapsed returns the analysis time of the job including the current run
+func (j AnalysisJob) Elapsed() time.Duration {
+	if j.Status == JobRunning {
+		return j.elapsed + time.Since(j.started)
+	}
+	return j.elapsed
+}
+
+// Verdict returns the encryption class of a finished job, empty otherwise
+func (j AnalysisJob) Verdict() string {
+	if j.Result == nil {
+		return ""
+	}
+	return encryptionNames[j.Result.Encryption]
+}
+
+// AnalyzeFunc runs the analysis of an image for the queue
+type AnalyzeFunc func(ctx context.Context, fileName string, options AnalysisOptions) (AnalysisResult, error)
+
+// JobQueue runs the queued images in their order, at most Limit at the same time.
+// A paused job is cancelled and waits to be resumed, its block analysis continues from the checkpoint.
+type JobQueue struct {
+	mu      sync.Mutex
+	jobs    []*AnalysisJob
+	limit   int
+	paused  bool
+	nextID  int
+	analyze AnalyzeFunc
+	// Called from the analysis goroutines after a job changes, may be nil
+	OnChange func(job AnalysisJob)
+}
+
+func NewJobQueue(limit int, analyze AnalyzeFunc) *JobQueue {
+	return &JobQueue{limit: max(1, limit), analyze: analyze}
+}
+
+// Add appends the images to the queue and starts them when there is room.
+// The images already queued, running or paused are skipped and returned.
+func (q *JobQueue) Add(options AnalysisOptions, fileNames ...string) (skipped []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, fileName := range fileNames {
+		if slices.ContainsFunc(q.jobs, func(job *AnalysisJob) bool {
+			return job.active() && sameImage(job.FileName, fileName)
+		}) {
+			skipped = append(skipped, fileName)
+			continue
+		}
+		q.nextID++
+		q.jobs = append(q.jobs, &AnalysisJob{ID: q.nextID, FileName: fileName, Options: options})
+	}
+	q.schedule()
+	return skipped
+}
+
+// active reports whether the job has not finished yet
+func (j *AnalysisJob) active() bool {
+	return j.Status == JobQueued || j.Status == JobRunning || j.Status == JobPaused
+}
+
+// sameImage reports whether the names point at the same image, the jobs of an image share its optimized file
+func sameImage(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
+
+// Jobs returns the snapshot of the queue in its order
+func (q *JobQueue) Jobs() []AnalysisJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]AnalysisJob, len(q.jobs))
+	for idx, job := range q.jobs {
+		jobs[idx] = *job
+	}
+	return jobs
+}
+
+func (q *JobQueue) find(id int) int {
+	return slices.IndexFunc(q.jobs, func(job *AnalysisJob) bool {
+		return job.ID == id
+	})
+}
+
+// Move shifts the job by delta places, the order decides which queued job starts next
+func (q *JobQueue) Move(id int, delta int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	idx := q.find(id)
+	if idx < 0 {
+		return
+	}
+	target := max(0, min(len(q.jobs)-1, idx+delta))
+	job := q.jobs[idx]
+	q.jobs = slices.Insert(slices.Delete(q.jobs, idx, idx+1), target, job)
+}
+
+// Remove cancels the job if it runs and drops it from the queue
+func (q *JobQueue) Remove(id int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if idx := q.find(id); idx >= 0 {
+		if q.jobs[idx].cancel != nil {
+			q.jobs[idx].cancel()
+		}
+		q.jobs = slices.Delete(q.jobs, idx, idx+1)
+	}
+}
+
+// Pause stops a running or queued job until it is resumed
+func (q *JobQueue) Pause(id int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if idx := q.find(id); idx >= 0 {
+		job := q.jobs[idx]
+		switch job.Status {
+		case JobRunning:
+			// The status is set when the cancelled analysis returns
+			job.cancel()
+		case JobQueued:
+			job.Status = JobPaused
+		}
+	}
+}
+
+// Resume puts a paused or failed job back into the queue
+func (q *JobQueue) Resume(id int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if idx := q.find(id); idx >= 0 {
+		job := q.jobs[idx]
+		if job.Status == JobPaused || job.Status == JobFailed {
+			job.Status, job.Err = JobQueued, nil
+			q.schedule()
+		}
+	}
+}
+
+// SetPaused stops or restarts starting the queued jobs, the running ones go on
+func (q *JobQueue) SetPaused(paused bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.paused = paused
+	q.schedule()
+}
+
+// SetLimit changes the number of jobs run at the same time, the running ones are not stopped
+func (q *JobQueue) SetLimit(limit int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.limit = max(1, limit)
+	q.schedule()
+}
+
+// schedule starts the first queued jobs while there is room, q.mu must be held
+func (q *JobQueue) schedule() {
+	if q.paused {
+		return
+	}
+	running := 0
+	for _, job := range q.jobs {
+		if job.Status == JobRunning {
+			running++
+		}
+	}
+	for _, job := range q.jobs {
+		if running >= q.limit {
+			return
+		}
+		// A resumed job may share the image with a running one, it waits for it to finish
+		if job.Status == JobQueued && !slices.ContainsFunc(q.jobs, func(other *AnalysisJob) bool {
+			return other.Status == JobRunning && sameImage(other.FileName, job.FileName)
+		}) {
+			q.start(job)
+			running++
+		}
+	}
+}
+
+// start runs the job in the background, q.mu must be held
+func (q *JobQueue) start(job *AnalysisJob) {
+	ctx, cancel := context.WithCancel(context.Background())
+	job.Status, job.started, job.cancel = JobRunning, time.Now(), cancel
+	job.Progress = Progress{}
+	options := job.Options
+	options.Progress = func(progress Progress) {
+		q.mu.Lock()
+		job.Progress = progress
+		snapshot := *job
+		q.mu.Unlock()
+		q.notify(snapshot)
+	}
+
+	go func() {
+		result, err := q.analyze(ctx, job.FileName, options)
+		cancel()
+
+		q.mu.Lock()
+		job.elapsed += time.Since(job.started)
+		job.cancel = nil
+		switch {
+		case errors.Is(err, context.Canceled):
+			job.Status = JobPaused
+		case err != nil:
+			job.Status, job.Err = JobFailed, err
+		default:
+			job.Status, job.Result = JobDone, &result
+		}
+		snapshot := *job
+		q.schedule()
+		q.mu.Unlock()
+		q.notify(snapshot)
+	}()
+}
+
+func (q *JobQueue) notify(job AnalysisJob) {
+	if q.OnChange != nil {
+		q.OnChange(job)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+* Analysis job queue tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAnalysis runs until its image is released or the job is cancelled
+type fakeAnalysis struct {
+	mu       sync.Mutex
+	releases map[string]chan struct{}
+	runs     map[string]int
+}
+
+func newFakeAnalysis() *fakeAnalysis {
+	return &fakeAnalysis{releases: make(map[string]chan struct{}), runs: make(map[string]int)}
+}
+
+func (f *fakeAnalysis) releaseChannel(fileName string) chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.releases[fileName] == nil {
+		f.releases[fileName] = make(chan struct{})
+	}
+	return f.releases[fileName]
+}
+
+func (f *fakeAnalysis) release(fileName string) {
+	close(f.releaseChannel(fileName))
+}
+
+func (f *fakeAnalysis) analyze(ctx context.Context, fileName string, options AnalysisOptions) (AnalysisResult, error) {
+	f.mu.Lock()
+	f.runs[fileName]++
+	f.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		return AnalysisResult{FileName: fileName}, ctx.Err()
+	case <-f.releaseChannel(fileName):
+	}
+	if fileName == "failing.img" {
+		return AnalysisResult{FileName: fileName}, errors.New("read error")
+	}
+	return AnalysisResult{FileName: fileName, Encryption: FullDiskEncryption}, nil
+}
+
+func (f *fakeAnalysis) runCount(fileName string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.runs[fileName]
+}
+
+// waitForStatuses waits until the jobs of the queue have the statuses in their order
+func waitForStatuses(t *testing.T, queue *JobQueue, want ...JobStatus) []AnalysisJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		jobs := queue.Jobs()
+		matched := len(jobs) == len(want)
+		for idx := 0; matched && idx < len(jobs); idx++ {
+			matched = jobs[idx].Status == want[idx]
+		}
+		if matched {
+			return jobs
+		}
+		if time.Now().After(deadline) {
+			var statuses []JobStatus
+			for _, job := range jobs {
+				statuses = append(statuses, job.Status)
+			}
+			t.Fatalf("job statuses %v, want %v", statuses, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestJobQueueLimit(t *testing.T) {
+	fake := newFakeAnalysis()
+	queue := NewJobQueue(2, fake.analyze)
+	queue.Add(DefaultAnalysisOptions(), "a.img", "b.img", "c.img")
+	waitForStatuses(t, queue, JobRunning, JobRunning, JobQueued)
+
+	fake.release("a.img")
+	jobs := waitForStatuses(t, queue, JobDone, JobRunning, JobRunning)
+	if jobs[0].Verdict() != encryptionNames[FullDiskEncryption] {
+		t.Errorf("verdict %q of the finished job", jobs[0].Verdict())
+	}
+
+	queue.Add(DefaultAnalysisOptions(), "d.img")
+	waitForStatuses(t, queue, JobDone, JobRunning, JobRunning, JobQueued)
+	queue.SetLimit(3)
+	waitForStatuses(t, queue, JobDone, JobRunning, JobRunning, JobRunning)
+
+	for _, fileName := range []string{"b.img", "c.img", "d.img"} {
+		fake.release(fileName)
+	}
+	waitForStatuses(t, queue, JobDone, JobDone, JobDone, JobDone)
+}
+
+func TestJobQueuePauseResume(t *testing.T) {
+	fake := newFakeAnalysis()
+	queue := NewJobQueue(1, fake.analyze)
+	queue.Add(DefaultAnalysisOptions(), "a.img", "b.img")
+	jobs := waitForStatuses(t, queue, JobRunning, JobQueued)
+
+	// A paused queued job is skipped, the paused running one makes room for the next
+	queue.Pause(jobs[1].ID)
+	waitForStatuses(t, queue, JobRunning, JobPaused)
+	queue.Pause(jobs[0].ID)
+	waitForStatuses(t, queue, JobPaused, JobPaused)
+
+	queue.Resume(jobs[1].ID)
+	waitForStatuses(t, queue, JobPaused, JobRunning)
+	queue.Resume(jobs[0].ID)
+	waitForStatuses(t, queue, JobQueued, JobRunning)
+
+	fake.release("b.img")
+	waitForStatuses(t, queue, JobRunning, JobDone)
+	fake.release("a.img")
+	waitForStatuses(t, queue, JobDone, JobDone)
+	if runs := fake.runCount("a.img"); runs != 2 {
+		t.Errorf("the resumed job ran %d times, want 2", runs)
+	}
+}
+
+func TestJobQueuePausedQueue(t *testing.T) {
+	fake := newFakeAnalysis()
+	queue := NewJobQueue(2, fake.analyze)
+	queue.SetPaused(true)
+	queue.Add(DefaultAnalysisOptions(), "a.img", "failing.img")
+	waitForStatuses(t, queue, JobQueued, JobQueued)
+
+	queue.SetPaused(false)
+	waitForStatuses(t, queue, JobRunning, JobRunning)
+	fake.release("failing.img")
+	jobs := waitForStatuses(t, queue, JobRunning, JobFailed)
+	if jobs[1].Err == nil {
+		t.Error("the failed job has no error")
+	}
+	queue.Remove(jobs[0].ID)
+	waitForStatuses(t, queue, JobFailed)
+}
+
+func TestJobQueueDuplicates(t *testing.T) {
+	fake := newFakeAnalysis()
+	queue := NewJobQueue(1, fake.analyze)
+	if skipped := queue.Add(DefaultAnalysisOptions(), "a.img", "b.img", "a.img"); len(skipped) != 1 || skipped[0] != "a.img" {
+		t.Errorf("skipped %v, want the repeated a.img", skipped)
+	}
+	jobs := waitForStatuses(t, queue, JobRunning, JobQueued)
+	if skipped := queue.Add(DefaultAnalysisOptions(), "./b.img"); len(skipped) != 1 {
+		t.Errorf("the queued image was added again by another name")
+	}
+
+	fake.release("a.img")
+	waitForStatuses(t, queue, JobDone, JobRunning)
+	// A finished image can be analysed again
+	if skipped := queue.Add(DefaultAnalysisOptions(), "a.img"); len(skipped) != 0 {
+		t.Errorf("the finished image was skipped")
+	}
+	waitForStatuses(t, queue, JobDone, JobRunning, JobQueued)
+	// The image was released already, its second run finishes at once
+	queue.Remove(jobs[1].ID)
+	waitForStatuses(t, queue, JobDone, JobDone)
+	if runs := fake.runCount("a.img"); runs != 2 {
+		t.Errorf("the image ran %d times, want 2", runs)
+	}
+}
@@ -0,0 +1,214 @@
+/*
+* Job queue panel of the GUI module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mappu/miqt/qt"
+	"github.com/mappu/miqt/qt/mainthread"
+)
+
+var jobColumns = []string{"Образ", "Стан", "Вердикт", "Час", "Перебіг"}
+
+// JobQueuePanel shows the queue of the images and controls it
+type JobQueuePanel struct {
+	Widget *qt.QWidget
+	queue  *JobQueue
+	table  *qt.QTableWidget
+	// Rows of the table, in the order of the queue
+	jobs []AnalysisJob
+	// Called when the user opens a finished job, may be nil
+	OnOpen func(job AnalysisJob)
+}
+
+// NewJobQueuePanel creates the panel of the queue, options returns the settings of the images added to it
+func NewJobQueuePanel(parent *qt.QWidget, queue *JobQueue, options func() AnalysisOptions) *JobQueuePanel {
+	panel := &JobQueuePanel{Widget: qt.NewQWidget(parent), queue: queue}
+	layout := qt.NewQVBoxLayout(panel.Widget)
+
+	panel.table = qt.NewQTableWidget3(0, len(jobColumns))
+	panel.table.SetHorizontalHeaderLabels(jobColumns)
+	panel.table.SetSelectionBehavior(qt.QAbstractItemView__SelectRows)
+	panel.table.SetSelectionMode(qt.QAbstractItemView__SingleSelection)
+	panel.table.SetEditTriggers(qt.QAbstractItemView__NoEditTriggers)
+	panel.table.HorizontalHeader().SetStretchLastSection(true)
+	panel.table.OnCellDoubleClicked(func(row int, column int) {
+		panel.open(row)
+	})
+
+	addButton := qt.NewQPushButton4(qt.QIcon_FromTheme("list-add"), "Додати образи")
+	addButton.OnClicked(func() {
+		fileNames := qt.QFileDialog_GetOpenFileNames4(panel.Widget, "Виберіть образи для аналізу", "", "Всі файли (*)")
+		if len(fileNames) > 0 {
+			panel.add(options(), fileNames)
+		}
+	})
+	upButton := qt.NewQPushButton4(qt.QIcon_FromTheme("go-up"), "Вгору")
+	upButton.OnClicked(func() { panel.move(-1) })
+	downButton := qt.NewQPushButton4(qt.QIcon_FromTheme("go-down"), "Вниз")
+	downButton.OnClicked(func() { panel.move(1) })
+	pauseButton := qt.NewQPushButton4(qt.QIcon_FromTheme("media-playback-pause"), "Призупинити")
+	pauseButton.OnClicked(func() {
+		if job, ok := panel.selected(); ok {
+			queue.Pause(job.ID)
+		}
+	})
+	resumeButton := qt.NewQPushButton4(qt.QIcon_FromTheme("media-playback-start"), "Продовжити")
+	resumeButton.OnClicked(func() {
+		if job, ok := panel.selected(); ok {
+			queue.Resume(job.ID)
+		}
+	})
+	removeButton := qt.NewQPushButton4(qt.QIcon_FromTheme("list-remove"), "Видалити")
+	removeButton.OnClicked(func() {
+		if job, ok := panel.selected(); ok {
+			queue.Remove(job.ID)
+			panel.refresh()
+		}
+	})
+	openButton := qt.NewQPushButton4(qt.QIcon_FromTheme("document-open"), "Відкрити результат")
+	openButton.OnClicked(func() { panel.open(panel.table.CurrentRow()) })
+
+	pauseQueueCheckBox := qt.NewQCheckBox3("Призупинити чергу")
+	pauseQueueCheckBox.OnToggled(queue.SetPaused)
+	limitSpinBox := qt.NewQSpinBox(panel.Widget)
+	limitSpinBox.SetRange(1, 16)
+	limitSpinBox.SetValue(defaultJobLimit)
+	limitSpinBox.OnValueChanged(queue.SetLimit)
+
+	buttons := qt.NewQHBoxLayout2()
+	for _, button := range []*qt.QPushButton{addButton, upButton, downButton, pauseButton, resumeButton, removeButton, openButton} {
+		buttons.AddWidget(button.QWidget)
+	}
+	settings := qt.NewQHBoxLayout2()
+	settings.AddWidget(pauseQueueCheckBox.QWidget)
+	settings.AddWidget(qt.NewQLabel3("Одночасних завдань").QWidget)
+	settings.AddWidget(limitSpinBox.QWidget)
+	settings.AddStretch()
+	layout.AddLayout(buttons.QLayout)
+	layout.AddLayout(settings.QLayout)
+	layout.AddWidget(panel.table.QWidget)
+
+	// Images dropped from a file manager are added to the queue
+	panel.Widget.SetAcceptDrops(true)
+	panel.Widget.OnDragEnterEvent(func(super func(event *qt.QDragEnterEvent), event *qt.QDragEnterEvent) {
+		if event.MimeData().HasUrls() {
+			event.AcceptProposedAction()
+		}
+	})
+	panel.Widget.OnDropEvent(func(super func(event *qt.QDropEvent), event *qt.QDropEvent) {
+		var fileNames []string
+		for _, url := range event.MimeData().Urls() {
+			if !url.IsLocalFile() {
+				continue
+			}
+			if fileStat, err := os.Stat(url.ToLocalFile()); err == nil && !fileStat.IsDir() {
+				fileNames = append(fileNames, url.ToLocalFile())
+			}
+		}
+		if len(fileNames) > 0 {
+			panel.add(options(), fileNames)
+			event.AcceptProposedAction()
+		}
+	})
+
+	queue.OnChange = func(job AnalysisJob) {
+		mainthread.Start(panel.refresh)
+	}
+	// The elapsed time of the running jobs goes on between the progress reports
+	timer := qt.NewQTimer2(panel.Widget.QObject)
+	timer.OnTimeout(panel.refresh)
+	timer.Start(1000)
+	return panel
+}
+
+func (p *JobQueuePanel) selected() (AnalysisJob, bool) {
+	row := p.table.CurrentRow()
+	if row < 0 || row >= len(p.jobs) {
+		return AnalysisJob{}, false
+	}
+	return p.jobs[row], true
+}
+
+func (p *JobQueuePanel) move(delta int) {
+	job, ok := p.selected()
+	if !ok {
+		return
+	}
+	p.queue.Move(job.ID, delta)
+	p.refresh()
+	for row, moved := range p.jobs {
+		if moved.ID == job.ID {
+			p.table.SelectRow(row)
+		}
+	}
+}
+
+func (p *JobQueuePanel) open(row int) {
+	if row < 0 || row >= len(p.jobs) || p.jobs[row].Result == nil || p.OnOpen == nil {
+		return
+	}
+	p.OnOpen(p.jobs[row])
+}
+
+// refresh shows the current state of the queue, it runs on the Qt thread
+// add queues the images and tells which of them were already in the queue
+func (p *JobQueuePanel) add(options AnalysisOptions, fileNames []string) {
+	if skipped := p.queue.Add(options, fileNames...); len(skipped) > 0 {
+		errorWindow := qt.NewQErrorMessage(p.Widget)
+		errorWindow.ShowMessage("Ці образи вже є в черзі: " + strings.Join(skipped, ", "))
+	}
+}
+
+func (p *JobQueuePanel) refresh() {
+	p.jobs = p.queue.Jobs()
+	p.table.SetRowCount(len(p.jobs))
+	for row, job := range p.jobs {
+		status := job.Status.String()
+		if job.Err != nil {
+			status += ": " + job.Err.Error()
+		}
+		var progress string
+		if job.Status == JobRunning {
+			progress = job.Progress.String()
+		}
+		cells := []string{filepath.Base(job.FileName), status, job.Verdict(), job.Elapsed().Round(time.Second).String(), progress}
+		for column, text := range cells {
+			// The rows are refreshed every tick, their items are created once and updated in place
+			if item := p.table.Item(row, column); item != nil {
+				if item.Text() != text {
+					item.SetText(text)
+				}
+				if column == 0 {
+					item.SetToolTip(job.FileName)
+				}
+				continue
+			}
+			item := qt.NewQTableWidgetItem2(text)
+			if column == 0 {
+				item.SetToolTip(job.FileName)
+			}
+			p.table.SetItem(row, column, item)
+		}
+	}
+}
@@ -19,10 +19,23 @@
 package main
 
 import (
+	"fmt"
 	"math"
 )
 
-func KsTest(totalCounter map[byte]int, readBytesCount int) (float64, int, int, float64, float64) {
+// KsResult is the Kolmogorov-Smirnov test of the byte distribution against the discrete uniform one
+type KsResult struct {
+	Statistic       float64
+	MaxDiffPosition int
+	// Critical values of the continuous distribution at the 0.01 and 0.05 levels, conservative for bytes
+	CriticalValue001 float64
+	CriticalValue005 float64
+	// Asymptotic p-value of the discrete distribution, see ksDiscretePValue. It is not the exact one
+	// of the sample size and is conservative, the exact p-value of a finite sample is smaller.
+	PValue float64
+}
+
+func KsTest(totalCounter ByteHistogram, readBytesCount int) KsResult {
 
 	var empiricalCumSum float64
 	var theoreticalCumSum float64
@@ -30,7 +43,7 @@ func KsTest(totalCounter map[byte]int, readBytesCount int) (float64, int, int, f
 	var theoreticalCDF []float64
 
 	for i := 0; i < 256; i++ {
-		empiricalCumSum += float64(totalCounter[byte(i)]) / float64(readBytesCount)
+		empiricalCumSum += float64(totalCounter[i]) / float64(readBytesCount)
 		theoreticalCumSum += float64(readBytesCount) / 256 / float64(readBytesCount)
 		empiricalCDF = append(empiricalCDF, empiricalCumSum)
 		theoreticalCDF = append(theoreticalCDF, theoreticalCumSum)
@@ -52,7 +65,120 @@ func KsTest(totalCounter map[byte]int, readBytesCount int) (float64, int, int, f
 			continue
 		}
 	}
-	criticalValue001 := 1.63 / math.Sqrt(float64(readBytesCount))
-	criticalValue005 := 1.36 / math.Sqrt(float64(readBytesCount))
-	return ksStatistic, maxDiffPosition, readBytesCount, criticalValue001, criticalValue005
+	return KsResult{
+		Statistic:        ksStatistic,
+		MaxDiffPosition:  maxDiffPosition,
+		CriticalValue001: 1.63 / math.Sqrt(float64(readBytesCount)),
+		CriticalValue005: 1.36 / math.Sqrt(float64(readBytesCount)),
+		PValue:           ksDiscretePValue(ksStatistic*math.Sqrt(float64(readBytesCount)), 256),
+	}
+}
+
+// kolmogorovTail returns the probability that the supremum of the Brownian bridge exceeds d
+func kolmogorovTail(d float64) float64 {
+	var tail float64
+	for k := 1; k <= 100; k++ {
+		term := 2 * math.Exp(-2*float64(k*k)*d*d)
+		if k%2 == 0 {
+			term = -term
+		}
+		tail += term
+		if math.Abs(term) < specFuncEpsilon {
+			break
+		}
+	}
+	return math.Max(0, math.Min(1, tail))
+}
+
+// ksDiscretePValue returns the asymptotic p-value of the scaled statistic d = D·sqrt(n) for a uniform
+// distribution over the given number of values, not the exact one of the sample size. The empirical process is then a Brownian bridge seen
+// only at the cells, so the p-value is the probability that the bridge leaves [-d; d] at one of them.
+// It is computed by propagating the density of the bridge over a grid from cell to cell; the Kolmogorov
+// distribution of continuous data would overstate the p-value.
+func ksDiscretePValue(d float64, cells int) float64 {
+	if d < 0.1 {
+		return 1
+	}
+	if d > 3.5 {
+		// Both are below 1e-10 here, the continuous tail is an upper bound of the discrete one
+		return kolmogorovTail(d)
+	}
+
+	step := 1 / math.Sqrt(float64(cells))
+	gridStep := step / 6
+	points := 2*int(math.Ceil(d/gridStep)) + 1
+	gridStep = 2 * d / float64(points-1)
+	band := int(math.Ceil(6 * step / gridStep))
+
+	gaussian := func(x float64) float64 {
+		return math.Exp(-x*x/(2*step*step)) / (step * math.Sqrt(2*math.Pi))
+	}
+	kernel := make([]float64, band+1)
+	for offset := range kernel {
+		kernel[offset] = gaussian(float64(offset) * gridStep)
+	}
+	// Trapezoid weights of the grid
+	weight := func(idx int) float64 {
+		if idx == 0 || idx == points-1 {
+			return gridStep / 2
+		}
+		return gridStep
+	}
+
+	density := make([]float64, points)
+	next := make([]float64, points)
+	for idx := range density {
+		density[idx] = gaussian(-d + float64(idx)*gridStep)
+	}
+	for range cells - 2 {
+		for idx := range density {
+			density[idx] *= weight(idx)
+		}
+		for idx := range next {
+			var sum float64
+			for other := max(0, idx-band); other < idx; other++ {
+				sum += density[other] * kernel[idx-other]
+			}
+			for other := idx; other <= min(points-1, idx+band); other++ {
+				sum += density[other] * kernel[other-idx]
+			}
+			next[idx] = sum
+		}
+		density, next = next, density
+	}
+
+	// The bridge ends at zero, the density of the free walk there normalises the probability
+	var inside float64
+	for idx, value := range density {
+		inside += value * gaussian(-d+float64(idx)*gridStep) * weight(idx)
+	}
+	// The bridge seen at the cells cannot leave the band more often than the continuous one,
+	// the bound also hides the rounding error of the grid far in the tail
+	return math.Max(0, math.Min(kolmogorovTail(d), 1-inside*math.Sqrt(2*math.Pi)))
+}
+
+type ksAnalyzer struct {
+	analyzerInfo
+	histogramInput
+	result    KsResult
+	bytesRead int
+}
+
+func newKsAnalyzer(info analyzerInfo, run AnalyzerRun) Analyzer {
+	return &ksAnalyzer{analyzerInfo: info}
+}
+
+// Finalize returns the p-value, the statistic D is reported in the details
+func (k *ksAnalyzer) Finalize(counts *StreamCounts) float64 {
+	k.bytesRead = counts.BytesRead
+	if k.bytesRead == 0 {
+		return math.NaN()
+	}
+	k.result = KsTest(counts.Histogram, counts.BytesRead)
+	return k.result.PValue
+}
+
+func (k *ksAnalyzer) Details() string {
+	return fmt.Sprintf("Статистика D %f, асимптотичне (консервативне) p-значення дискретного розподілу %f, максимальне відхилення у позиції %d, прочитано %d байтів. Критичні значення неперервного розподілу %f (0,01) та %f (0,05).",
+		k.result.Statistic, k.result.PValue, k.result.MaxDiffPosition, k.bytesRead, k.result.CriticalValue001, k.result.CriticalValue005)
 }
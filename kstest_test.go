@@ -0,0 +1,138 @@
+/*
+* Kolmogorov-Smirnov test tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestKsDiscretePValue(t *testing.T) {
+	tests := []struct {
+		d     float64
+		cells int
+	}{
+		{0.8, 256},
+		{1.0, 256},
+		{1.36, 256},
+		{1.63, 256},
+		{2.0, 256},
+		{1.36, 1024},
+	}
+	for _, test := range tests {
+		got := ksDiscretePValue(test.d, test.cells)
+		// A Brownian bridge seen at the cells leaves [-d; d] about as often as the continuous one leaves
+		// the band widened by 0.5826 of the cell step (Broadie, Glasserman and Kou, 1997)
+		want := kolmogorovTail(test.d + 0.5826/math.Sqrt(float64(test.cells)))
+		if math.Abs(got-want) > 0.01*want {
+			t.Errorf("ksDiscretePValue(%g, %d) = %.6f, want about %.6f", test.d, test.cells, got, want)
+		}
+		if continuous := kolmogorovTail(test.d); got > continuous {
+			t.Errorf("ksDiscretePValue(%g, %d) = %.6f exceeds the continuous p-value %.6f", test.d, test.cells, got, continuous)
+		}
+	}
+	if got := ksDiscretePValue(0.05, 256); got != 1 {
+		t.Errorf("ksDiscretePValue(0.05, 256) = %f, want 1", got)
+	}
+}
+
+func TestKolmogorovTail(t *testing.T) {
+	tests := []struct {
+		d, want float64
+	}{
+		// Critical values of the Kolmogorov distribution
+		{1.3581, 0.05},
+		{1.6276, 0.01},
+		{1.2239, 0.10},
+	}
+	for _, test := range tests {
+		if got := kolmogorovTail(test.d); math.Abs(got-test.want) > 1e-4 {
+			t.Errorf("kolmogorovTail(%g) = %.6f, want %.6f", test.d, got, test.want)
+		}
+	}
+}
+
+func TestKsTest(t *testing.T) {
+	var uniform, constant ByteHistogram
+	for idx := range uniform {
+		uniform[idx] = 100
+	}
+	constant[0] = 25600
+	tests := []struct {
+		name      string
+		histogram ByteHistogram
+		statistic float64
+	}{
+		{"uniform bytes", uniform, 0},
+		{"one byte value", constant, 255.0 / 256},
+	}
+	for _, test := range tests {
+		result := KsTest(test.histogram, 25600)
+		if math.Abs(result.Statistic-test.statistic) > 1e-12 {
+			t.Errorf("%s: D = %f, want %f", test.name, result.Statistic, test.statistic)
+		}
+	}
+}
+
+// The asymptotic p-value is conservative for finite samples of uniform bytes: the statistic reaches
+// the critical value of a level no more often than the level says, but not much less often either
+func TestKsTestFiniteSampleLevel(t *testing.T) {
+	const samples = 4000
+	const sampleSize = 2048
+	rng := rand.New(rand.NewPCG(11, 12))
+	statistics := make([]float64, samples)
+	for idx := range statistics {
+		var histogram ByteHistogram
+		for range sampleSize {
+			histogram[rng.UintN(256)]++
+		}
+		// The statistic of KsTest, without the p-value of every sample
+		var cumulative, statistic float64
+		for value, count := range histogram {
+			cumulative += float64(count) / sampleSize
+			statistic = math.Max(statistic, math.Abs(cumulative-float64(value+1)/256))
+		}
+		statistics[idx] = statistic * math.Sqrt(sampleSize)
+	}
+
+	for _, level := range []float64{0.01, 0.05, 0.1} {
+		// The scaled statistic at which the p-value falls to the level
+		low, high := 0.1, 3.5
+		for range 50 {
+			middle := (low + high) / 2
+			if ksDiscretePValue(middle, 256) > level {
+				low = middle
+			} else {
+				high = middle
+			}
+		}
+		var rejected float64
+		for _, statistic := range statistics {
+			if statistic >= high {
+				rejected++
+			}
+		}
+		rate := rejected / samples
+		margin := 3 * math.Sqrt(level*(1-level)/samples)
+		if rate > level+margin || rate < level/2 {
+			t.Errorf("level %g: rejection rate %f", level, rate)
+		}
+	}
+}
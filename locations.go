@@ -0,0 +1,241 @@
+/*
+* Locations of the findings in the images module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"cmp"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/rure-go"
+)
+
+// Signatures located per image, the first ones are enough to inspect what was counted
+const maxSignatureHits = 1000
+
+// Entropy bounds of the segments of the entropy profile, the upper one is that of the random sectors
+const (
+	lowEntropySegment  = 1.0
+	highEntropySegment = 7.0
+)
+
+// FileLocation is a finding the hex viewer can jump to, the offset is in FileName,
+// which is the optimized image for the findings of the block analysis
+type FileLocation struct {
+	FileName string `json:"file_name"`
+	Offset   int64  `json:"offset"`
+	// Bytes to highlight from the offset
+	Length int    `json:"length"`
+	Label  string `json:"label"`
+}
+
+func (l FileLocation) String() string {
+	return fmt.Sprintf("0x%010x  %s  (%s)", l.Offset, l.Label, filepath.Base(l.FileName))
+}
+
+func signatureLocations(fileName string, hits []SignatureHit, label string) []FileLocation {
+	locations := make([]FileLocation, 0, len(hits))
+	for _, hit := range hits {
+		locations = append(locations, FileLocation{FileName: fileName, Offset: hit.Offset, Length: hit.Length, Label: fmt.Sprintf(label, hit.Name)})
+	}
+	return locations
+}
+
+// LocateEncToolHeaders finds the headers of the encryption tools EncToolDetection counted
+// in the first or the last block, the ones of the whole image are the scanHits of its pass
+func LocateEncToolHeaders(fileName string, blockSize int, found map[string]int, scanHits []SignatureHit) ([]FileLocation, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	fileStat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	hits := slices.Clone(scanHits)
+	buffer := make([]byte, blockSize)
+	for name, count := range found {
+		pattern, ok := encToolPatterns[name]
+		if !ok || count == 0 || pattern.sector == 0 {
+			continue
+		}
+		regex, err := rure.Compile(pattern.regex)
+		if err != nil {
+			return nil, err
+		}
+
+		var start int64
+		if pattern.sector < 0 {
+			start = max(0, fileStat.Size()-int64(blockSize))
+		}
+		bytesRead, err := file.ReadAt(buffer, start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		for _, hit := range findSignatureHits(name, hex.EncodeToString(buffer[:bytesRead]), regex, maxSignatureHits) {
+			hit.Offset += start
+			hits = append(hits, hit)
+		}
+	}
+
+	slices.SortFunc(hits, func(a, b SignatureHit) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+	return signatureLocations(fileName, hits, "Заголовок засобу шифрування %s"), nil
+}
+
+// Partition is an entry of the partition table as parted prints it
+type Partition struct {
+	Number     int
+	Start      int64
+	End        int64
+	FileSystem string
+}
+
+// parsePartedTable reads the machine-readable output of parted in bytes,
+// the line after the unit (BYT;) describes the device and the following ones its partitions
+func parsePartedTable(output string) (partitions []Partition, sectorSize int) {
+	lines := strings.Split(output, "\n")
+	device := slices.Index(lines, "BYT;") + 1
+	if device == 0 {
+		return nil, 0
+	}
+	for idx := device; idx < len(lines); idx++ {
+		fields := strings.Split(strings.TrimSuffix(strings.TrimSpace(lines[idx]), ";"), ":")
+		if idx == device && len(fields) > 3 {
+			sectorSize, _ = strconv.Atoi(fields[3])
+			continue
+		}
+		if len(fields) < 5 {
+			continue
+		}
+		number, numberErr := strconv.Atoi(fields[0])
+		start, startErr := strconv.ParseInt(strings.TrimSuffix(fields[1], "B"), 10, 64)
+		end, endErr := strconv.ParseInt(strings.TrimSuffix(fields[2], "B"), 10, 64)
+		if numberErr != nil || startErr != nil || endErr != nil {
+			continue
+		}
+		partitions = append(partitions, Partition{Number: number, Start: start, End: end, FileSystem: fields[4]})
+	}
+	return partitions, sectorSize
+}
+
+// PartitionTable lists the partitions of the image, empty when parted finds no partition table
+func PartitionTable(fileName string) ([]Partition, int) {
+	result, err := exec.Command("./parted", "-m", fileName, "unit", "B", "print").CombinedOutput()
+	if err != nil || strings.Contains(string(result), "Error") {
+		return nil, 0
+	}
+	return parsePartedTable(string(result))
+}
+
+func partitionLocations(fileName string) []FileLocation {
+	partitions, sectorSize := PartitionTable(fileName)
+	if sectorSize <= 0 {
+		sectorSize = 512
+	}
+	var locations []FileLocation
+	for _, partition := range partitions {
+		fileSystem := partition.FileSystem
+		if fileSystem == "" {
+			fileSystem = "без файлової системи"
+		}
+		locations = append(locations, FileLocation{
+			FileName: fileName,
+			Offset:   partition.Start,
+			Length:   sectorSize,
+			Label:    fmt.Sprintf("Розділ %d (%s), %d байтів", partition.Number, fileSystem, partition.End-partition.Start+1),
+		})
+	}
+	return locations
+}
+
+func entropySegmentClass(entropy float64) string {
+	switch {
+	case entropy < lowEntropySegment:
+		return "порожні або однорідні дані"
+	case entropy < highEntropySegment:
+		return "структуровані дані"
+	default:
+		return "дані високої ентропії"
+	}
+}
+
+// EntropySegments joins the consecutive windows of the entropy profile with the same kind of data
+func EntropySegments(fileName string, entropies []float64, windowSize int) []FileLocation {
+	var locations []FileLocation
+	for start := 0; start < len(entropies); {
+		class := entropySegmentClass(entropies[start])
+		end := start + 1
+		sum := entropies[start]
+		for end < len(entropies) && entropySegmentClass(entropies[end]) == class {
+			sum += entropies[end]
+			end++
+		}
+		locations = append(locations, FileLocation{
+			FileName: fileName,
+			Offset:   int64(start) * int64(windowSize),
+			Label:    fmt.Sprintf("Сегмент ентропії: %s, середня ентропія %.4f, %d вікон", class, math.Abs(sum/float64(end-start)), end-start),
+		})
+		start = end
+	}
+	return locations
+}
+
+func geometryLocations(fileName string, geometry *VolumeGeometry) []FileLocation {
+	if geometry == nil || !geometry.Found() {
+		return nil
+	}
+	locations := []FileLocation{{FileName: fileName, Offset: geometry.EncryptedStart, Label: "Початок зашифрованої області"}}
+	if geometry.TrailerBytes > 0 {
+		locations = append(locations, FileLocation{FileName: fileName, Offset: geometry.EncryptedEnd, Label: "Метадані після зашифрованої області"})
+	}
+	return locations
+}
+
+// collectImageLocations adds the findings in the image itself to the result,
+// the encryption tool headers, the partitions and the layout of the encrypted volume.
+// The scanHits are the headers found by the pass of EncToolDetection, none for a sample.
+func collectImageLocations(result *AnalysisResult, scanHits []SignatureHit) error {
+	var locations []FileLocation
+	if result.EncToolFound() {
+		headers, err := LocateEncToolHeaders(result.FileName, result.Options.BlockSize, result.EncToolResult, scanHits)
+		if err != nil {
+			return err
+		}
+		locations = append(locations, headers...)
+	}
+	if result.HasFileSystem() {
+		locations = append(locations, partitionLocations(result.FileName)...)
+	}
+	locations = append(locations, geometryLocations(result.FileName, result.Geometry)...)
+	result.Locations = append(locations, result.Locations...)
+	return nil
+}
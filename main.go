@@ -19,27 +19,24 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
-	"maps"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"slices"
-	"strconv"
-	"strings"
+	"runtime"
+	"time"
 
 	"github.com/mappu/miqt/qt"
-)
-
-const (
-	NoEncryption int = iota
-	FullDiskEncryption
-	FileBasedEncryption
+	"github.com/mappu/miqt/qt/mainthread"
 )
 
 func main() {
+	// Console subcommands, the GUI is started when none is given
+	if exitCode, handled := runConsoleCommand(os.Args[1:]); handled {
+		os.Exit(exitCode)
+	}
+
 	qt.NewQApplication(os.Args)
 	window := qt.NewQMainWindow(nil)
 	window.SetWindowTitle("Графічний інтерфейс фінальної реалізації методу")
@@ -84,6 +81,16 @@ func main() {
 		}
 	})
 	startButton := qt.NewQPushButton4(qt.QIcon_FromTheme("media-playback-start"), "Аналіз")
+	enqueueButton := qt.NewQPushButton4(qt.QIcon_FromTheme("list-add"), "До черги")
+	stopButton := qt.NewQPushButton4(qt.QIcon_FromTheme("media-playback-stop"), "Зупинити")
+	stopButton.SetEnabled(false)
+	var cancelAnalysis context.CancelFunc
+	stopButton.OnClicked(func() {
+		if cancelAnalysis != nil {
+			cancelAnalysis()
+			stopButton.SetEnabled(false)
+		}
+	})
 
 	encryptedFileLocationEdit := qt.NewQLineEdit(widget)
 	encryptedFileLocationEdit.SetPlaceholderText("Введіть шлях для переміщення зашифрованих файлів")
@@ -112,64 +119,239 @@ func main() {
 	filePickerLayout.AddWidget2(fileNameTextField.QWidget, 0, 0)
 	filePickerLayout.AddWidget2(filePickerButton.QWidget, 0, 1)
 	filePickerLayout.AddWidget2(startButton.QWidget, 0, 2)
+	filePickerLayout.AddWidget2(stopButton.QWidget, 0, 3)
+	filePickerLayout.AddWidget2(enqueueButton.QWidget, 0, 4)
 	filePickerLayout.AddWidget3(encryptedFileLocationEdit.QWidget, 1, 0, 1, 2)
 	filePickerLayout.AddWidget2(encryptedFileLocationPickerButton.QWidget, 1, 2)
 
-	// Values display widgets
+	// Block workers, one image can use all processor cores
+	workersSpinBox := qt.NewQSpinBox(widget)
+	workersSpinBox.SetRange(1, 4*runtime.NumCPU())
+	workersSpinBox.SetValue(runtime.NumCPU())
+	filePickerLayout.AddWidget2(qt.NewQLabel3("Потоків обробки блоків").QWidget, 2, 0)
+	filePickerLayout.AddWidget2(workersSpinBox.QWidget, 2, 1)
+
+	sampleSpinBox := qt.NewQSpinBox(widget)
+	sampleSpinBox.SetRange(0, 1000000)
+	sampleSpinBox.SetSpecialValueText("увесь образ")
+	filePickerLayout.AddWidget2(qt.NewQLabel3("Блоків вибірки (швидкий аналіз)").QWidget, 3, 0)
+	filePickerLayout.AddWidget2(sampleSpinBox.QWidget, 3, 1)
+
+	significanceSpinBox := qt.NewQDoubleSpinBox(widget)
+	significanceSpinBox.SetDecimals(4)
+	significanceSpinBox.SetRange(0.0001, 0.5)
+	significanceSpinBox.SetSingleStep(0.001)
+	significanceSpinBox.SetValue(DefaultAnalysisOptions().RandomnessSignificance)
+	filePickerLayout.AddWidget2(qt.NewQLabel3("Рівень значущості тестів випадковості").QWidget, 4, 0)
+	filePickerLayout.AddWidget2(significanceSpinBox.QWidget, 4, 1)
+
+	chiSqSpinBox := qt.NewQDoubleSpinBox(widget)
+	chiSqSpinBox.SetDecimals(4)
+	chiSqSpinBox.SetRange(0, 0.5)
+	chiSqSpinBox.SetSingleStep(0.001)
+	chiSqSpinBox.SetSpecialValueText("з профілю")
+	filePickerLayout.AddWidget2(qt.NewQLabel3("Рівень значущості критерію Пірсона").QWidget, 5, 0)
+	filePickerLayout.AddWidget2(chiSqSpinBox.QWidget, 5, 1)
+
+	// Values display widgets, the rows of the tests follow the analyser registry
 	encToolResultDisplay := qt.NewQLineEdit(widget)
 	encToolResultDisplay.SetReadOnly(true)
-
-	autoCorrResultDisplay := qt.NewQLineEdit(widget)
-	autoCorrResultDisplay.SetReadOnly(true)
-
-	fsResultDisplay := qt.NewQLineEdit(widget)
-	fsResultDisplay.SetReadOnly(true)
-
-	ksResultDisplay := qt.NewQLineEdit(widget)
-	ksResultDisplay.SetReadOnly(true)
-
-	compressionStatDisplay := qt.NewQLineEdit(widget)
-	compressionStatDisplay.SetReadOnly(true)
-
-	sigResultDisplay := qt.NewQLineEdit(widget)
-	sigResultDisplay.SetReadOnly(true)
-
-	entropyStatDisplay := qt.NewQLineEdit(widget)
-	entropyStatDisplay.SetReadOnly(true)
-
-	// Placing them in grid with their respecting labels
 	resultsLayout.AddWidget2(qt.NewQLabel3("Тест пошуку сигнатур засобів шифрування").QWidget, 1, 0)
 	resultsLayout.AddWidget2(encToolResultDisplay.QWidget, 1, 1)
 
-	resultsLayout.AddWidget2(qt.NewQLabel3("Автокореляційний тест").QWidget, 2, 0)
-	resultsLayout.AddWidget2(autoCorrResultDisplay.QWidget, 2, 1)
-
-	resultsLayout.AddWidget2(qt.NewQLabel3("Тест пошуку файлових систем").QWidget, 3, 0)
-	resultsLayout.AddWidget2(fsResultDisplay.QWidget, 3, 1)
-
-	resultsLayout.AddWidget2(qt.NewQLabel3("Критерій узгодженості Колмогорова").QWidget, 4, 0)
-	resultsLayout.AddWidget2(ksResultDisplay.QWidget, 4, 1)
-
-	resultsLayout.AddWidget2(qt.NewQLabel3("Тест оцінки коефіцієнту стиснення").QWidget, 5, 0)
-	resultsLayout.AddWidget2(compressionStatDisplay.QWidget, 5, 1)
-
-	resultsLayout.AddWidget2(qt.NewQLabel3("Тест пошуку сигнатур файлів").QWidget, 6, 0)
-	resultsLayout.AddWidget2(sigResultDisplay.QWidget, 6, 1)
+	fsResultDisplay := qt.NewQLineEdit(widget)
+	fsResultDisplay.SetReadOnly(true)
+	resultsLayout.AddWidget2(qt.NewQLabel3("Тест пошуку файлових систем").QWidget, 2, 0)
+	resultsLayout.AddWidget2(fsResultDisplay.QWidget, 2, 1)
+
+	row := 3
+	testResultDisplays := make(map[string]*qt.QLineEdit)
+	for _, registration := range analyzerRegistry {
+		display := qt.NewQLineEdit(widget)
+		display.SetReadOnly(true)
+		resultsLayout.AddWidget2(qt.NewQLabel3(registration.Title()).QWidget, row, 0)
+		resultsLayout.AddWidget2(display.QWidget, row, 1)
+		testResultDisplays[registration.Name()] = display
+		row++
+	}
 
-	resultsLayout.AddWidget2(qt.NewQLabel3("Тест оцінки інформаційної ентропії").QWidget, 7, 0)
-	resultsLayout.AddWidget2(entropyStatDisplay.QWidget, 7, 1)
+	randomnessResultDisplay := qt.NewQLineEdit(widget)
+	randomnessResultDisplay.SetReadOnly(true)
+	resultsLayout.AddWidget2(qt.NewQLabel3("Тести випадковості NIST SP 800-22").QWidget, row, 0)
+	resultsLayout.AddWidget2(randomnessResultDisplay.QWidget, row, 1)
+	row++
+
+	probabilityDisplay := qt.NewQLineEdit(widget)
+	probabilityDisplay.SetReadOnly(true)
+	resultsLayout.AddWidget2(qt.NewQLabel3("Ймовірність шифрування (95% довірчий інтервал)").QWidget, row, 0)
+	resultsLayout.AddWidget2(probabilityDisplay.QWidget, row, 1)
+
+	// Progress of the running analysis
+	progressLabel := qt.NewQLabel3("Аналіз не запущено")
+	progressBar := qt.NewQProgressBar(widget)
+	progressBar.SetRange(0, 1000)
+	progressBar.SetValue(0)
+	showProgress := func(progress Progress) {
+		progressLabel.SetText(progress.String())
+		if fraction := progress.Fraction(); fraction >= 0 {
+			progressBar.SetRange(0, 1000)
+			progressBar.SetValue(int(fraction * 1000))
+		} else if progress.Done {
+			progressBar.SetRange(0, 1000)
+			progressBar.SetValue(1000)
+		} else {
+			// Busy indicator for the phases of unknown length
+			progressBar.SetRange(0, 0)
+		}
+	}
 
 	// Combining sublayouts into the main layout
 	mainLayout.AddLayout(filePickerLayout.QLayout)
+	mainLayout.AddWidget(progressLabel.QWidget)
+	mainLayout.AddWidget(progressBar.QWidget)
 	mainLayout.AddLayout(resultsLayout.QLayout)
 
-	// Log window (read-only)
+	// Log window (read-only) and the charts of the result, in tabs
+	outputTabs := qt.NewQTabWidget(widget)
 	logWindow := qt.NewQTextEdit4("Виведення протоколу роботи комплексного методу", widget)
 	logWindow.SetReadOnly(true)
 	logWindow.SetFont(qt.NewQFont2("monospace"))
-	mainLayout.AddWidget(logWindow.QWidget)
+	outputTabs.AddTab(logWindow.QWidget, "Протокол")
+	chartPanel := NewChartPanel(widget)
+	outputTabs.AddTab(chartPanel.Widget, "Графіки")
+	hexViewer := NewHexViewer(widget)
+	outputTabs.AddTab(hexViewer.Widget, "Шістнадцятковий перегляд")
+	mainLayout.AddWidget(outputTabs.QWidget)
+
+	// Shows a result in the displays, the log window and the viewers, the lines of the log are also passed to logLine
+	showResult := func(result AnalysisResult, profile Profile, logLine func(line string)) {
+		for _, display := range testResultDisplays {
+			display.Clear()
+		}
+		fsResultDisplay.Clear()
+		randomnessResultDisplay.Clear()
+		probabilityDisplay.Clear()
+		encToolResultDisplay.SetText(foundSignaturesTotalToReadable(result.EncToolResult))
+
+		for _, line := range ResultLog(result, profile) {
+			logWindow.Append(line)
+			logLine(line)
+		}
+		if !result.EncToolFound() {
+			fsResultDisplay.SetText(result.FileSystem)
+			for _, report := range TestReports(result, profile) {
+				testResultDisplays[report.Name].SetText(report.Value())
+			}
+			if classification := result.Classification; classification != nil {
+				probabilityDisplay.SetText(fmt.Sprintf("%f [%f; %f]", classification.Probability, classification.LowerBound, classification.UpperBound))
+			} else if result.Randomness != nil {
+				randomnessResultDisplay.SetText(randomnessResultToReadable(*result.Randomness))
+			}
+		}
+
+		chartPanel.SetCharts(ResultCharts(result))
+		hexViewer.SetLocations(result.Locations)
+		if openErr := hexViewer.Open(result.FileName); openErr != nil {
+			logWindow.Append(fmt.Sprintf("Не вдалося відкрити %s для перегляду: %s", result.FileName, openErr))
+		}
+	}
+
+	// History of the analyses and the settings of the last session, the GUI works without them
+	// when the configuration directory is not available
+	history, historyErr := OpenHistory()
+	if historyErr != nil {
+		log.Printf("Журнал аналізів вимкнено: %s", historyErr)
+	} else if settings, settingsErr := history.LoadSettings(); settingsErr != nil {
+		log.Printf("Не вдалося завантажити налаштування: %s", settingsErr)
+	} else {
+		fileNameTextField.SetText(settings.FileName)
+		if settings.OutputDir != "" {
+			encryptedFileLocationEdit.SetText(settings.OutputDir)
+		}
+		if settings.Workers > 0 {
+			workersSpinBox.SetValue(settings.Workers)
+		}
+		sampleSpinBox.SetValue(settings.Sample)
+		if settings.Significance > 0 {
+			significanceSpinBox.SetValue(settings.Significance)
+		}
+		chiSqSpinBox.SetValue(settings.ChiSqSignificance)
+	}
+	var historyPanel *HistoryPanel
+	// Adds the result to the history, it is called from the analysis goroutines as well
+	recordResult := func(result AnalysisResult, profile Profile, elapsed time.Duration) {
+		if history == nil {
+			return
+		}
+		if _, err := history.Add(NewAnalysisReport(result, profile, elapsed)); err != nil {
+			log.Printf("Не вдалося записати аналіз до журналу: %s", err)
+		}
+		mainthread.Start(historyPanel.Refresh)
+	}
+
+	// Queue of the images analysed in the background, each writes its own .enclog file
+	jobQueue := NewJobQueue(defaultJobLimit, func(ctx context.Context, fileName string, options AnalysisOptions) (AnalysisResult, error) {
+		profile, profileErr := LoadProfile(defaultProfileFile)
+		if profileErr != nil && !errors.Is(profileErr, os.ErrNotExist) {
+			log.Printf("Не вдалося завантажити профіль, використано типовий: %s", profileErr)
+		}
+		started := time.Now()
+		result, err := AnalyzeImageLogged(ctx, fileName, options, profile)
+		if err == nil {
+			recordResult(result, profile, time.Since(started))
+		}
+		return result, err
+	})
+	jobOptions := func() AnalysisOptions {
+		options := DefaultAnalysisOptions()
+		options.Workers = workersSpinBox.Value()
+		options.Sampling.Blocks = sampleSpinBox.Value()
+		options.RandomnessSignificance = significanceSpinBox.Value()
+		options.ChiSqSignificance = chiSqSpinBox.Value()
+		return options
+	}
+	jobPanel := NewJobQueuePanel(widget, jobQueue, jobOptions)
+	jobPanel.OnOpen = func(job AnalysisJob) {
+		logWindow.Clear()
+		logWindow.Append(fmt.Sprintf("Результат завдання черги: %s, час аналізу %s.\n", job.FileName, job.Elapsed().Round(time.Second)))
+		profile, _ := LoadProfile(defaultProfileFile)
+		showResult(*job.Result, profile, func(line string) {})
+		outputTabs.SetCurrentWidget(logWindow.QWidget)
+	}
+	outputTabs.AddTab(jobPanel.Widget, "Черга образів")
+	if history != nil {
+		historyPanel = NewHistoryPanel(widget, history)
+		historyPanel.OnOpen = func(report AnalysisReport) {
+			logWindow.Clear()
+			logWindow.Append(fmt.Sprintf("Звіт із журналу аналізів: %s, %s, час аналізу %.0f с.\n", report.Result.FileName, report.Created.Format("2006-01-02 15:04:05"), report.ElapsedSeconds))
+			profile, _ := LoadProfile(defaultProfileFile)
+			showResult(report.Result, profile, func(line string) {})
+			outputTabs.SetCurrentWidget(logWindow.QWidget)
+		}
+		historyPanel.OnRerun = func(entry HistoryEntry) {
+			jobPanel.add(entry.Options, []string{entry.FileName})
+			outputTabs.SetCurrentWidget(jobPanel.Widget)
+		}
+		outputTabs.AddTab(historyPanel.Widget, "Журнал аналізів")
+		comparisonPanel := NewComparisonPanel(widget)
+		historyPanel.OnCompare = func(a, b AnalysisReport) {
+			profile, _ := LoadProfile(defaultProfileFile)
+			comparisonPanel.SetComparison(CompareReports(a, b, profile))
+			outputTabs.SetCurrentWidget(comparisonPanel.Widget)
+		}
+		outputTabs.AddTab(comparisonPanel.Widget, "Порівняння")
+	}
+	enqueueButton.OnClicked(func() {
+		if fileName := fileNameTextField.Text(); fileName != "" {
+			jobPanel.add(jobOptions(), []string{fileName})
+			outputTabs.SetCurrentWidget(jobPanel.Widget)
+		}
+	})
+
 	startButton.OnClicked(func() {
 		logWindow.Clear()
+		chartPanel.SetCharts(nil)
+		hexViewer.SetLocations(nil)
 		fileName := fileNameTextField.Text()
 		outputDir := encryptedFileLocationEdit.Text()
 
@@ -206,164 +388,72 @@ func main() {
 			errorWindow := qt.NewQErrorMessage(widget)
 			errorWindow.ShowMessage("Запитаний каталог для збереження зашифрованих файлів не знайдено. Перевірте правильність введення шляху та повторіть спробу.")
 		} else if inputFileStatErr == nil || inputFileTypeErr == nil || outputDirTypeErr == nil {
-			var logFile = fmt.Sprintf("%s.enclog", fileName)
-			logFileHandle, logOpenErr := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY, 0644)
+			logFileHandle, logOpenErr := openResultLog(fileName)
 			if logOpenErr != nil {
 				fmt.Printf("Не вдалося відкрити файл журналу: %s", logOpenErr)
 			}
-			defer func(logFileHandle *os.File) {
-				logCloseErr := logFileHandle.Close()
-				if logCloseErr != nil {
-					fmt.Printf("Не вдалося закрити файл журналу: %s", logCloseErr)
-				}
-			}(logFileHandle)
-
 			fileNormalLogger := log.New(logFileHandle, "", log.LstdFlags)
 			fileErrorLogger := log.New(logFileHandle, "", log.LstdFlags)
 
-			fileExtension := filepath.Ext(fileName)
-			filePath := strings.TrimSuffix(fileName, fileExtension)
-
-			var optimizedfname = fmt.Sprintf("%s_opt%s", filePath, fileExtension)
-
-			if _, optFileOpenErr := os.Stat(optimizedfname); errors.Is(optFileOpenErr, os.ErrNotExist) {
-				fileErrorLogger.Printf("Оптимізований файл %s не знайдено.", optimizedfname)
-				result, fileOptimizationErr := exec.Command("python3", "prepare.py", "optimize", fileName).Output()
-				if fileOptimizationErr != nil {
-					fmt.Printf("Помилка оптимізації файлу: %s", result)
-				}
+			options := jobOptions()
+			options.Progress = func(progress Progress) {
+				mainthread.Start(func() {
+					showProgress(progress)
+				})
 			}
-
-			var blockSize = 1048576
-			var autocorrThreshold = 0.125
-			var ksTestThreshold = 0.1
-			var compressionThreshold = 1.1
-			var signatureThreshold = 150.0
-			var entropyThreshold = 7.95
-
-			var part1Result, part2Result string
-			var ksStatistic, compressionStat, signatureStat, entropyStat float64
-			var maxDiffPosition, readBytesCount int
-
-			var encToolFound bool
-
-			encToolResult := EncToolDetection(fileName, blockSize, false)
-			noEncToolResults := map[string]int{
-				"FreeBSD GELI": 0,
-				"BitLocker":    0,
-				"LUKSv1":       0,
-				"LUKSv2":       0,
-				"FileVault v2": 0,
-				"PGP WDE":      0,
+			profile, profileErr := LoadProfile(defaultProfileFile)
+			if profileErr != nil && !errors.Is(profileErr, os.ErrNotExist) {
+				fileErrorLogger.Printf("Не вдалося завантажити профіль, використано типовий: %s", profileErr)
 			}
 
-			var autocorrResult float64
-			var partedResult string
-
-			fmt.Println(!maps.Equal(encToolResult, noEncToolResults))
-
-			if !maps.Equal(encToolResult, noEncToolResults) {
-				encToolFound = true
-				part1Result = "Етап 1: Виявлено сигнатуру відомого програмного засобу шифрування. " + foundSignaturesTotalToReadable(encToolResult)
-				fmt.Println(part1Result)
-			} else {
-
-				autocorrResult = AutoCorrelation(optimizedfname, blockSize)
-				partedResult = PartedCheck(fileName)
-				noFSResults := []string{"", "unknown"}
-				contains := slices.Contains(noFSResults, partedResult)
-				var encryptionResult = NoEncryption
-				fmt.Println(encryptionResult)
-
-				if contains {
-					part1Result = "Етап 1: Шифрування не виявлено. Перехід на Етап 2."
-					counter, total := CreateFileCounter(optimizedfname, blockSize)
-					ksStatistic, maxDiffPosition, readBytesCount, _, _ = KsTest(counter, total)
-
-					compressionStat = CompressionTest(optimizedfname)
-					signatureStat = SignatureAnalysis(optimizedfname, blockSize)
-					entropyStat = EntropyEstimation(counter, total)
-
-					var autocorrTrue = autocorrResult <= autocorrThreshold
-					var ksTrue = ksStatistic <= ksTestThreshold
-					var compressionTrue = compressionStat <= compressionThreshold
-					var signatureTrue = signatureStat <= signatureThreshold
-					var entropyTrue = entropyStat >= entropyThreshold
-
-					var finalResult = CountTrueBools(autocorrTrue, ksTrue, compressionTrue, signatureTrue, entropyTrue)
-
-					if finalResult <= 2 {
-						part2Result = fmt.Sprintf("Етап 2: Кількість позитивних результатів %d <= 2, шифрування не виявлено. Завершення роботи програми.", finalResult)
-						encryptionResult = NoEncryption
-					} else if finalResult > 3 && finalResult <= 5 {
-						part2Result = fmt.Sprintf("Етап 2: Кількість позитивних результатів %d є [3,5], виявлено шифрування. Завершення роботи програми.", finalResult)
-						encryptionResult = FullDiskEncryption
-					} else {
-						part2Result = "Етап 2: Сталася помилка підрахунку."
-						encryptionResult = NoEncryption
-					}
-				} else {
-					if autocorrResult <= autocorrThreshold {
-						part1Result = "Етап 1: Файлова система з високою ймовірністю містить пофайлове шифрування або стиснуті дані. Завершення роботи програми."
-						encryptionResult = FileBasedEncryption
-					} else {
-						part1Result = "Етап 1: Шифрування не виявлено. Файлова система з високою ймовірністю містить незашифровані файли. Завершення роботи програми."
-						encryptionResult = NoEncryption
-					}
-				}
-			}
-
-			welcomeText := fmt.Sprintf("Графічний інтерфейс фінальної реалізації методу. Ім'я файлу: %s, розмір блоку: %d байтів.\n", fileName, blockSize)
+			welcomeText := fmt.Sprintf("Графічний інтерфейс фінальної реалізації методу. Ім'я файлу: %s, розмір блоку: %d байтів, потоків: %d.\n", fileName, options.BlockSize, options.Workers)
 			logWindow.Append(welcomeText)
 			fileNormalLogger.Println(welcomeText)
 
-			if encToolFound {
-				logWindow.Append(part1Result)
-				fileNormalLogger.Println(part1Result)
-			} else {
-
-				autocorrLogText := fmt.Sprintf("Значення автокореляційного тесту: %f, реф. значення %f\n", autocorrResult, autocorrThreshold)
-				logWindow.Append(autocorrLogText)
-				fileNormalLogger.Print(autocorrLogText)
-				autoCorrResultDisplay.SetText(strconv.FormatFloat(autocorrResult, 'f', -1, 64))
-
-				fsLogText := fmt.Sprintf("Тест виявлення файлової системи: %s\n", partedResult)
-				logWindow.Append(fsLogText)
-				fileNormalLogger.Print(fsLogText)
-				fsResultDisplay.SetText(partedResult)
-
-				noFSResults := []string{"", "unknown"}
-				contains := slices.Contains(noFSResults, partedResult)
-
-				if contains {
-					logWindow.Append(part1Result)
-					fileNormalLogger.Println(part1Result)
-					ksLogText := fmt.Sprintf("Критерій узгодженості Колмогорова: максимальне відхилення: %f (реф. значення %f) у позиції %d, прочитано %d байтів.\n", ksStatistic, ksTestThreshold, maxDiffPosition, readBytesCount)
-					logWindow.Append(ksLogText)
-					fileNormalLogger.Print(ksLogText)
-					ksResultDisplay.SetText(strconv.FormatFloat(ksStatistic, 'f', -1, 64))
-
-					compLogText := fmt.Sprintf("Середній коефіцієнт стиснення: %f, реф. значення %f\n", compressionStat, compressionThreshold)
-					logWindow.Append(compLogText)
-					fileNormalLogger.Print(compLogText)
-					compressionStatDisplay.SetText(strconv.FormatFloat(compressionStat, 'f', -1, 64))
-
-					sigLogText := fmt.Sprintf("Кількість сигнатур на мегабайт: %f, реф. значення %f\n", signatureStat, signatureThreshold)
-					logWindow.Append(sigLogText)
-					fileNormalLogger.Print(sigLogText)
-					sigResultDisplay.SetText(strconv.FormatFloat(signatureStat, 'f', -1, 64))
-
-					entropyLogText := fmt.Sprintf("Оціночний рівень інформаційної ентропії файлу: %f, реф. значення %f\n", entropyStat, entropyThreshold)
-					logWindow.Append(entropyLogText)
-					fileNormalLogger.Print(entropyLogText)
-					entropyStatDisplay.SetText(strconv.FormatFloat(entropyStat, 'f', -1, 64))
-					logWindow.Append(part2Result)
-					fileNormalLogger.Print(part2Result)
-				} else {
-					logWindow.Append(part1Result)
-					fileNormalLogger.Print(part1Result)
-				}
-			}
+			// The analysis runs in the background, the widgets are only touched from the Qt thread
+			started := time.Now()
+			ctx, cancel := context.WithCancel(context.Background())
+			cancelAnalysis = cancel
+			startButton.SetEnabled(false)
+			stopButton.SetEnabled(true)
+
+			go func() {
+				defer func(logFileHandle *os.File) {
+					logCloseErr := logFileHandle.Close()
+					if logCloseErr != nil {
+						fmt.Printf("Не вдалося закрити файл журналу: %s", logCloseErr)
+					}
+				}(logFileHandle)
+
+				result, analysisErr := AnalyzeImage(ctx, fileName, options, profile, fileErrorLogger)
+
+				mainthread.Wait(func() {
+					cancel()
+					cancelAnalysis = nil
+					startButton.SetEnabled(true)
+					stopButton.SetEnabled(false)
+
+					if errors.Is(analysisErr, context.Canceled) {
+						progressLabel.SetText("Аналіз зупинено")
+						progressBar.SetRange(0, 1000)
+						progressBar.SetValue(0)
+						stopText := "Аналіз зупинено користувачем. Повторний запуск продовжить аналіз блоків з останньої контрольної точки."
+						logWindow.Append(stopText)
+						fileNormalLogger.Println(stopText)
+						return
+					} else if analysisErr != nil {
+						progressLabel.SetText("Помилка аналізу")
+						logWindow.Append(fmt.Sprintf("Помилка аналізу: %s", analysisErr))
+						fileErrorLogger.Printf("Помилка аналізу: %s", analysisErr)
+						return
+					}
+					progressLabel.SetText("Аналіз завершено")
+					showResult(result, profile, func(line string) {
+						fileNormalLogger.Println(line)
+					})
+					recordResult(result, profile, time.Since(started))
+				})
+			}()
 		}
 	})
 
@@ -371,4 +461,18 @@ func main() {
 	window.SetCentralWidget(widget)
 	window.Show()
 	qt.QApplication_Exec()
+
+	if history != nil {
+		settings := GUISettings{
+			FileName:          fileNameTextField.Text(),
+			OutputDir:         encryptedFileLocationEdit.Text(),
+			Workers:           workersSpinBox.Value(),
+			Sample:            sampleSpinBox.Value(),
+			Significance:      significanceSpinBox.Value(),
+			ChiSqSignificance: chiSqSpinBox.Value(),
+		}
+		if err := history.SaveSettings(settings); err != nil {
+			log.Printf("Не вдалося зберегти налаштування: %s", err)
+		}
+	}
 }
@@ -0,0 +1,446 @@
+/*
+* Entropy estimators of NIST SP 800-90B module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+)
+
+const (
+	// The estimators over bits read this many bytes from the start of every full block,
+	// which keeps them cheap and makes every block an independent sample
+	nistChunkSize = 16384
+	// Upper 99.5% quantile of the standard normal distribution used by SP 800-90B for its bounds
+	nistZ = 2.576
+	// Parameters of the compression estimate: bits per symbol and symbols of the dictionary
+	maurerSymbolBits     = 6
+	maurerDictionarySize = 1000
+	maurerVarianceFactor = 0.5907
+	nistSearchIterations = 100
+	markovSequenceLength = 128
+)
+
+func chunkBit(chunk []byte, idx int) int {
+	return int(chunk[idx>>3]>>(7-idx&7)) & 1
+}
+
+// nistChunk returns the part of a full block the bit estimators read, nil for a partial block
+func nistChunk(block []byte, blockSize int) []byte {
+	if len(block) < blockSize || len(block) < nistChunkSize {
+		return nil
+	}
+	return block[:nistChunkSize]
+}
+
+// MostCommonValueEntropy is the min-entropy estimate of SP 800-90B 6.3.1, in bits per byte
+func MostCommonValueEntropy(histogram ByteHistogram, total int) (float64, float64) {
+	mostCommon := 0
+	for _, count := range histogram {
+		mostCommon = max(mostCommon, count)
+	}
+	p := float64(mostCommon) / float64(total)
+	upper := math.Min(1, p+nistZ*math.Sqrt(p*(1-p)/float64(total-1)))
+	return -math.Log2(upper), p
+}
+
+// RenyiEntropy returns the Rényi entropy of order 2 of the bytes, in bits per byte
+func RenyiEntropy(histogram ByteHistogram, total int) float64 {
+	var collision float64
+	for _, count := range histogram {
+		p := float64(count) / float64(total)
+		collision += p * p
+	}
+	return -math.Log2(collision)
+}
+
+// searchProbability finds by bisection the p in [low; high] at which the decreasing function
+// of the estimator equals the target. When even p = low is below the target the data
+// is as unpredictable as the estimator can tell, low is returned then.
+func searchProbability(low float64, high float64, target float64, function func(p float64) float64) float64 {
+	if function(low) <= target {
+		return low
+	}
+	for range nistSearchIterations {
+		middle := (low + high) / 2
+		if function(middle) > target {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+	return (low + high) / 2
+}
+
+type meanAccumulator struct {
+	Count int     `json:"count"`
+	Sum   float64 `json:"sum"`
+	SumSq float64 `json:"sum_sq"`
+}
+
+func (m *meanAccumulator) add(value float64) {
+	m.Count++
+	m.Sum += value
+	m.SumSq += value * value
+}
+
+func (m *meanAccumulator) merge(other meanAccumulator) {
+	m.Count += other.Count
+	m.Sum += other.Sum
+	m.SumSq += other.SumSq
+}
+
+func (m meanAccumulator) mean() float64 {
+	return m.Sum / float64(m.Count)
+}
+
+func (m meanAccumulator) stdDev() float64 {
+	mean := m.mean()
+	return math.Sqrt(math.Max(0, (m.SumSq-float64(m.Count)*mean*mean)/float64(m.Count-1)))
+}
+
+// collisionTimes steps through the bits of the chunk until a value repeats, as in SP 800-90B 6.3.2
+func collisionTimes(chunk []byte) meanAccumulator {
+	var times meanAccumulator
+	bits := len(chunk) * 8
+	for idx := 0; idx+2 < bits; {
+		if chunkBit(chunk, idx) == chunkBit(chunk, idx+1) {
+			times.add(2)
+			idx += 2
+		} else {
+			times.add(3)
+			idx += 3
+		}
+	}
+	return times
+}
+
+// CollisionEntropy is the collision estimate of SP 800-90B 6.3.2 over bits, in bits per byte
+func CollisionEntropy(times meanAccumulator) float64 {
+	lowerMean := times.mean() - nistZ*times.stdDev()/math.Sqrt(float64(times.Count))
+	p := searchProbability(0.5, 1, lowerMean, func(p float64) float64 {
+		q := 1 - p
+		z := 1 / q
+		// F(q) = Γ(3, z) z^-3 e^z
+		f := 2 * (1 + z + z*z/2) / (z * z * z)
+		return p/(q*q)*(1+(1/p-1/q)/2)*f - p/q*(1/p-1/q)/2
+	})
+	return -8 * math.Log2(p)
+}
+
+type markovCounts struct {
+	Ones        int       `json:"ones"`
+	Bits        int       `json:"bits"`
+	Transitions [2][2]int `json:"transitions"`
+}
+
+func (m *markovCounts) merge(other markovCounts) {
+	m.Ones += other.Ones
+	m.Bits += other.Bits
+	for from := range 2 {
+		for to := range 2 {
+			m.Transitions[from][to] += other.Transitions[from][to]
+		}
+	}
+}
+
+func countMarkovTransitions(chunk []byte) markovCounts {
+	counts := markovCounts{Bits: len(chunk) * 8}
+	previous := chunkBit(chunk, 0)
+	counts.Ones = previous
+	for idx := 1; idx < counts.Bits; idx++ {
+		bit := chunkBit(chunk, idx)
+		counts.Ones += bit
+		counts.Transitions[previous][bit]++
+		previous = bit
+	}
+	return counts
+}
+
+// MarkovEntropy is the Markov estimate of SP 800-90B 6.3.3 over bits, in bits per byte:
+// the min-entropy of the most likely 128-bit sequence of the first-order Markov model
+func MarkovEntropy(counts markovCounts) float64 {
+	p1 := float64(counts.Ones) / float64(counts.Bits)
+	initial := [2]float64{1 - p1, p1}
+	var transition [2][2]float64
+	for from := range 2 {
+		if total := counts.Transitions[from][0] + counts.Transitions[from][1]; total > 0 {
+			for to := range 2 {
+				transition[from][to] = float64(counts.Transitions[from][to]) / float64(total)
+			}
+		}
+	}
+
+	logProbability := func(p float64, power float64) float64 {
+		if p == 0 {
+			return math.Inf(-1)
+		}
+		return power * math.Log2(p)
+	}
+	steps := float64(markovSequenceLength - 1)
+	candidates := []float64{
+		logProbability(initial[0], 1) + logProbability(transition[0][0], steps),
+		logProbability(initial[0], 1) + logProbability(transition[0][1], math.Ceil(steps/2)) + logProbability(transition[1][0], math.Floor(steps/2)),
+		logProbability(initial[0], 1) + logProbability(transition[0][1], 1) + logProbability(transition[1][1], steps-1),
+		logProbability(initial[1], 1) + logProbability(transition[1][0], 1) + logProbability(transition[0][0], steps-1),
+		logProbability(initial[1], 1) + logProbability(transition[1][0], math.Ceil(steps/2)) + logProbability(transition[0][1], math.Floor(steps/2)),
+		logProbability(initial[1], 1) + logProbability(transition[1][1], steps),
+	}
+	mostLikely := math.Inf(-1)
+	for _, candidate := range candidates {
+		mostLikely = math.Max(mostLikely, candidate)
+	}
+	return 8 * math.Min(-mostLikely/markovSequenceLength, 1)
+}
+
+func maurerSymbolCount() int {
+	return nistChunkSize * 8 / maurerSymbolBits
+}
+
+// maurerDistances reads the chunk as 6-bit symbols and returns the log2 distances of the test symbols
+// to the previous occurrence of the same symbol, the first symbols fill the dictionary
+func maurerDistances(chunk []byte) meanAccumulator {
+	var lastSeen [1 << maurerSymbolBits]int
+	var distances meanAccumulator
+	for idx := 1; idx <= maurerSymbolCount(); idx++ {
+		symbol := 0
+		for bit := range maurerSymbolBits {
+			symbol = symbol<<1 | chunkBit(chunk, (idx-1)*maurerSymbolBits+bit)
+		}
+		if idx > maurerDictionarySize {
+			distance := idx
+			if lastSeen[symbol] > 0 {
+				distance = idx - lastSeen[symbol]
+			}
+			distances.add(math.Log2(float64(distance)))
+		}
+		lastSeen[symbol] = idx
+	}
+	return distances
+}
+
+// maurerLogs holds log2(t) for the symbol positions of a chunk, G(z) is evaluated many times per estimate
+var maurerLogs = sync.OnceValue(func() []float64 {
+	logs := make([]float64, maurerSymbolCount()+1)
+	for t := 1; t < len(logs); t++ {
+		logs[t] = math.Log2(float64(t))
+	}
+	return logs
+})
+
+// maurerExpectation is G(z) of SP 800-90B 6.3.4 for a chunk, every chunk has the same symbol count
+func maurerExpectation(z float64) float64 {
+	logs := maurerLogs()
+	symbols := maurerSymbolCount()
+	var sum, prefix float64
+	power := 1.0
+	// prefix accumulates log2(u) (1-z)^(u-1) over u < t
+	for t := 1; t <= symbols; t++ {
+		if t > maurerDictionarySize {
+			sum += z*z*prefix + z*logs[t]*power
+		}
+		prefix += logs[t] * power
+		power *= 1 - z
+		if power < 1e-18 {
+			// The prefix no longer grows, the remaining positions add the same term
+			sum += z * z * prefix * float64(symbols-max(t, maurerDictionarySize))
+			break
+		}
+	}
+	return sum / float64(symbols-maurerDictionarySize)
+}
+
+// CompressionEntropy is the compression (Maurer) estimate of SP 800-90B 6.3.4 over bits, in bits per byte
+func CompressionEntropy(distances meanAccumulator) float64 {
+	alphabet := float64(int(1) << maurerSymbolBits)
+	lowerMean := distances.mean() - nistZ*maurerVarianceFactor*distances.stdDev()/math.Sqrt(float64(distances.Count))
+	p := searchProbability(1/alphabet, 1, lowerMean, func(p float64) float64 {
+		q := (1 - p) / (alphabet - 1)
+		return maurerExpectation(p) + (alphabet-1)*maurerExpectation(q)
+	})
+	return -8 * math.Log2(p) / maurerSymbolBits
+}
+
+type mcvAnalyzer struct {
+	analyzerInfo
+	histogramInput
+	mostCommon float64
+	bytesRead  int
+}
+
+func newMcvAnalyzer(info analyzerInfo, run AnalyzerRun) Analyzer {
+	return &mcvAnalyzer{analyzerInfo: info}
+}
+
+func (m *mcvAnalyzer) Finalize(counts *StreamCounts) float64 {
+	m.bytesRead = counts.BytesRead
+	if m.bytesRead < 2 {
+		return math.NaN()
+	}
+	var entropy float64
+	entropy, m.mostCommon = MostCommonValueEntropy(counts.Histogram, counts.BytesRead)
+	return entropy
+}
+
+func (m *mcvAnalyzer) Details() string {
+	return fmt.Sprintf("Частка найчастішого значення байта %f, прочитано %d байтів.", m.mostCommon, m.bytesRead)
+}
+
+type renyiAnalyzer struct {
+	analyzerInfo
+	histogramInput
+}
+
+func newRenyiAnalyzer(info analyzerInfo, run AnalyzerRun) Analyzer {
+	return &renyiAnalyzer{analyzerInfo: info}
+}
+
+func (r *renyiAnalyzer) Finalize(counts *StreamCounts) float64 {
+	if counts.BytesRead == 0 {
+		return math.NaN()
+	}
+	return RenyiEntropy(counts.Histogram, counts.BytesRead)
+}
+
+// bitEstimatorSink feeds an estimator over the bits of the first nistChunkSize bytes of every full block,
+// count computes the partial result of a chunk and merge folds it into total
+type bitEstimatorSink struct {
+	blockSize int
+	count     func(chunk []byte) any
+	merge     func(partial any)
+	// Pointer to the merged partial results, saved in the checkpoints
+	total  any
+	chunks int
+}
+
+func (b *bitEstimatorSink) ProcessBlock(block []byte) any {
+	chunk := nistChunk(block, b.blockSize)
+	if chunk == nil {
+		return nil
+	}
+	return b.count(chunk)
+}
+
+func (b *bitEstimatorSink) Merge(partial any) {
+	if partial == nil {
+		return
+	}
+	b.merge(partial)
+	b.chunks++
+}
+
+func (b *bitEstimatorSink) Update(block []byte) {
+	b.Merge(b.ProcessBlock(block))
+}
+
+type bitEstimatorState struct {
+	Total  any `json:"total"`
+	Chunks int `json:"chunks"`
+}
+
+func (b *bitEstimatorSink) SaveState() ([]byte, error) {
+	return json.Marshal(bitEstimatorState{Total: b.total, Chunks: b.chunks})
+}
+
+func (b *bitEstimatorSink) RestoreState(state []byte) error {
+	restored := bitEstimatorState{Total: b.total}
+	if err := json.Unmarshal(state, &restored); err != nil {
+		return err
+	}
+	b.chunks = restored.Chunks
+	return nil
+}
+
+func (b *bitEstimatorSink) Details() string {
+	return fmt.Sprintf("Оцінка за бітами перших %d байтів %d повних блоків.", nistChunkSize, b.chunks)
+}
+
+type collisionAnalyzer struct {
+	analyzerInfo
+	*bitEstimatorSink
+	times meanAccumulator
+}
+
+func newCollisionAnalyzer(info analyzerInfo, run AnalyzerRun) Analyzer {
+	analyzer := &collisionAnalyzer{analyzerInfo: info}
+	analyzer.bitEstimatorSink = &bitEstimatorSink{
+		blockSize: run.Options.BlockSize,
+		count:     func(chunk []byte) any { return collisionTimes(chunk) },
+		merge:     func(partial any) { analyzer.times.merge(partial.(meanAccumulator)) },
+		total:     &analyzer.times,
+	}
+	return analyzer
+}
+
+func (c *collisionAnalyzer) Finalize(counts *StreamCounts) float64 {
+	if c.chunks == 0 {
+		return math.NaN()
+	}
+	return CollisionEntropy(c.times)
+}
+
+type markovAnalyzer struct {
+	analyzerInfo
+	*bitEstimatorSink
+	counts markovCounts
+}
+
+func newMarkovAnalyzer(info analyzerInfo, run AnalyzerRun) Analyzer {
+	analyzer := &markovAnalyzer{analyzerInfo: info}
+	analyzer.bitEstimatorSink = &bitEstimatorSink{
+		blockSize: run.Options.BlockSize,
+		count:     func(chunk []byte) any { return countMarkovTransitions(chunk) },
+		merge:     func(partial any) { analyzer.counts.merge(partial.(markovCounts)) },
+		total:     &analyzer.counts,
+	}
+	return analyzer
+}
+
+func (m *markovAnalyzer) Finalize(counts *StreamCounts) float64 {
+	if m.chunks == 0 {
+		return math.NaN()
+	}
+	return MarkovEntropy(m.counts)
+}
+
+type maurerAnalyzer struct {
+	analyzerInfo
+	*bitEstimatorSink
+	distances meanAccumulator
+}
+
+func newMaurerAnalyzer(info analyzerInfo, run AnalyzerRun) Analyzer {
+	analyzer := &maurerAnalyzer{analyzerInfo: info}
+	analyzer.bitEstimatorSink = &bitEstimatorSink{
+		blockSize: run.Options.BlockSize,
+		count:     func(chunk []byte) any { return maurerDistances(chunk) },
+		merge:     func(partial any) { analyzer.distances.merge(partial.(meanAccumulator)) },
+		total:     &analyzer.distances,
+	}
+	return analyzer
+}
+
+func (m *maurerAnalyzer) Finalize(counts *StreamCounts) float64 {
+	if m.chunks == 0 {
+		return math.NaN()
+	}
+	return CompressionEntropy(m.distances)
+}
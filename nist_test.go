@@ -0,0 +1,125 @@
+/*
+* SP 800-90B estimator tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// biasedBytes returns bytes of independent bits that are set with the given probability
+func biasedBytes(size int, ones float64, seed uint64) []byte {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	data := make([]byte, size)
+	for idx := range data {
+		for bit := range 8 {
+			if rng.Float64() < ones {
+				data[idx] |= 1 << bit
+			}
+		}
+	}
+	return data
+}
+
+// runEstimators streams the data through the registered SP 800-90B analysers
+func runEstimators(data []byte, blockSize int) map[string]float64 {
+	run := AnalyzerRun{Options: AnalysisOptions{BlockSize: blockSize}}
+	counters := streamCounters{histogram: NewHistogramSink()}
+	var analyzers []Analyzer
+	for _, registration := range analyzerRegistry {
+		switch registration.name {
+		case McvEntropyTestName, CollisionEntropyTestName, MarkovEntropyTestName, CompressionEntropyTestName, RenyiEntropyTestName:
+			analyzers = append(analyzers, registration.New(registration.analyzerInfo, run))
+		}
+	}
+	for start := 0; start < len(data); start += blockSize {
+		block := data[start:min(start+blockSize, len(data))]
+		counters.histogram.Update(block)
+		for _, analyzer := range analyzers {
+			analyzer.Update(block)
+		}
+	}
+	statistics := make(map[string]float64)
+	for _, analyzer := range analyzers {
+		statistics[analyzer.Name()] = analyzer.Finalize(counters.Counts())
+	}
+	return statistics
+}
+
+func TestEntropyEstimators(t *testing.T) {
+	// Independent bits set with probability 3/4 have the min-entropy -log2(3/4) and the Rényi entropy
+	// -log2(3/4·3/4 + 1/4·1/4) per bit
+	biasedMinEntropy := -8 * math.Log2(0.75)
+	biasedRenyi := -8 * math.Log2(0.625)
+	tests := []struct {
+		name      string
+		data      []byte
+		estimator string
+		// Range of the estimate in bits per byte
+		low, high float64
+	}{
+		{"random", randomBytes(1<<18, 1), McvEntropyTestName, 7.7, 8},
+		{"random", randomBytes(1<<18, 1), RenyiEntropyTestName, 7.99, 8},
+		{"random", randomBytes(1<<18, 1), CollisionEntropyTestName, 6.5, 8},
+		{"random", randomBytes(1<<18, 1), MarkovEntropyTestName, 7.9, 8},
+		{"random", randomBytes(1<<18, 1), CompressionEntropyTestName, 6, 8},
+		{"biased bits", biasedBytes(1<<18, 0.75, 2), McvEntropyTestName, biasedMinEntropy - 0.1, biasedMinEntropy},
+		{"biased bits", biasedBytes(1<<18, 0.75, 2), RenyiEntropyTestName, biasedRenyi - 0.02, biasedRenyi + 0.02},
+		{"biased bits", biasedBytes(1<<18, 0.75, 2), CollisionEntropyTestName, biasedMinEntropy - 0.3, biasedMinEntropy + 0.1},
+		{"biased bits", biasedBytes(1<<18, 0.75, 2), MarkovEntropyTestName, biasedMinEntropy - 0.1, biasedMinEntropy + 0.1},
+		// The compression estimate is the most conservative one
+		{"biased bits", biasedBytes(1<<18, 0.75, 2), CompressionEntropyTestName, 1, biasedMinEntropy},
+		{"zeros", make([]byte, 1<<18), McvEntropyTestName, 0, 1e-9},
+		{"zeros", make([]byte, 1<<18), CollisionEntropyTestName, 0, 0.01},
+		{"zeros", make([]byte, 1<<18), MarkovEntropyTestName, 0, 1e-9},
+		{"zeros", make([]byte, 1<<18), CompressionEntropyTestName, 0, 1e-9},
+		// The most likely 128 bits of alternating bits are certain but for the first one
+		{"alternating bits", bytesOf(0x55, 1<<18), MarkovEntropyTestName, 8.0 / 128, 8.0/128 + 1e-9},
+	}
+	for _, test := range tests {
+		estimate := runEstimators(test.data, 65536)[test.estimator]
+		if math.IsNaN(estimate) || estimate < test.low || estimate > test.high {
+			t.Errorf("%s: %s %f outside [%f; %f]", test.name, test.estimator, estimate, test.low, test.high)
+		}
+	}
+}
+
+func bytesOf(value byte, size int) []byte {
+	data := make([]byte, size)
+	for idx := range data {
+		data[idx] = value
+	}
+	return data
+}
+
+// The bit estimators only read full blocks, they have no estimate without one
+func TestEntropyEstimatorsPartialBlocks(t *testing.T) {
+	for _, size := range []int{nistChunkSize - 1, 65536 - 1} {
+		statistics := runEstimators(randomBytes(size, 3), 65536)
+		for _, name := range []string{CollisionEntropyTestName, MarkovEntropyTestName, CompressionEntropyTestName} {
+			if !math.IsNaN(statistics[name]) {
+				t.Errorf("%d bytes: %s %f from a partial block", size, name, statistics[name])
+			}
+		}
+		if math.IsNaN(statistics[McvEntropyTestName]) {
+			t.Errorf("%d bytes: no most common value estimate", size)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+/*
+* Analysis pipeline (Stage 1 and Stage 2 of the method) module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	NoEncryption int = iota
+	FullDiskEncryption
+	FileBasedEncryption
+)
+
+const defaultBlockSize = 1048576
+
+type AnalysisOptions struct {
+	BlockSize int
+	// Number of block workers, all CPU cores when zero
+	Workers int
+	// Interval between the checkpoints of the block analysis, checkpointing is off when zero
+	CheckpointInterval time.Duration
+	// Analyse a sample of the blocks instead of the whole image
+	Sampling    SamplingOptions
+	Compression CompressionOptions
+	// Lags of the autocorrelation test
+	Autocorrelation AutocorrelationOptions
+	// Significance level of the NIST randomness tests, the default one when zero
+	RandomnessSignificance float64
+	// Significance level of the chi-squared test at Stage 2, the threshold of the profile when zero
+	ChiSqSignificance float64
+	// Receives the progress of the phases, may be nil
+	Progress ProgressFunc `json:"-"`
+}
+
+func DefaultAnalysisOptions() AnalysisOptions {
+	return AnalysisOptions{
+		BlockSize:              defaultBlockSize,
+		CheckpointInterval:     defaultCheckpointInterval,
+		Sampling:               DefaultSamplingOptions(),
+		Compression:            DefaultCompressionOptions(),
+		Autocorrelation:        DefaultAutocorrelationOptions(),
+		RandomnessSignificance: defaultRandomnessSignificance,
+	}
+}
+
+type AnalysisResult struct {
+	FileName      string
+	Options       AnalysisOptions
+	EncToolResult map[string]int
+	FileSystem    string
+	// Statistics of the tests that were run, keyed by the profile test names
+	Statistics map[string]float64
+	// Intermediate values behind the statistics, for the reports
+	TestDetails    map[string]string
+	ReadBytesCount int
+	// Counts of the byte values of the optimized image (or of the sample)
+	Histogram *ByteHistogram
+	// Shannon entropy of consecutive blocks of the optimized image
+	EntropyProfile []float64
+	// Compression ratio of every compressed block, see CompressionSink.BlockRatios
+	CompressionMap []BlockRatio
+	// Autocorrelation by lag and power spectrum of the blocks
+	Autocorrelation *AutocorrelationProfile
+	// Repeated cipher blocks, checked on the images that reach Stage 2
+	DeterministicMode *DeterministicModeResult
+	// Layout of the encrypted volume, inferred for the full disk encryption verdicts
+	Geometry   *VolumeGeometry
+	Randomness *RandomnessResult
+	// Number of the file signatures found by type, for the comparison of results
+	SignatureCounts map[string]int
+	// Signatures, partitions and other findings to inspect in the hex viewer
+	Locations []FileLocation
+	// Set when the statistics come from a sample of the blocks
+	Sampling       *SamplingResult
+	Classification *Classification
+	// Set at Stage 2 when the repeated cipher blocks point at a deterministic mode,
+	// apart from the verdict of the classifier
+	DeterministicEncryption bool
+	Part1Result             string
+	Part2Result             string
+	Encryption              int
+}
+
+func (r AnalysisResult) EncToolFound() bool {
+	return sum(r.EncToolResult) > 0
+}
+
+func (r AnalysisResult) HasFileSystem() bool {
+	noFSResults := []string{"", "unknown"}
+	return !slices.Contains(noFSResults, r.FileSystem)
+}
+
+// Stage2Run reports whether the image reached Stage 2 of the method
+func (r AnalysisResult) Stage2Run() bool {
+	return r.Classification != nil
+}
+
+func optimizedFileName(fileName string) string {
+	fileExtension := filepath.Ext(fileName)
+	filePath := strings.TrimSuffix(fileName, fileExtension)
+	return fmt.Sprintf("%s_opt%s", filePath, fileExtension)
+}
+
+// prepareOptimizedFile creates the copy of the image without empty regions unless it already exists,
+// a copy interrupted by cancellation is removed so that it is not mistaken for a complete one later
+func prepareOptimizedFile(ctx context.Context, fileName string, progress ProgressFunc, errorLogger *log.Logger) (string, error) {
+	optimizedfname := optimizedFileName(fileName)
+	if _, optFileOpenErr := os.Stat(optimizedfname); errors.Is(optFileOpenErr, os.ErrNotExist) {
+		errorLogger.Printf("Оптимізований файл %s не знайдено.", optimizedfname)
+		tracker := startPhase(progress, OptimizationPhase, 0)
+		defer tracker.Finish()
+		result, fileOptimizationErr := exec.CommandContext(ctx, "python3", "prepare.py", "optimize", fileName).Output()
+		if ctx.Err() != nil {
+			if removeErr := os.Remove(optimizedfname); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+				errorLogger.Printf("Не вдалося видалити неповний файл %s: %s", optimizedfname, removeErr)
+			}
+			return optimizedfname, ctx.Err()
+		}
+		if fileOptimizationErr != nil {
+			errorLogger.Printf("Помилка оптимізації файлу: %s", result)
+		}
+	}
+	return optimizedfname, nil
+}
+
+func detectFileSystem(fileName string, progress ProgressFunc) string {
+	tracker := startPhase(progress, FileSystemPhase, 0)
+	defer tracker.Finish()
+	return PartedCheck(fileName)
+}
+
+// streamStatistics reads the optimized image once, running the Stage 1 analysers and,
+// when withStage2 is set, all the registered ones on the way
+func streamStatistics(ctx context.Context, optimizedfname string, result *AnalysisResult, withStage2 bool, errorLogger *log.Logger) error {
+	analyzers := newAnalyzers(AnalyzerRun{FileName: optimizedfname, Options: result.Options, ErrorLogger: errorLogger}, withStage2)
+	var sinks []BlockSink
+	var analyzerSinks []*analyzerSink
+	var layout []string
+	for _, analyzer := range analyzers {
+		if signatures, ok := analyzer.(*signatureAnalyzer); ok {
+			signatures.MaxHits = maxSignatureHits
+		}
+		sink, statisticSink := newAnalyzerSink(analyzer)
+		analyzerSinks = append(analyzerSinks, statisticSink)
+		if sink != nil {
+			sinks = append(sinks, sink)
+			layout = append(layout, analyzer.Name())
+		}
+	}
+
+	// The byte histogram and the byte pairs are counted once for all the analysers
+	counters := newStreamCounters(analyzers)
+	counterSinks, counterLayout := counters.sinks()
+	sinks = append(sinks, counterSinks...)
+	layout = append(layout, counterLayout...)
+
+	var entropyWindows *EntropyWindowSink
+	var duplicates *DuplicateBlockSink
+	if withStage2 {
+		entropyWindows = NewEntropyWindowSink(result.Options.BlockSize)
+		duplicates = NewDuplicateBlockSink()
+		sinks = append(sinks, entropyWindows, duplicates)
+		layout = append(layout, "entropy_profile", "duplicate_blocks")
+	}
+
+	streamOptions := StreamOptions{BlockSize: result.Options.BlockSize, Workers: result.Options.Workers, Progress: result.Options.Progress}
+	if result.Options.CheckpointInterval > 0 {
+		checkpoint, err := NewCheckpointer(optimizedfname, result.Options.CheckpointInterval, layout, errorLogger)
+		if err != nil {
+			errorLogger.Printf("Контрольні точки для %s вимкнено: %s", optimizedfname, err)
+		} else {
+			checkpoint.Settings = "compression=" + result.Options.Compression.String() + ";lags=" + result.Options.Autocorrelation.String()
+			streamOptions.Checkpoint = checkpoint
+		}
+	}
+
+	readBytesCount, err := StreamFile(ctx, optimizedfname, streamOptions, sinks...)
+	if err != nil {
+		return err
+	}
+	result.ReadBytesCount = readBytesCount
+	if streamOptions.Checkpoint != nil {
+		if err := streamOptions.Checkpoint.Remove(); err != nil {
+			errorLogger.Printf("Не вдалося видалити контрольну точку %s: %s", streamOptions.Checkpoint.FileName, err)
+		}
+	}
+
+	counts := counters.Counts()
+	collectAnalyzerStatistics(analyzerSinks, counts, result)
+	result.Histogram = &counts.Histogram
+	for _, sink := range analyzerSinks {
+		// The totals are only written for a complete pass, a cancelled one leaves no partial file
+		if signatures, ok := sink.analyzer.(*signatureAnalyzer); ok {
+			if err := writeSignatureTotals(optimizedfname, signatures.Found); err != nil {
+				errorLogger.Printf("Не вдалося записати кількість сигнатур %s: %s", optimizedfname, err)
+			}
+			result.SignatureCounts = signatures.Found
+			result.Locations = append(result.Locations, signatureLocations(optimizedfname, signatures.Hits, "Сигнатура %s")...)
+		}
+	}
+	if entropyWindows != nil {
+		result.EntropyProfile = entropyWindows.Entropies
+		result.DeterministicMode = &duplicates.Result
+		result.Locations = append(result.Locations, EntropySegments(optimizedfname, entropyWindows.Entropies, entropyWindows.WindowSize)...)
+	}
+	return nil
+}
+
+// collectAnalyzerStatistics finalizes the analysers and stores their statistics and details,
+// an analyser without a statistic (NaN) leaves its test out of the result
+func collectAnalyzerStatistics(analyzerSinks []*analyzerSink, counts *StreamCounts, result *AnalysisResult) {
+	finalizeAnalyzers(analyzerSinks, counts)
+	for _, sink := range analyzerSinks {
+		name := sink.analyzer.Name()
+		if !math.IsNaN(sink.statistic) {
+			result.Statistics[name] = sink.statistic
+		}
+		if detailed, ok := sink.analyzer.(DetailedAnalyzer); ok {
+			result.TestDetails[name] = detailed.Details()
+		}
+		if compression, ok := sink.analyzer.(*compressionAnalyzer); ok && compression.sink != nil {
+			result.CompressionMap = compression.sink.BlockRatios
+		}
+		if autocorrelation, ok := sink.analyzer.(*autocorrelationAnalyzer); ok && autocorrelation.LagMeans != nil {
+			result.Autocorrelation = autocorrelation.Profile()
+			for idx, lag := range autocorrelation.Lags[autocorrelation.TestLags:] {
+				result.Statistics[AutocorrelationLagStatisticName(lag)] = autocorrelation.LagMeans[autocorrelation.TestLags+idx]
+			}
+		}
+	}
+}
+
+func collectRandomness(ctx context.Context, optimizedfname string, result *AnalysisResult) error {
+	// Options recorded before the level was configurable have it unset
+	significance := result.Options.RandomnessSignificance
+	if significance <= 0 {
+		significance = defaultRandomnessSignificance
+	}
+	randomness := RandomnessBattery(ctx, optimizedfname, randomnessBlockSize, randomnessMaxBlocks, significance, result.Options.Progress)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	result.Randomness = &randomness
+	return nil
+}
+
+// lowAutocorrelation reports whether the autocorrelation test ran and found no structure,
+// a missing statistic (too small an image) is no evidence either way
+func lowAutocorrelation(result AnalysisResult, profile Profile) bool {
+	value, ok := result.Statistics[AutocorrelationTestName]
+	return ok && !math.IsNaN(value) && value <= profile.Tests[AutocorrelationTestName].Threshold
+}
+
+// AnalyzeImage runs the method on an image, computing only the tests its stages need.
+// With sampling enabled only a sample of the blocks is read, see analyzeWithSampling.
+// The analysis stops with the context error once ctx is cancelled.
+func AnalyzeImage(ctx context.Context, fileName string, options AnalysisOptions, profile Profile, errorLogger *log.Logger) (AnalysisResult, error) {
+	if options.Sampling.Enabled() {
+		return analyzeWithSampling(ctx, fileName, options, profile, errorLogger)
+	}
+	result := AnalysisResult{FileName: fileName, Options: options, Statistics: map[string]float64{}, TestDetails: map[string]string{}}
+	optimizedfname, err := prepareOptimizedFile(ctx, fileName, options.Progress, errorLogger)
+	if err != nil {
+		return result, err
+	}
+
+	// The geometry needs the offsets of the image itself, it is inferred in the same pass over it
+	geometry := NewGeometrySink(fileName)
+	var headerHits []SignatureHit
+	result.EncToolResult, headerHits, err = EncToolDetection(ctx, fileName, options, false, false, geometry)
+	if err != nil {
+		return result, err
+	}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if !result.EncToolFound() {
+		result.FileSystem = detectFileSystem(fileName, options.Progress)
+		if err := streamStatistics(ctx, optimizedfname, &result, !result.HasFileSystem(), errorLogger); err != nil {
+			return result, err
+		}
+
+		if result.HasFileSystem() && lowAutocorrelation(result, profile) {
+			// Low autocorrelation is shared by ciphertext and compressed data, randomness tests tell them apart
+			if err := collectRandomness(ctx, optimizedfname, &result); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	DecideEncryption(&result, profile)
+	if result.Encryption == FullDiskEncryption {
+		result.Geometry = &geometry.Result
+	}
+	return result, collectImageLocations(&result, headerHits)
+}
+
+// CollectAllStatistics runs every test on an image regardless of the stage outcomes,
+// so that the result can be decided again with any profile
+func CollectAllStatistics(ctx context.Context, fileName string, options AnalysisOptions, errorLogger *log.Logger) (AnalysisResult, error) {
+	result := AnalysisResult{FileName: fileName, Options: options, Statistics: map[string]float64{}, TestDetails: map[string]string{}}
+	optimizedfname, err := prepareOptimizedFile(ctx, fileName, options.Progress, errorLogger)
+	if err != nil {
+		return result, err
+	}
+
+	result.EncToolResult, _, err = EncToolDetection(ctx, fileName, options, false, false)
+	if err != nil {
+		return result, err
+	}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	result.FileSystem = detectFileSystem(fileName, options.Progress)
+	if err := streamStatistics(ctx, optimizedfname, &result, true, errorLogger); err != nil {
+		return result, err
+	}
+	return result, collectRandomness(ctx, optimizedfname, &result)
+}
+
+// DecideEncryption applies the stage logic of the method to the collected statistics
+func DecideEncryption(result *AnalysisResult, profile Profile) {
+	profile = profile.WithOptions(result.Options)
+	result.Classification = nil
+	result.DeterministicEncryption = false
+	result.Part2Result = ""
+
+	if result.EncToolFound() {
+		result.Part1Result = "Етап 1: Виявлено сигнатуру відомого програмного засобу шифрування. " + foundSignaturesTotalToReadable(result.EncToolResult)
+		result.Encryption = FullDiskEncryption
+		return
+	}
+
+	if !result.HasFileSystem() {
+		result.Part1Result = "Етап 1: Шифрування не виявлено. Перехід на Етап 2."
+
+		classification := Classify(profile, result.Statistics)
+		result.Classification = &classification
+		if classification.Encrypted {
+			result.Part2Result = fmt.Sprintf("Етап 2: Ймовірність шифрування %f (95%% довірчий інтервал [%f; %f]) >= %f, виявлено шифрування.", classification.Probability, classification.LowerBound, classification.UpperBound, profile.DecisionThreshold)
+			result.Encryption = FullDiskEncryption
+		} else {
+			result.Part2Result = fmt.Sprintf("Етап 2: Ймовірність шифрування %f (95%% довірчий інтервал [%f; %f]) < %f, шифрування не виявлено.", classification.Probability, classification.LowerBound, classification.UpperBound, profile.DecisionThreshold)
+			result.Encryption = NoEncryption
+		}
+		if result.DeterministicMode != nil && result.DeterministicMode.Detected {
+			// Equal plaintext blocks give equal ciphertext, so the repeats can make the statistics look unencrypted
+			result.DeterministicEncryption = true
+			result.Part2Result += fmt.Sprintf(" Окремо виявлено ознаки шифрування детермінованим режимом (%s), що не приховує повторів відкритого тексту.", result.DeterministicMode.Mode)
+		}
+		result.Part2Result += " Завершення роботи програми."
+		return
+	}
+
+	if autocorrelation, ok := result.Statistics[AutocorrelationTestName]; !ok || math.IsNaN(autocorrelation) {
+		result.Part1Result = "Етап 1: Шифрування не виявлено. Образ замалий для тесту автокореляції. Завершення роботи програми."
+		result.Encryption = NoEncryption
+	} else if !lowAutocorrelation(*result, profile) {
+		result.Part1Result = "Етап 1: Шифрування не виявлено. Файлова система з високою ймовірністю містить незашифровані файли. Завершення роботи програми."
+		result.Encryption = NoEncryption
+	} else if result.Randomness != nil && result.Randomness.IsRandom() {
+		result.Part1Result = "Етап 1: Дані пройшли тести випадковості. Файлова система з високою ймовірністю містить пофайлове шифрування. Завершення роботи програми."
+		result.Encryption = FileBasedEncryption
+	} else {
+		result.Part1Result = "Етап 1: Дані не пройшли тести випадковості. Файлова система з високою ймовірністю містить стиснуті дані без шифрування. Завершення роботи програми."
+		result.Encryption = NoEncryption
+	}
+}
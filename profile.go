@@ -0,0 +1,132 @@
+/*
+* Detection profile (thresholds and classifier weights) module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+)
+
+const defaultProfileFile = "profile.json"
+
+// Test of the profiles calibrated on the statistic D of the Kolmogorov-Smirnov test, now replaced by its p-value
+const legacyKsTestName = "kolmogorov_smirnov"
+
+// Names under which the tests are stored in the profile
+const (
+	AutocorrelationTestName    = "autocorrelation"
+	KsTestName                 = "kolmogorov_smirnov_p"
+	ChiSqTestName              = "chi_squared"
+	CompressionTestName        = "compression"
+	SignatureTestName          = "signatures"
+	EntropyTestName            = "entropy"
+	BigramTestName             = "bigram_chi_squared"
+	MutualInformationTestName  = "mutual_information"
+	McvEntropyTestName         = "mcv_min_entropy"
+	CollisionEntropyTestName   = "collision_min_entropy"
+	MarkovEntropyTestName      = "markov_min_entropy"
+	CompressionEntropyTestName = "compression_min_entropy"
+	RenyiEntropyTestName       = "renyi_entropy"
+	AndersonDarlingTestName    = "anderson_darling"
+	CramerVonMisesTestName     = "cramer_von_mises"
+)
+
+type TestCalibration struct {
+	// Value of the statistic at which the test gives no evidence either way
+	Threshold float64 `json:"threshold"`
+	// Distance from the threshold that corresponds to one unit of evidence, in decades for the p-values
+	Scale float64 `json:"scale"`
+	// Log-likelihood ratio contributed per unit of evidence
+	Weight       float64 `json:"weight"`
+	WeightStdErr float64 `json:"weight_std_err"`
+}
+
+type Profile struct {
+	Name       string                     `json:"name"`
+	Bias       float64                    `json:"bias"`
+	BiasStdErr float64                    `json:"bias_std_err"`
+	Tests      map[string]TestCalibration `json:"tests"`
+	// Probability of encryption from which the image is classified as encrypted
+	DecisionThreshold float64 `json:"decision_threshold"`
+}
+
+// DefaultProfile returns the hand-tuned thresholds of the thesis with equal weights
+func DefaultProfile() Profile {
+	tests := make(map[string]TestCalibration)
+	for _, registration := range analyzerRegistry {
+		tests[registration.name] = registration.DefaultCalibration
+	}
+
+	return Profile{
+		Name:              "default",
+		Bias:              0.0,
+		BiasStdErr:        0.5,
+		Tests:             tests,
+		DecisionThreshold: 0.5,
+	}
+}
+
+// WithOptions returns the profile with the thresholds overridden by the analysis options
+func (p Profile) WithOptions(options AnalysisOptions) Profile {
+	if options.ChiSqSignificance <= 0 {
+		return p
+	}
+	p.Tests = maps.Clone(p.Tests)
+	calibration := p.Tests[ChiSqTestName]
+	calibration.Threshold = options.ChiSqSignificance
+	p.Tests[ChiSqTestName] = calibration
+	return p
+}
+
+// LoadProfile reads a profile from a JSON file, tests missing from it keep their default calibration
+func LoadProfile(fileName string) (Profile, error) {
+	profile := DefaultProfile()
+
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return profile, err
+	}
+
+	loaded := Profile{DecisionThreshold: profile.DecisionThreshold}
+	if err := json.Unmarshal(content, &loaded); err != nil {
+		return profile, fmt.Errorf("не вдалося розібрати профіль %s: %v", fileName, err)
+	}
+
+	if _, ok := loaded.Tests[legacyKsTestName]; ok {
+		return profile, fmt.Errorf("профіль %s відкалібровано на статистиці D критерію Колмогорова замість p-значення, відкалібруйте його заново", fileName)
+	}
+	for name, calibration := range loaded.Tests {
+		if calibration.Scale <= 0 {
+			return profile, fmt.Errorf("профіль %s: масштаб тесту %s має бути додатнім", fileName, name)
+		}
+		profile.Tests[name] = calibration
+	}
+	loaded.Tests = profile.Tests
+	return loaded, nil
+}
+
+func SaveProfile(profile Profile, fileName string) error {
+	content, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, content, 0644)
+}
@@ -0,0 +1,50 @@
+/*
+* Profile tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import "testing"
+
+func TestProfileWithOptions(t *testing.T) {
+	profile := DefaultProfile()
+	tests := []struct {
+		significance float64
+		want         float64
+	}{
+		{0, profile.Tests[ChiSqTestName].Threshold},
+		{0.05, 0.05},
+	}
+	for _, test := range tests {
+		overridden := profile.WithOptions(AnalysisOptions{ChiSqSignificance: test.significance})
+		if threshold := overridden.Tests[ChiSqTestName].Threshold; threshold != test.want {
+			t.Errorf("significance %g: threshold %g, want %g", test.significance, threshold, test.want)
+		}
+		if threshold := overridden.Tests[KsTestName].Threshold; threshold != profile.Tests[KsTestName].Threshold {
+			t.Errorf("significance %g changed the threshold of another test to %g", test.significance, threshold)
+		}
+	}
+	if profile.Tests[ChiSqTestName].Threshold == 0.05 {
+		t.Error("the override changed the original profile")
+	}
+
+	// The report of the result records the threshold it was decided with
+	report := NewAnalysisReport(AnalysisResult{Options: AnalysisOptions{ChiSqSignificance: 0.05}}, profile, 0)
+	if report.Thresholds[ChiSqTestName] != 0.05 {
+		t.Errorf("report threshold %g, want 0.05", report.Thresholds[ChiSqTestName])
+	}
+}
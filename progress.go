@@ -0,0 +1,140 @@
+/*
+* Analysis progress reporting module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Minimal interval between two progress reports of a phase
+const progressInterval = 200 * time.Millisecond
+
+// Phases of the analysis as shown to the user
+const (
+	OptimizationPhase    = "Оптимізація образу"
+	EncToolPhase         = "Пошук сигнатур засобів шифрування"
+	FileSystemPhase      = "Пошук файлових систем"
+	BlockAnalysisPhase   = "Аналіз блоків"
+	RandomnessTestsPhase = "Тести випадковості"
+)
+
+// Progress is a snapshot of a running phase of the analysis
+type Progress struct {
+	Phase     string
+	BytesDone int64
+	// Zero when the amount of work of the phase is not known
+	BytesTotal int64
+	// Bytes per second since the start of the phase
+	Throughput float64
+	// Estimated time left, zero when it cannot be estimated
+	ETA  time.Duration
+	Done bool
+}
+
+// ProgressFunc receives the progress reports, it is called from the analysis goroutines
+type ProgressFunc func(progress Progress)
+
+// Fraction returns the completed part of the phase, or -1 when it is not known
+func (p Progress) Fraction() float64 {
+	if p.BytesTotal <= 0 {
+		return -1
+	}
+	return min(1, float64(p.BytesDone)/float64(p.BytesTotal))
+}
+
+func (p Progress) String() string {
+	if p.BytesTotal <= 0 && p.BytesDone == 0 {
+		if p.Done {
+			return p.Phase + ": завершено"
+		}
+		return p.Phase + "..."
+	}
+
+	text := fmt.Sprintf("%s: %.1f", p.Phase, float64(p.BytesDone)/1048576)
+	if p.BytesTotal > 0 {
+		text += fmt.Sprintf(" / %.1f МБ (%.1f%%)", float64(p.BytesTotal)/1048576, 100*p.Fraction())
+	} else {
+		text += " МБ"
+	}
+	text += fmt.Sprintf(", %.1f МБ/с", p.Throughput/1048576)
+	if p.ETA > 0 {
+		text += ", залишилось " + p.ETA.Round(time.Second).String()
+	}
+	return text
+}
+
+// progressTracker turns the byte counts of a phase into throttled progress reports,
+// a tracker without a ProgressFunc does nothing
+type progressTracker struct {
+	report     ProgressFunc
+	progress   Progress
+	started    time.Time
+	lastReport time.Time
+	// Work done before the phase was resumed, it does not count towards the throughput
+	resumed int64
+}
+
+// startPhase reports the start of a phase, total is zero when the amount of work is not known
+func startPhase(report ProgressFunc, phase string, total int64) *progressTracker {
+	return resumePhase(report, phase, total, 0)
+}
+
+// resumePhase reports the start of a phase of which done bytes were completed by an earlier run
+func resumePhase(report ProgressFunc, phase string, total int64, done int64) *progressTracker {
+	tracker := &progressTracker{
+		report:   report,
+		progress: Progress{Phase: phase, BytesDone: done, BytesTotal: total},
+		started:  time.Now(),
+		resumed:  done,
+	}
+	tracker.emit()
+	return tracker
+}
+
+func (t *progressTracker) Add(bytesDone int) {
+	if t.report == nil {
+		return
+	}
+	t.progress.BytesDone += int64(bytesDone)
+	if time.Since(t.lastReport) >= progressInterval {
+		t.emit()
+	}
+}
+
+func (t *progressTracker) Finish() {
+	t.progress.Done = true
+	t.emit()
+}
+
+func (t *progressTracker) emit() {
+	if t.report == nil {
+		return
+	}
+
+	t.lastReport = time.Now()
+	if elapsed := t.lastReport.Sub(t.started).Seconds(); elapsed > 0 {
+		t.progress.Throughput = float64(t.progress.BytesDone-t.resumed) / elapsed
+	}
+	t.progress.ETA = 0
+	if remaining := t.progress.BytesTotal - t.progress.BytesDone; remaining > 0 && t.progress.Throughput > 0 && !t.progress.Done {
+		t.progress.ETA = time.Duration(float64(remaining) / t.progress.Throughput * float64(time.Second))
+	}
+	t.report(t.progress)
+}
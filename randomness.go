@@ -0,0 +1,338 @@
+/*
+* Randomness tests module (NIST SP 800-22 subset, chi-squared and bigram uniformity)
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/bits"
+	"os"
+)
+
+const (
+	// 2^20 bits per tested sequence, the length recommended by NIST SP 800-22
+	randomnessBlockSize           = 131072
+	randomnessMaxBlocks           = 256
+	defaultRandomnessSignificance = 0.01
+	approxEntropyPatternLength    = 10
+	serialPatternLength           = 16
+)
+
+type RandomnessTestResult struct {
+	Name   string
+	Passed int
+	Tested int
+}
+
+func (r RandomnessTestResult) PassRate() float64 {
+	if r.Tested == 0 {
+		return 0.0
+	}
+	return float64(r.Passed) / float64(r.Tested)
+}
+
+type RandomnessResult struct {
+	Tests        []RandomnessTestResult
+	BlocksTested int
+	// Lower bound of the acceptable proportion of passing blocks (NIST SP 800-22, section 4.2.1)
+	MinPassRate float64
+}
+
+// IsRandom reports whether every test was passed by an acceptable proportion of blocks.
+// Ciphertext passes all of them, while compressed streams usually fail at least one.
+func (r RandomnessResult) IsRandom() bool {
+	if r.BlocksTested == 0 {
+		return false
+	}
+	for _, test := range r.Tests {
+		if test.PassRate() < r.MinPassRate {
+			return false
+		}
+	}
+	return true
+}
+
+func randomnessResultToReadable(result RandomnessResult) string {
+	var readable string
+	for _, test := range result.Tests {
+		readable = readable + fmt.Sprintf("%s - %.3f, ", test.Name, test.PassRate())
+	}
+	return readable
+}
+
+func bitAt(data []byte, idx int) int {
+	return int(data[idx>>3]>>(7-uint(idx&7))) & 1
+}
+
+func countOnes(data []byte) int {
+	var ones int
+	for _, b := range data {
+		ones += bits.OnesCount8(b)
+	}
+	return ones
+}
+
+// countOverlappingPatterns counts every m-bit pattern in the sequence, wrapping around its end,
+// a sequence shorter than the pattern has none
+func countOverlappingPatterns(data []byte, m int) []int {
+	n := len(data) * 8
+	counts := make([]int, 1<<m)
+	if m == 0 {
+		counts[0] = n
+		return counts
+	}
+	if n < m {
+		return counts
+	}
+
+	mask := 1<<m - 1
+	var pattern int
+	for i := 0; i < m-1; i++ {
+		pattern = pattern<<1 | bitAt(data, i)
+	}
+	for i := 0; i < n; i++ {
+		pattern = (pattern<<1 | bitAt(data, (i+m-1)%n)) & mask
+		counts[pattern]++
+	}
+	return counts
+}
+
+func MonobitTest(data []byte) float64 {
+	n := float64(len(data) * 8)
+	sum := 2*float64(countOnes(data)) - n
+	return math.Erfc(math.Abs(sum) / math.Sqrt(n) / math.Sqrt2)
+}
+
+func RunsTest(data []byte) float64 {
+	n := float64(len(data) * 8)
+	pi := float64(countOnes(data)) / n
+	if math.Abs(pi-0.5) >= 2/math.Sqrt(n) {
+		// Frequency prerequisite failed, the runs test is not applicable
+		return 0.0
+	}
+
+	runs := 1
+	for idx, b := range data {
+		runs += bits.OnesCount8((b ^ (b >> 1)) & 0x7f)
+		if idx > 0 {
+			runs += int((data[idx-1] & 1) ^ (b >> 7))
+		}
+	}
+
+	numerator := math.Abs(float64(runs) - 2*n*pi*(1-pi))
+	denominator := 2 * math.Sqrt(2*n) * pi * (1 - pi)
+	return math.Erfc(numerator / denominator)
+}
+
+func ApproximateEntropyTest(data []byte, m int) float64 {
+	n := float64(len(data) * 8)
+	phi := func(m int) float64 {
+		var sum float64
+		for _, count := range countOverlappingPatterns(data, m) {
+			if count > 0 {
+				p := float64(count) / n
+				sum += p * math.Log(p)
+			}
+		}
+		return sum
+	}
+
+	apEn := phi(m) - phi(m+1)
+	chiSquare := 2 * n * (math.Ln2 - apEn)
+	return igamc(math.Pow(2, float64(m-1)), chiSquare/2)
+}
+
+func SerialTest(data []byte, m int) (float64, float64) {
+	n := float64(len(data) * 8)
+	psiSquared := func(m int) float64 {
+		if m <= 0 {
+			return 0.0
+		}
+		var sum float64
+		for _, count := range countOverlappingPatterns(data, m) {
+			sum += float64(count) * float64(count)
+		}
+		return math.Pow(2, float64(m))/n*sum - n
+	}
+
+	psiM, psiM1, psiM2 := psiSquared(m), psiSquared(m-1), psiSquared(m-2)
+	delta1 := psiM - psiM1
+	delta2 := psiM - 2*psiM1 + psiM2
+	return igamc(math.Pow(2, float64(m-2)), delta1/2), igamc(math.Pow(2, float64(m-3)), delta2/2)
+}
+
+// cumulativeSumsPValue evaluates the NIST SP 800-22 cumulative sums p-value for the maximum partial sum z
+func cumulativeSumsPValue(z float64, n float64) float64 {
+	if z == 0 {
+		return 1.0
+	}
+	sqrtN := math.Sqrt(n)
+
+	var sum1, sum2 float64
+	for k := int((-n/z + 1) / 4); k <= int((n/z-1)/4); k++ {
+		sum1 += normalCDF((4*float64(k)+1)*z/sqrtN) - normalCDF((4*float64(k)-1)*z/sqrtN)
+	}
+	for k := int((-n/z - 3) / 4); k <= int((n/z-1)/4); k++ {
+		sum2 += normalCDF((4*float64(k)+3)*z/sqrtN) - normalCDF((4*float64(k)+1)*z/sqrtN)
+	}
+	return 1.0 - sum1 + sum2
+}
+
+// CumulativeSumsTest returns the forward and backward mode p-values
+func CumulativeSumsTest(data []byte) (float64, float64) {
+	n := len(data) * 8
+	partialSums := make([]int, n)
+	var sum, forwardMax int
+	for i := 0; i < n; i++ {
+		sum += 2*bitAt(data, i) - 1
+		partialSums[i] = sum
+		if abs := max(sum, -sum); abs > forwardMax {
+			forwardMax = abs
+		}
+	}
+
+	// Backward partial sums are the differences between the total and the forward sums
+	backwardMax := max(sum, -sum)
+	for i := 0; i < n-1; i++ {
+		diff := sum - partialSums[i]
+		if abs := max(diff, -diff); abs > backwardMax {
+			backwardMax = abs
+		}
+	}
+
+	return cumulativeSumsPValue(float64(forwardMax), float64(n)), cumulativeSumsPValue(float64(backwardMax), float64(n))
+}
+
+func ByteChiSqTest(data []byte) float64 {
+	_, pValue := ChiSqTest(countBytes(data), len(data))
+	return pValue
+}
+
+// BigramTest applies the generalized serial test to overlapping byte pairs, the sequence wraps around
+// so that every byte starts a pair
+func BigramTest(data []byte) float64 {
+	pairs := new(BigramHistogram)
+	for idx, b := range data {
+		pairs[int(b)<<8|int(data[(idx+1)%len(data)])]++
+	}
+	_, pValue := BigramSerialTest(pairs, len(data))
+	return pValue
+}
+
+func runRandomnessTests(data []byte, results []RandomnessTestResult, significance float64) {
+	serial1, serial2 := SerialTest(data, serialPatternLength)
+	cusumForward, cusumBackward := CumulativeSumsTest(data)
+
+	pValues := [][]float64{
+		{MonobitTest(data)},
+		{RunsTest(data)},
+		{ApproximateEntropyTest(data, approxEntropyPatternLength)},
+		{serial1, serial2},
+		{cusumForward, cusumBackward},
+		{ByteChiSqTest(data)},
+		{BigramTest(data)},
+	}
+
+	for idx, testPValues := range pValues {
+		passed := true
+		for _, pValue := range testPValues {
+			if pValue < significance {
+				passed = false
+			}
+		}
+		results[idx].Tested++
+		if passed {
+			results[idx].Passed++
+		}
+	}
+}
+
+// RandomnessBattery runs the randomness tests on up to maxBlocks blocks spread evenly over the file,
+// a block passes a test when its p-values are not below the significance level
+func RandomnessBattery(ctx context.Context, fileName string, blockSize int, maxBlocks int, significance float64, progress ProgressFunc) RandomnessResult {
+	file, err := os.Open(fileName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}(file)
+
+	fileStat, err := file.Stat()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fsize := int(fileStat.Size())
+	if fsize < blockSize {
+		blockSize = fsize
+	}
+
+	results := []RandomnessTestResult{
+		{Name: "Частотний (монобітний)"},
+		{Name: "Серій"},
+		{Name: "Апроксимованої ентропії"},
+		{Name: "Послідовностей"},
+		{Name: "Кумулятивних сум"},
+		{Name: "Хі-квадрат Пірсона"},
+		{Name: "Рівномірності біграм"},
+	}
+
+	// The serial test needs at least as many bits as its patterns have
+	if blockSize*8 < serialPatternLength {
+		return RandomnessResult{Tests: results}
+	}
+
+	blockCount := fsize / blockSize
+	stride := max(1, blockCount/maxBlocks)
+	buffer := make([]byte, blockSize)
+	var blocksTested int
+	tracker := startPhase(progress, RandomnessTestsPhase, int64(min(blockCount, maxBlocks))*int64(blockSize))
+	defer tracker.Finish()
+
+	for blockIdx := 0; blockIdx < blockCount && blocksTested < maxBlocks; blockIdx += stride {
+		if ctx.Err() != nil {
+			break
+		}
+		bytesRead, err := file.ReadAt(buffer, int64(blockIdx)*int64(blockSize))
+		if bytesRead < blockSize {
+			if err != nil && err != io.EOF {
+				log.Fatal(err)
+			}
+			break
+		}
+
+		runRandomnessTests(buffer, results, significance)
+		blocksTested++
+		tracker.Add(blockSize)
+	}
+
+	expectedPassRate := 1 - significance
+	return RandomnessResult{
+		Tests:        results,
+		BlocksTested: blocksTested,
+		MinPassRate:  expectedPassRate - 3*math.Sqrt(expectedPassRate*significance/float64(max(1, blocksTested))),
+	}
+}
@@ -0,0 +1,118 @@
+/*
+* Randomness test battery tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"math"
+	"math/big"
+	"sync"
+	"testing"
+)
+
+// eExpansion returns the first million bits of the binary expansion of e, the sequence
+// of SP 800-22 Appendix B, computed as e - 1 = p/q by binary splitting of Σ 1/k!
+var eExpansion = sync.OnceValue(func() []byte {
+	const bits = 1000000
+	var split func(a, b int64) (*big.Int, *big.Int)
+	split = func(a, b int64) (*big.Int, *big.Int) {
+		if b-a == 1 {
+			return big.NewInt(1), big.NewInt(b)
+		}
+		middle := (a + b) / 2
+		leftP, leftQ := split(a, middle)
+		rightP, rightQ := split(middle, b)
+		p := new(big.Int).Mul(leftP, rightQ)
+		return p.Add(p, rightP), leftQ.Mul(leftQ, rightQ)
+	}
+	// 80000! exceeds 2^1000000 by far
+	p, q := split(0, 80000)
+	value := new(big.Int).Add(p, q)
+	value.Lsh(value, bits-2)
+	return value.Quo(value, q).FillBytes(make([]byte, bits/8))
+})
+
+func TestRandomnessTestsOnExpansionOfE(t *testing.T) {
+	data := eExpansion()
+	serial1, serial2 := SerialTest(data, 16)
+	cusumForward, cusumBackward := CumulativeSumsTest(data)
+	// P-values of SP 800-22 Appendix B for the expansion of e
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"frequency", MonobitTest(data), 0.953749},
+		{"runs", RunsTest(data), 0.561917},
+		{"approximate entropy, m = 10", ApproximateEntropyTest(data, 10), 0.700073},
+		{"serial, m = 16, first p-value", serial1, 0.766182},
+		{"serial, m = 16, second p-value", serial2, 0.462921},
+		{"cumulative sums, forward", cusumForward, 0.669886},
+		{"cumulative sums, backward", cusumBackward, 0.724265},
+	}
+	for _, test := range tests {
+		if math.Abs(test.got-test.want) > 1e-6 {
+			t.Errorf("%s: p-value %.7f, want %.6f", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestCumulativeSumsPValue(t *testing.T) {
+	tests := []struct {
+		name string
+		z, n float64
+		want float64
+	}{
+		// SP 800-22 2.13.4 and 2.13.8
+		{"ten bits", 4, 10, 0.4116588},
+		{"hundred bits, forward", 16, 100, 0.219194},
+		{"hundred bits, backward", 19, 100, 0.114866},
+		{"no excursion", 0, 100, 1},
+	}
+	for _, test := range tests {
+		if got := cumulativeSumsPValue(test.z, test.n); math.Abs(got-test.want) > 1e-6 {
+			t.Errorf("%s: p-value %.7f, want %.7f", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCountOverlappingPatterns(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		m    int
+		want []int
+	}{
+		{"empty pattern", []byte{0xff}, 0, []int{8}},
+		{"single bits", []byte{0xf0}, 1, []int{4, 4}},
+		{"wrapping pairs", []byte{0xf0}, 2, []int{3, 1, 1, 3}},
+		{"pattern longer than the sequence", []byte{0xa5}, 9, make([]int, 512)},
+	}
+	for _, test := range tests {
+		got := countOverlappingPatterns(test.data, test.m)
+		if len(got) != len(test.want) {
+			t.Fatalf("%s: %d counts, want %d", test.name, len(got), len(test.want))
+		}
+		for idx := range got {
+			if got[idx] != test.want[idx] {
+				t.Errorf("%s: counts %v, want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+/*
+* Analysis reports module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// openResultLog opens the .enclog protocol file next to the image, the protocols of the repeated
+// analyses are appended after the earlier ones instead of overwriting them in place
+func openResultLog(fileName string) (*os.File, error) {
+	return os.OpenFile(fmt.Sprintf("%s.enclog", fileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// ResultLog returns the protocol of the method for the result, the lines the GUI shows and writes to the .enclog file
+func ResultLog(result AnalysisResult, profile Profile) []string {
+	var lines []string
+	if result.Sampling != nil {
+		lines = append(lines, result.Sampling.String())
+	}
+
+	if result.EncToolFound() {
+		lines = append(lines, result.Part1Result)
+	} else {
+		lines = append(lines, fmt.Sprintf("Тест виявлення файлової системи: %s", result.FileSystem))
+		for _, report := range TestReports(result, profile) {
+			lines = append(lines, report.String())
+		}
+
+		if result.Stage2Run() {
+			lines = append(lines, result.Part1Result)
+			lines = append(lines, fmt.Sprintf("Профіль класифікатора: %s. Внески тестів у логарифм відношення правдоподібності: %s", profile.Name, contributionsToReadable(result.Classification.Contributions)))
+			if result.DeterministicMode != nil {
+				lines = append(lines, result.DeterministicMode.String())
+			}
+			lines = append(lines, result.Part2Result)
+		} else {
+			if result.Randomness != nil {
+				lines = append(lines, fmt.Sprintf("Тести випадковості: перевірено %d блоків, мінімальна допустима частка успішних блоків %f. Частки успішних блоків: %s", result.Randomness.BlocksTested, result.Randomness.MinPassRate, randomnessResultToReadable(*result.Randomness)))
+			}
+			lines = append(lines, result.Part1Result)
+		}
+	}
+	if result.Geometry != nil {
+		lines = append(lines, result.Geometry.String())
+	}
+	return lines
+}
+
+// AnalyzeImageLogged runs the analysis with the protocol written to the .enclog file of the image,
+// as the GUI does for a single image
+func AnalyzeImageLogged(ctx context.Context, fileName string, options AnalysisOptions, profile Profile) (AnalysisResult, error) {
+	logFileHandle, err := openResultLog(fileName)
+	if err != nil {
+		return AnalysisResult{}, err
+	}
+	defer logFileHandle.Close()
+	logger := log.New(logFileHandle, "", log.LstdFlags)
+
+	logger.Printf("Аналіз образу %s, розмір блоку: %d байтів, потоків: %d.", fileName, options.BlockSize, options.Workers)
+	result, err := AnalyzeImage(ctx, fileName, options, profile, logger)
+	if errors.Is(err, context.Canceled) {
+		logger.Println("Аналіз призупинено. Повторний запуск продовжить аналіз блоків з останньої контрольної точки.")
+		return result, err
+	} else if err != nil {
+		logger.Printf("Помилка аналізу: %s", err)
+		return result, err
+	}
+	for _, line := range ResultLog(result, profile) {
+		logger.Println(line)
+	}
+	return result, nil
+}
+
+// runAnalyzeCommand analyses the images from the console as the GUI does: the protocol is written
+// to the .enclog file of every image and printed, and the analyses are recorded in the history
+func runAnalyzeCommand(args []string) int {
+	flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+	profileFile := flags.String("profile", defaultProfileFile, "профіль класифікатора")
+	reportFile := flags.String("report", "", "JSON-файл, до якого записується звіт аналізу (лише для одного образу)")
+	noHistory := flags.Bool("no-history", false, "не записувати аналізи до журналу")
+	analysis := addAnalysisFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Використання: analyze [параметри] <образ>...")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	options, err := analysis.Options()
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if *reportFile != "" && flags.NArg() > 1 {
+		fmt.Println("Звіт (-report) записується лише для одного образу.")
+		return 2
+	}
+
+	profile, err := LoadProfile(*profileFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("Не вдалося завантажити профіль, використано типовий: %s\n", err)
+	}
+
+	var history *History
+	if !*noHistory {
+		if history, err = OpenHistory(); err != nil {
+			fmt.Printf("Не вдалося відкрити журнал аналізів: %s\n", err)
+		}
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	exitCode := 0
+	for _, fileName := range flags.Args() {
+		started := time.Now()
+		result, err := AnalyzeImageLogged(ctx, fileName, options, profile)
+		if err != nil {
+			fmt.Printf("%s: %s\n", fileName, err)
+			if ctx.Err() != nil {
+				return 1
+			}
+			exitCode = 1
+			continue
+		}
+		for _, line := range ResultLog(result, profile) {
+			fmt.Println(line)
+		}
+
+		report := NewAnalysisReport(result, profile, time.Since(started))
+		if history != nil {
+			if _, err := history.Add(report); err != nil {
+				fmt.Printf("Не вдалося записати аналіз до журналу: %s\n", err)
+				exitCode = 1
+			}
+		}
+		if *reportFile != "" {
+			if err := report.Save(*reportFile); err != nil {
+				fmt.Printf("Не вдалося записати звіт %s: %s\n", *reportFile, err)
+				exitCode = 1
+			}
+		}
+	}
+	return exitCode
+}
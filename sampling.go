@@ -0,0 +1,363 @@
+/*
+* Statistical sampling (fast triage) module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"slices"
+)
+
+const (
+	RandomSampling     = "random"
+	StratifiedSampling = "stratified"
+	// Candidate blocks per sampled block, the empty ones are skipped like in the optimized image
+	samplingCandidates      = 4
+	samplingBootstrapRounds = 200
+)
+
+type SamplingOptions struct {
+	// Number of blocks to read, the whole image is analysed when zero
+	Blocks   int
+	Strategy string
+	// Seed of the block choice and of the bootstrap, the same seed reads the same blocks
+	Seed uint64
+	// Run the full analysis when the sampled result is borderline
+	Escalate bool
+}
+
+func DefaultSamplingOptions() SamplingOptions {
+	return SamplingOptions{Strategy: StratifiedSampling, Seed: 1, Escalate: true}
+}
+
+func (o SamplingOptions) Enabled() bool {
+	return o.Blocks > 0
+}
+
+type ConfidenceInterval struct {
+	Low  float64
+	High float64
+}
+
+func (c ConfidenceInterval) Contains(value float64) bool {
+	return c.Low <= value && value <= c.High
+}
+
+func (c ConfidenceInterval) String() string {
+	return fmt.Sprintf("95%% довірчий інтервал [%f; %f]", c.Low, c.High)
+}
+
+// SamplingResult describes the sample behind the statistics of an AnalysisResult
+type SamplingResult struct {
+	Strategy string
+	Seed     uint64
+	// Number of non-empty blocks that were analysed
+	Blocks int
+	// Bootstrap intervals of the statistics of the parallel analysers
+	Intervals map[string]ConfidenceInterval
+	// Bootstrap interval of the Stage 2 probability of encryption
+	Probability *ConfidenceInterval
+	// The reason for the full analysis when the sampled result was borderline
+	Borderline string
+}
+
+func (s SamplingResult) String() string {
+	text := fmt.Sprintf("Вибірка: %d блоків (%s, зерно %d).", s.Blocks, s.Strategy, s.Seed)
+	if s.Probability != nil {
+		text += fmt.Sprintf(" Ймовірність шифрування за вибіркою: %s.", s.Probability)
+	}
+	if s.Borderline != "" {
+		text += " Результат на межі: " + s.Borderline + ", виконано повний аналіз."
+	}
+	return text
+}
+
+// distinctBlocks picks count distinct block indices from [low, high) in random order
+func distinctBlocks(rng *rand.Rand, low int64, high int64, count int) []int64 {
+	size := high - low
+	if int64(count) >= size/2 {
+		var blocks []int64
+		for _, offset := range rng.Perm(int(size)) {
+			blocks = append(blocks, low+int64(offset))
+		}
+		return blocks[:min(int64(count), size)]
+	}
+
+	chosen := make(map[int64]bool, count)
+	blocks := make([]int64, 0, count)
+	for len(blocks) < count {
+		block := low + rng.Int64N(size)
+		if !chosen[block] {
+			chosen[block] = true
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// sampleBlockGroups chooses the blocks of the sample. Every group yields one block, its first
+// index is the choice and the rest are the replacements for an empty block. Stratified sampling
+// takes a group from each of the equal parts of the image, random sampling spreads them freely.
+func sampleBlockGroups(options SamplingOptions, totalBlocks int64) ([][]int64, error) {
+	rng := rand.New(rand.NewPCG(options.Seed, 0))
+	var groups [][]int64
+
+	switch options.Strategy {
+	case StratifiedSampling:
+		strata := min(int64(options.Blocks), totalBlocks)
+		for stratum := int64(0); stratum < strata; stratum++ {
+			low, high := stratum*totalBlocks/strata, (stratum+1)*totalBlocks/strata
+			groups = append(groups, distinctBlocks(rng, low, high, samplingCandidates))
+		}
+	case RandomSampling:
+		blocks := distinctBlocks(rng, 0, totalBlocks, options.Blocks*samplingCandidates)
+		groupCount := min(options.Blocks, len(blocks))
+		groups = make([][]int64, groupCount)
+		for idx, block := range blocks {
+			groups[idx%groupCount] = append(groups[idx%groupCount], block)
+		}
+		// Reading in the order of the image keeps the seeks short
+		slices.SortFunc(groups, func(first []int64, second []int64) int {
+			return cmp.Compare(first[0], second[0])
+		})
+	default:
+		return nil, fmt.Errorf("невідомий спосіб вибірки %q", options.Strategy)
+	}
+	return groups, nil
+}
+
+// recordingSink keeps the partial results of the blocks for the bootstrap
+type recordingSink struct {
+	ParallelSink
+	partials []any
+}
+
+func (s *recordingSink) Merge(partial any) {
+	s.partials = append(s.partials, partial)
+	s.ParallelSink.Merge(partial)
+}
+
+func (s *recordingSink) Update(block []byte) {
+	s.Merge(s.ProcessBlock(block))
+}
+
+type sampledAnalyzer struct {
+	registration AnalyzerRegistration
+	// Nil for a CountsAnalyzer, which is resampled through the byte histogram
+	recorder   *recordingSink
+	statistics []float64
+}
+
+func percentileInterval(values []float64) ConfidenceInterval {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	last := len(sorted) - 1
+	return ConfidenceInterval{
+		Low:  sorted[int(0.025*float64(last))],
+		High: sorted[int(0.975*float64(last)+0.5)],
+	}
+}
+
+// bootstrapIntervals resamples the blocks with replacement, merging the recorded partial results
+// into fresh analysers and byte histograms. All the analysers of a round get the same blocks, so the rounds
+// also give the interval of the Stage 2 probability, the statistics that cannot be resampled stay fixed.
+func bootstrapIntervals(sampled []*sampledAnalyzer, histogram *recordingSink, run AnalyzerRun, profile Profile, statistics map[string]float64, sampling *SamplingResult) {
+	blockCount := len(histogram.partials)
+	if len(sampled) == 0 || blockCount < 2 {
+		return
+	}
+	rng := rand.New(rand.NewPCG(run.Options.Sampling.Seed, 1))
+
+	var probabilities []float64
+	for round := 0; round < samplingBootstrapRounds; round++ {
+		blocks := make([]int, blockCount)
+		for idx := range blocks {
+			blocks[idx] = rng.IntN(blockCount)
+		}
+
+		roundHistogram := NewHistogramSink()
+		for _, block := range blocks {
+			roundHistogram.Merge(histogram.partials[block])
+		}
+		counts := streamCounters{histogram: roundHistogram}.Counts()
+
+		roundStatistics := maps.Clone(statistics)
+		for _, entry := range sampled {
+			analyzer := entry.registration.New(entry.registration.analyzerInfo, run)
+			if entry.recorder != nil {
+				processor := analyzer.(blockProcessor)
+				for _, block := range blocks {
+					processor.Merge(entry.recorder.partials[block])
+				}
+			}
+			roundStatistics[entry.registration.name] = analyzer.Finalize(counts)
+			entry.statistics = append(entry.statistics, roundStatistics[entry.registration.name])
+		}
+		probabilities = append(probabilities, Classify(profile.WithOptions(run.Options), roundStatistics).Probability)
+	}
+
+	for _, entry := range sampled {
+		sampling.Intervals[entry.registration.name] = percentileInterval(entry.statistics)
+	}
+	probability := percentileInterval(probabilities)
+	sampling.Probability = &probability
+}
+
+// borderlineReason explains why the sampled decision may change with the whole image,
+// it is empty when the intervals are clear of the thresholds the decision depends on
+func borderlineReason(result AnalysisResult, profile Profile) string {
+	if result.EncToolFound() {
+		return ""
+	}
+	if result.HasFileSystem() {
+		interval, ok := result.Sampling.Intervals[AutocorrelationTestName]
+		if ok && interval.Contains(profile.Tests[AutocorrelationTestName].Threshold) {
+			return fmt.Sprintf("автокореляція, %s містить поріг %f", interval, profile.Tests[AutocorrelationTestName].Threshold)
+		}
+		return ""
+	}
+	if result.Sampling.Probability != nil && result.Sampling.Probability.Contains(profile.DecisionThreshold) {
+		return fmt.Sprintf("ймовірність шифрування, %s містить поріг %f", result.Sampling.Probability, profile.DecisionThreshold)
+	}
+	return ""
+}
+
+// analyzeSample runs the method on a sample of the blocks of the image. The optimized image
+// is sampled when it exists, otherwise the image itself is, skipping the empty blocks.
+func analyzeSample(ctx context.Context, fileName string, options AnalysisOptions, profile Profile, errorLogger *log.Logger) (AnalysisResult, error) {
+	result := AnalysisResult{FileName: fileName, Options: options, Statistics: map[string]float64{}, TestDetails: map[string]string{}}
+	sampledfname := optimizedFileName(fileName)
+	if _, err := os.Stat(sampledfname); errors.Is(err, os.ErrNotExist) {
+		errorLogger.Printf("Оптимізований файл %s не знайдено, вибірка робиться з образу.", sampledfname)
+		sampledfname = fileName
+	}
+
+	encToolResult, _, err := EncToolDetection(ctx, fileName, options, false, true)
+	if err != nil {
+		return result, err
+	}
+	result.EncToolResult = encToolResult
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	result.FileSystem = detectFileSystem(fileName, options.Progress)
+
+	fileStat, err := os.Stat(sampledfname)
+	if err != nil {
+		return result, err
+	}
+	totalBlocks := (fileStat.Size() + int64(options.BlockSize) - 1) / int64(options.BlockSize)
+	groups, err := sampleBlockGroups(options.Sampling, totalBlocks)
+	if err != nil {
+		return result, err
+	}
+
+	run := AnalyzerRun{FileName: sampledfname, Options: options, ErrorLogger: errorLogger}
+	var sinks []BlockSink
+	var sampled []*sampledAnalyzer
+	var analyzerSinks []*analyzerSink
+	for _, registration := range analyzerRegistry {
+		if !registration.Sampled {
+			continue
+		}
+		sink, statisticSink := newAnalyzerSink(registration.New(registration.analyzerInfo, run))
+		analyzerSinks = append(analyzerSinks, statisticSink)
+		if sink == nil {
+			sampled = append(sampled, &sampledAnalyzer{registration: registration})
+			continue
+		}
+		// The analysers with dependent blocks run on the sample as well, without an interval
+		parallelSink, ok := sink.(ParallelSink)
+		if !ok {
+			sinks = append(sinks, sink)
+			continue
+		}
+		recorder := &recordingSink{ParallelSink: parallelSink}
+		sinks = append(sinks, recorder)
+		sampled = append(sampled, &sampledAnalyzer{registration: registration, recorder: recorder})
+	}
+	encToolScan, err := NewEncToolScanSink()
+	if err != nil {
+		return result, err
+	}
+	duplicates := NewDuplicateBlockSink()
+	// The byte pair analysers are not sampled, only the histogram is counted and recorded for the bootstrap
+	counters := streamCounters{histogram: NewHistogramSink()}
+	histogram := &recordingSink{ParallelSink: counters.histogram}
+	sinks = append(sinks, encToolScan, duplicates, histogram)
+
+	streamOptions := StreamOptions{BlockSize: options.BlockSize, Workers: options.Workers, Progress: options.Progress, Sample: groups}
+	readBytesCount, err := StreamFile(ctx, sampledfname, streamOptions, sinks...)
+	if err != nil {
+		return result, err
+	}
+	result.ReadBytesCount = readBytesCount
+	for sigType, matches := range encToolScan.Found {
+		result.EncToolResult[sigType] += matches
+	}
+	counts := counters.Counts()
+	collectAnalyzerStatistics(analyzerSinks, counts, &result)
+	result.Histogram = &counts.Histogram
+	if !result.HasFileSystem() {
+		result.DeterministicMode = &duplicates.Result
+	}
+
+	result.Sampling = &SamplingResult{
+		Strategy:  options.Sampling.Strategy,
+		Seed:      options.Sampling.Seed,
+		Intervals: make(map[string]ConfidenceInterval),
+	}
+	result.Sampling.Blocks = len(histogram.partials)
+	bootstrapIntervals(sampled, histogram, run, profile, result.Statistics, result.Sampling)
+
+	if !result.EncToolFound() && result.HasFileSystem() && lowAutocorrelation(result, profile) {
+		if err := collectRandomness(ctx, sampledfname, &result); err != nil {
+			return result, err
+		}
+	}
+	DecideEncryption(&result, profile)
+	// The offsets of the sample are not those of the image, only the headers at fixed sectors are located
+	return result, collectImageLocations(&result, nil)
+}
+
+// analyzeWithSampling runs the sampled analysis and escalates to the full one when the result is borderline
+func analyzeWithSampling(ctx context.Context, fileName string, options AnalysisOptions, profile Profile, errorLogger *log.Logger) (AnalysisResult, error) {
+	result, err := analyzeSample(ctx, fileName, options, profile, errorLogger)
+	if err != nil || !options.Sampling.Escalate {
+		return result, err
+	}
+	reason := borderlineReason(result, profile)
+	if reason == "" {
+		return result, nil
+	}
+
+	errorLogger.Printf("Результат вибірки для %s на межі (%s), виконується повний аналіз.", fileName, reason)
+	sampling := result.Sampling
+	sampling.Borderline = reason
+	options.Sampling.Blocks = 0
+	result, err = AnalyzeImage(ctx, fileName, options, profile, errorLogger)
+	result.Sampling = sampling
+	return result, err
+}
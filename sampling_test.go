@@ -0,0 +1,116 @@
+/*
+* Block sampling tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestSampleBlockGroups(t *testing.T) {
+	const totalBlocks = 1000
+	for _, strategy := range []string{StratifiedSampling, RandomSampling} {
+		t.Run(strategy, func(t *testing.T) {
+			options := SamplingOptions{Blocks: 50, Strategy: strategy, Seed: 42}
+			first, err := sampleBlockGroups(options, totalBlocks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, _ := sampleBlockGroups(options, totalBlocks)
+			if !reflect.DeepEqual(first, second) {
+				t.Error("the same seed chose other blocks")
+			}
+			options.Seed++
+			if other, _ := sampleBlockGroups(options, totalBlocks); reflect.DeepEqual(first, other) {
+				t.Error("another seed chose the same blocks")
+			}
+
+			if len(first) != 50 {
+				t.Fatalf("%d groups, want 50", len(first))
+			}
+			seen := make(map[int64]bool)
+			for idx, group := range first {
+				for _, block := range group {
+					if block < 0 || block >= totalBlocks || seen[block] {
+						t.Fatalf("group %d: block %d outside the image or chosen twice", idx, block)
+					}
+					seen[block] = true
+					// Every stratum has 20 blocks
+					if strategy == StratifiedSampling && block/20 != int64(idx) {
+						t.Errorf("group %d: block %d outside its stratum", idx, block)
+					}
+				}
+			}
+		})
+	}
+	if _, err := sampleBlockGroups(SamplingOptions{Blocks: 5, Strategy: "other"}, totalBlocks); err == nil {
+		t.Error("an unknown strategy was accepted")
+	}
+}
+
+func TestSampledStatisticsSeed(t *testing.T) {
+	const blockSize = 4096
+	fileName, _ := writeTestImage(t, 200*blockSize, 11)
+
+	var sampledRegistrations []AnalyzerRegistration
+	for _, registration := range analyzerRegistry {
+		if registration.name == EntropyTestName || registration.name == ChiSqTestName {
+			sampledRegistrations = append(sampledRegistrations, registration)
+		}
+	}
+	sample := func(seed uint64) (ByteHistogram, SamplingResult) {
+		options := DefaultAnalysisOptions()
+		options.BlockSize = blockSize
+		options.Sampling.Blocks = 30
+		options.Sampling.Seed = seed
+		groups, err := sampleBlockGroups(options.Sampling, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		histogram := &recordingSink{ParallelSink: NewHistogramSink()}
+		if _, err := StreamFile(context.Background(), fileName, StreamOptions{BlockSize: blockSize, Workers: 4, Sample: groups}, histogram); err != nil {
+			t.Fatal(err)
+		}
+
+		var sampled []*sampledAnalyzer
+		for _, registration := range sampledRegistrations {
+			sampled = append(sampled, &sampledAnalyzer{registration: registration})
+		}
+		result := SamplingResult{Intervals: make(map[string]ConfidenceInterval)}
+		run := AnalyzerRun{FileName: fileName, Options: options}
+		bootstrapIntervals(sampled, histogram, run, DefaultProfile(), map[string]float64{}, &result)
+		return histogram.ParallelSink.(*HistogramSink).Counter, result
+	}
+
+	firstHistogram, firstResult := sample(5)
+	secondHistogram, secondResult := sample(5)
+	if firstHistogram != secondHistogram {
+		t.Error("the same seed sampled other bytes")
+	}
+	if !reflect.DeepEqual(firstResult, secondResult) {
+		t.Errorf("the same seed gave other intervals: %v and %v", firstResult.Intervals, secondResult.Intervals)
+	}
+	if len(firstResult.Intervals) != len(sampledRegistrations) || firstResult.Probability == nil {
+		t.Errorf("intervals %v, probability %v", firstResult.Intervals, firstResult.Probability)
+	}
+	if otherHistogram, _ := sample(6); otherHistogram == firstHistogram {
+		t.Error("another seed sampled the same bytes")
+	}
+}
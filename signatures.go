@@ -19,6 +19,8 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -26,6 +28,8 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"slices"
+	"sync"
 
 	"github.com/BurntSushi/rure-go"
 )
@@ -48,6 +52,26 @@ func FindBytesPattern(data string, regex *rure.Regex) int {
 	return len(matches) / 2
 }
 
+// SignatureHit is a signature found in a file, the offset and length are in bytes
+type SignatureHit struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+	Length int    `json:"length"`
+}
+
+// findSignatureHits locates up to limit matches in the hex dump of a block,
+// the matches starting in the middle of a byte are counted by FindBytesPattern but have no offset
+func findSignatureHits(name string, hexData string, regex *rure.Regex, limit int) []SignatureHit {
+	var hits []SignatureHit
+	matches := regex.FindAll(hexData)
+	for idx := 0; idx+1 < len(matches) && len(hits) < limit; idx += 2 {
+		if matches[idx]%2 == 0 {
+			hits = append(hits, SignatureHit{Name: name, Offset: int64(matches[idx] / 2), Length: (matches[idx+1] - matches[idx] + 1) / 2})
+		}
+	}
+	return hits
+}
+
 // sum calculates the sum of all values in a map[string]int
 func sum(m map[string]int) int {
 	total := 0
@@ -65,23 +89,30 @@ func foundSignaturesTotalToReadable(foundSignaturesTotal map[string]int) string
 	return readable
 }
 
-func EncToolDetection(fileName string, blockSize int, hailMaryMode bool) map[string]int {
-	signatures := make(map[string]AdvancedSignatureMap)
+// Encryption tool headers, sector 0 means that the header may be anywhere in the image
+var encToolPatterns = map[string]SignatureData{
+	"FreeBSD GELI": {"(?i)(47454f4d3a3a454c49)", -1},
+	"BitLocker":    {"(?i)(eb58902d4656452d46532d0002080000)", 1},
+	"LUKSv1":       {"(?i)4c554b53babe0001", 1},
+	"LUKSv2":       {"(?i)4c554b53babe0002", 1},
+	"FileVault v2": {"(?i)41505342.{456}0800000000000000", 0},
+	"PGP WDE":      {"(?i)(eb489050475047554152440000000000)", 1},
+}
 
-	patterns := map[string]SignatureData{
-		"FreeBSD GELI": {"(?i)(47454f4d3a3a454c49)", -1},
-		"BitLocker":    {"(?i)(eb58902d4656452d46532d0002080000)", 1},
-		"LUKSv1":       {"(?i)4c554b53babe0001", 1},
-		"LUKSv2":       {"(?i)4c554b53babe0002", 1},
-		"FileVault v2": {"(?i)41505342.{456}0800000000000000", 0},
-		"PGP WDE":      {"(?i)(eb489050475047554152440000000000)", 1},
-	}
+// EncToolDetection searches the image for the headers of disk encryption tools,
+// the search stops early without an error once ctx is cancelled. The headers without a fixed
+// sector are searched in a pass over the whole image that also feeds the sinks, its hits are
+// returned for the locations of the headers. With sampled set only the headers at fixed sectors
+// are checked, the others are searched by an EncToolScanSink.
+func EncToolDetection(ctx context.Context, fileName string, options AnalysisOptions, hailMaryMode bool, sampled bool, sinks ...BlockSink) (map[string]int, []SignatureHit, error) {
+	blockSize := options.BlockSize
+	signatures := make(map[string]AdvancedSignatureMap)
 
 	foundSignaturesTotal := make(map[string]int)
-	for name, pattern := range patterns {
+	for name, pattern := range encToolPatterns {
 		regex, err := rure.Compile(pattern.regex)
 		if err != nil {
-			log.Fatalf("failed to compile pattern for %s: %v", name, err)
+			return nil, nil, fmt.Errorf("failed to compile pattern for %s: %w", name, err)
 		}
 		signatures[name] = AdvancedSignatureMap{regex: regex, sector: pattern.sector}
 		foundSignaturesTotal[name] = 0
@@ -89,28 +120,26 @@ func EncToolDetection(fileName string, blockSize int, hailMaryMode bool) map[str
 
 	file, err := os.Open(fileName)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
-	defer func(file *os.File) {
-		fileCloseErr := file.Close()
-		if fileCloseErr != nil {
-			log.Fatal(fileCloseErr)
-		}
-	}(file)
+	defer file.Close()
 
 	buffer := make([]byte, blockSize)
 
+	var hits []SignatureHit
 	if hailMaryMode {
-		buffer := make([]byte, blockSize)
-		n := 0
-		for {
+		fileStat, err := file.Stat()
+		if err != nil {
+			return nil, nil, err
+		}
+		tracker := startPhase(options.Progress, EncToolPhase, fileStat.Size())
+		defer tracker.Finish()
+		for ctx.Err() == nil {
 			bytesRead, err := file.Read(buffer)
 			if bytesRead == 0 || err != nil {
 				break
 			}
-
-			n += bytesRead
-			fmt.Printf("%.1f ", float32(n)/1048576)
+			tracker.Add(bytesRead)
 
 			// Convert bytes to hex string
 			hexData := hex.EncodeToString(buffer[:bytesRead])
@@ -119,9 +148,24 @@ func EncToolDetection(fileName string, blockSize int, hailMaryMode bool) map[str
 					foundSignaturesTotal[sigType] += FindBytesPattern(hexData, entry.regex)
 				}
 			}
-			fmt.Print("\r")
 		}
 	} else {
+		if !sampled {
+			// The headers at fixed sectors take a block each, only the pass is reported
+			scan, err := NewEncToolScanSink()
+			if err != nil {
+				return nil, nil, err
+			}
+			scan.MaxHits = maxSignatureHits
+			streamOptions := StreamOptions{BlockSize: blockSize, Workers: options.Workers, Progress: options.Progress, Phase: EncToolPhase}
+			if _, err := StreamFile(ctx, fileName, streamOptions, append([]BlockSink{scan}, sinks...)...); err != nil && ctx.Err() == nil {
+				return nil, nil, err
+			}
+			for sigType, matches := range scan.Found {
+				foundSignaturesTotal[sigType] += matches
+			}
+			hits = scan.Hits
+		}
 
 		for sigType := range signatures {
 			if entry, ok := signatures[sigType]; ok {
@@ -130,49 +174,47 @@ func EncToolDetection(fileName string, blockSize int, hailMaryMode bool) map[str
 				var seekErr error
 
 				if skip == 0 {
-					buffer := make([]byte, blockSize)
-					n := 0
-					for {
-						bytesRead, err := file.Read(buffer)
-						if bytesRead == 0 || err != nil {
-							break
-						}
-
-						n += bytesRead
-						fmt.Printf("%.1f ", float32(n)/1048576)
-
-						// Convert bytes to hex string
-						hexData := hex.EncodeToString(buffer[:bytesRead])
-						foundSignaturesTotal[sigType] += FindBytesPattern(hexData, entry.regex)
-						fmt.Print("\r")
-					}
-				} else if skip != 0 {
-					if skip < 0 {
-						_, seekErr = file.Seek(int64(blockSize*int(math.Abs(float64(skip))-2)), 2)
-					} else if skip > 0 {
-						skip = skip - 1
-						_, seekErr = file.Seek(int64(blockSize-1)*skip, 0)
-					}
-					if seekErr != nil {
-						log.Fatalln("Seek error: ", seekErr)
-					}
-					bytesRead, fileReadErr := file.Read(buffer)
-					if bytesRead == 0 || fileReadErr != nil {
-						break
-					}
-					hexData := hex.EncodeToString(buffer[:bytesRead])
-					foundSignaturesTotal[sigType] += FindBytesPattern(hexData, entry.regex)
-					_, returnSeekErr := file.Seek(0, 0)
-					if returnSeekErr != nil {
-						log.Fatalln("Return seek error: ", returnSeekErr)
-					}
+					continue
+				}
+				if skip < 0 {
+					_, seekErr = file.Seek(int64(blockSize*int(math.Abs(float64(skip))-2)), 2)
+				} else if skip > 0 {
+					skip = skip - 1
+					_, seekErr = file.Seek(int64(blockSize-1)*skip, 0)
+				}
+				if seekErr != nil {
+					return nil, nil, fmt.Errorf("seek error: %w", seekErr)
+				}
+				bytesRead, fileReadErr := file.Read(buffer)
+				if bytesRead == 0 || fileReadErr != nil {
+					break
+				}
+				hexData := hex.EncodeToString(buffer[:bytesRead])
+				foundSignaturesTotal[sigType] += FindBytesPattern(hexData, entry.regex)
+				_, returnSeekErr := file.Seek(0, 0)
+				if returnSeekErr != nil {
+					return nil, nil, fmt.Errorf("return seek error: %w", returnSeekErr)
 				}
 			}
 		}
 	}
-	fmt.Print("\r")
-	fmt.Println(foundSignaturesTotalToReadable(foundSignaturesTotal))
-	return foundSignaturesTotal
+	return foundSignaturesTotal, hits, nil
+}
+
+// NewEncToolScanSink searches the blocks for the encryption tool headers without a fixed sector
+func NewEncToolScanSink() (*SignatureSink, error) {
+	sink := &SignatureSink{Found: make(map[string]int), signatures: make(SignatureMap)}
+	for name, pattern := range encToolPatterns {
+		if pattern.sector != 0 {
+			continue
+		}
+		regex, err := rure.Compile(pattern.regex)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile pattern for %s: %w", name, err)
+		}
+		sink.signatures[name] = regex
+	}
+	return sink, nil
 }
 
 // getSignatures initializes and returns the signature patterns
@@ -746,74 +788,139 @@ func getSignatures() (SignatureMap, error) {
 	return signatures, nil
 }
 
-func SignatureAnalysis(fileName string, blockSize int) float64 {
-	signatures, err := getSignatures()
+// SignatureSink counts the file type signatures in the stream, the result is the number of signatures per MiB
+type SignatureSink struct {
+	Found     map[string]int
+	BytesRead int
+	Result    float64
+	// The first MaxHits signatures of the stream are located in Hits, none when MaxHits is zero.
+	// The offsets are those of the stream, so they are meaningless for a sample.
+	MaxHits    int
+	Hits       []SignatureHit
+	signatures SignatureMap
+}
+
+// The compiled signatures are shared by all the sinks, the sampling bootstrap creates many of them
+var sharedSignatures = sync.OnceValues(getSignatures)
+
+func NewSignatureSink() (*SignatureSink, error) {
+	signatures, err := sharedSignatures()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	foundSignaturesTotal := make(map[string]int)
+	found := make(map[string]int)
 	for sigType := range signatures {
-		foundSignaturesTotal[sigType] = 0
+		found[sigType] = 0
 	}
+	return &SignatureSink{Found: found, signatures: signatures}, nil
+}
 
-	file, err := os.Open(fileName)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			log.Fatal(err)
+type signatureBlockResult struct {
+	found     map[string]int
+	hits      []SignatureHit
+	bytesRead int
+}
+
+// ProcessBlock counts the signatures of a block, the compiled expressions are safe for concurrent use
+func (s *SignatureSink) ProcessBlock(block []byte) any {
+	partial := signatureBlockResult{found: make(map[string]int), bytesRead: len(block)}
+
+	// Convert bytes to hex string
+	hexData := hex.EncodeToString(block)
+	for sigType, regex := range s.signatures {
+		if matches := FindBytesPattern(hexData, regex); matches > 0 {
+			partial.found[sigType] = matches
+			if s.MaxHits > 0 {
+				partial.hits = append(partial.hits, findSignatureHits(sigType, hexData, regex, s.MaxHits)...)
+			}
 		}
-	}(file)
+	}
+	slices.SortFunc(partial.hits, func(a, b SignatureHit) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+	if len(partial.hits) > s.MaxHits {
+		partial.hits = partial.hits[:s.MaxHits]
+	}
+	return partial
+}
 
-	buffer := make([]byte, blockSize)
-	n := 0
-	for {
-		bytesRead, err := file.Read(buffer)
-		if bytesRead == 0 || err != nil {
+func (s *SignatureSink) Merge(partial any) {
+	blockResult := partial.(signatureBlockResult)
+	for _, hit := range blockResult.hits {
+		if len(s.Hits) >= s.MaxHits {
 			break
 		}
+		hit.Offset += int64(s.BytesRead)
+		s.Hits = append(s.Hits, hit)
+	}
+	s.BytesRead += blockResult.bytesRead
+	for sigType, matches := range blockResult.found {
+		s.Found[sigType] += matches
+	}
+}
 
-		n += bytesRead
-		fmt.Printf("%.1f ", float32(n)/1048576)
+func (s *SignatureSink) Update(block []byte) {
+	s.Merge(s.ProcessBlock(block))
+}
 
-		// Convert bytes to hex string
-		hexData := hex.EncodeToString(buffer[:bytesRead])
-		idx := 0
-		for sigType := range signatures {
-			idx = idx + 1
-			if idx%100 == 0 {
-				fmt.Printf("%d, ", idx)
-			}
+func (s *SignatureSink) Finalize() {
+	if s.BytesRead == 0 {
+		return
+	}
+	s.Result = float64(sum(s.Found)) / (float64(s.BytesRead) / 1048576.0)
+}
 
-			foundSignaturesTotal[sigType] += FindBytesPattern(hexData, signatures[sigType])
-		}
-		fmt.Print("\r")
+type signatureState struct {
+	Found     map[string]int `json:"found"`
+	BytesRead int            `json:"bytes_read"`
+	Hits      []SignatureHit `json:"hits,omitempty"`
+}
+
+func (s *SignatureSink) SaveState() ([]byte, error) {
+	return json.Marshal(signatureState{Found: s.Found, BytesRead: s.BytesRead, Hits: s.Hits})
+}
+
+func (s *SignatureSink) RestoreState(state []byte) error {
+	restored := signatureState{Found: make(map[string]int)}
+	if err := json.Unmarshal(state, &restored); err != nil {
+		return err
 	}
+	s.Found, s.BytesRead, s.Hits = restored.Found, restored.BytesRead, restored.Hits
+	return nil
+}
 
-	// Write results to file
+// writeSignatureTotals stores the per-signature counts in the working directory
+func writeSignatureTotals(fileName string, foundSignaturesTotal map[string]int) error {
 	resultsJSON, err := json.Marshal(foundSignaturesTotal)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	baseFileName := filepath.Base(fileName)
 	outputFileName := baseFileName + "_signatures_total.txt"
 	content := fmt.Sprintf("%s\t%d\t%v", fileName, sum(foundSignaturesTotal), string(resultsJSON))
-	err = os.WriteFile(outputFileName, []byte(content), 0644)
-	if err != nil {
-		log.Fatal(err)
-	}
-	fmt.Print("                                        \r")
+	return os.WriteFile(outputFileName, []byte(content), 0644)
+}
 
-	stat, err := os.Stat(fileName)
+type signatureAnalyzer struct {
+	analyzerInfo
+	*SignatureSink
+}
+
+func newSignatureAnalyzer(info analyzerInfo, run AnalyzerRun) Analyzer {
+	signatureSink, err := NewSignatureSink()
 	if err != nil {
-		fmt.Println(err)
+		log.Fatal(err)
 	}
+	return &signatureAnalyzer{info, signatureSink}
+}
 
-	fileSize := float64(stat.Size()) / 1048576.0
+func (s *signatureAnalyzer) Finalize(counts *StreamCounts) float64 {
+	s.SignatureSink.Finalize()
+	return s.Result
+}
 
-	return float64(sum(foundSignaturesTotal)) / fileSize
+func (s *signatureAnalyzer) Details() string {
+	return fmt.Sprintf("Знайдено сигнатур: %d.", sum(s.Found))
 }
@@ -0,0 +1,65 @@
+/*
+* Special function tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestIgamc(t *testing.T) {
+	tests := []struct {
+		name string
+		a, x float64
+		want float64
+	}{
+		// SP 800-22 2.11.4: P-value1 = igamc(2, 0.8), P-value2 = igamc(1, 0.4)
+		{"serial test example, first p-value", 2, 0.8, 0.808792},
+		{"serial test example, second p-value", 1, 0.4, 0.670320},
+		// SP 800-22 2.12.8: approximate entropy with m = 2, χ² = 5.550792
+		{"approximate entropy example", 2, 5.550792 / 2, 0.235301},
+		{"exponential", 1, 3, math.Exp(-3)},
+		{"complementary error function", 0.5, 2.25, math.Erfc(1.5)},
+		{"series branch", 10, 4, 0.991867757203},
+		{"continued fraction branch", 10, 20, 0.004995412308},
+		{"zero x", 3, 0, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := igamc(test.a, test.x); math.Abs(got-test.want) > 1e-6 {
+				t.Errorf("igamc(%g, %g) = %.9f, want %.9f", test.a, test.x, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNormalCDF(t *testing.T) {
+	tests := []struct {
+		x, want float64
+	}{
+		{0, 0.5},
+		{1.959964, 0.975},
+		{-1.644854, 0.05},
+	}
+	for _, test := range tests {
+		if got := normalCDF(test.x); math.Abs(got-test.want) > 1e-6 {
+			t.Errorf("normalCDF(%g) = %.9f, want %.9f", test.x, got, test.want)
+		}
+	}
+}
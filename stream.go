@@ -0,0 +1,284 @@
+/*
+* Single-pass streaming engine module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// BlockSink is an analyser fed by StreamFile
+type BlockSink interface {
+	// Update receives every block of the file in order, the block must not be retained after the call
+	Update(block []byte)
+	// Finalize is called once at the end of the file
+	Finalize()
+}
+
+// ParallelSink is a BlockSink whose per-block work does not depend on the other blocks,
+// StreamFile runs ProcessBlock on the worker pool and merges the partial results in block order
+type ParallelSink interface {
+	BlockSink
+	// ProcessBlock computes the partial result of a block, it is called concurrently
+	ProcessBlock(block []byte) any
+	// Merge folds the partial result of the next block into the sink
+	Merge(partial any)
+}
+
+type streamBlock struct {
+	index    int
+	data     []byte
+	partials []any
+}
+
+// resolveWorkers returns the worker count to use, all CPU cores when it is not set
+func resolveWorkers(workers int) int {
+	if workers <= 0 {
+		return runtime.NumCPU()
+	}
+	return workers
+}
+
+type StreamOptions struct {
+	BlockSize int
+	// Number of block workers, all CPU cores when zero
+	Workers int
+	// Receives the progress of the read, may be nil
+	Progress ProgressFunc
+	// Phase the progress is reported as, BlockAnalysisPhase when empty
+	Phase string
+	// Saves the state of the sinks at intervals and resumes the pass from it, may be nil
+	Checkpoint *Checkpointer
+	// Groups of block indices to read instead of the whole file, see sampleBlockGroups.
+	// The first non-empty block of every group is streamed, checkpoints are not used then.
+	Sample [][]int64
+}
+
+func isEmptyBlock(block []byte) bool {
+	for _, b := range block {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// readSampleBlock reads the first non-empty block of the group into the buffer
+func readSampleBlock(file *os.File, group []int64, buffer []byte) (int, error) {
+	for _, index := range group {
+		bytesRead, err := file.ReadAt(buffer, index*int64(len(buffer)))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if bytesRead > 0 && !isEmptyBlock(buffer[:bytesRead]) {
+			return bytesRead, nil
+		}
+	}
+	return 0, nil
+}
+
+// StreamFile reads the file once block by block and fans every block out to all sinks.
+// Parallel sinks process the blocks on a pool of workers and their partial results are merged
+// in block order, so the outcome does not depend on the worker count. The other sinks receive
+// the blocks in order, concurrently with each other. All sinks are finalized at the end of the file
+// (or after a read error or cancellation, so that they can release their resources).
+// The context is checked between blocks, the progress of the read is reported as options.Phase.
+// With a checkpointer the pass starts after the bytes of its last checkpoint, and an interrupted
+// pass saves a checkpoint before the sinks are finalized.
+func StreamFile(ctx context.Context, fileName string, options StreamOptions, sinks ...BlockSink) (int, error) {
+	blockSize := options.BlockSize
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	fileStat, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var offset int64
+	if options.Sample != nil {
+		options.Checkpoint = nil
+	}
+	if options.Checkpoint != nil {
+		if offset, err = options.Checkpoint.Restore(blockSize, sinks); err != nil {
+			return 0, err
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+	totalSize := fileStat.Size()
+	if options.Sample != nil {
+		totalSize = min(totalSize, int64(len(options.Sample))*int64(blockSize))
+	}
+	phase := options.Phase
+	if phase == "" {
+		phase = BlockAnalysisPhase
+	}
+	tracker := resumePhase(options.Progress, phase, totalSize, offset)
+
+	workers := resolveWorkers(options.Workers)
+	var parallelSinks []ParallelSink
+	var sequentialSinks []BlockSink
+	for _, sink := range sinks {
+		if parallelSink, ok := sink.(ParallelSink); ok {
+			parallelSinks = append(parallelSinks, parallelSink)
+		} else {
+			sequentialSinks = append(sequentialSinks, sink)
+		}
+	}
+
+	// The buffer pool bounds the number of blocks in flight
+	freeBuffers := make(chan []byte, 2*workers)
+	for idx := 0; idx < cap(freeBuffers); idx++ {
+		freeBuffers <- make([]byte, blockSize)
+	}
+	jobs := make(chan *streamBlock)
+	processed := make(chan *streamBlock)
+
+	readBytesCount := int(offset)
+	var readErr error
+	go func() {
+		defer close(jobs)
+		if options.Sample != nil {
+			index := 0
+			for _, group := range options.Sample {
+				if err := ctx.Err(); err != nil {
+					readErr = err
+					return
+				}
+
+				buffer := <-freeBuffers
+				bytesRead, err := readSampleBlock(file, group, buffer)
+				if err != nil {
+					readErr = err
+					return
+				}
+				if bytesRead == 0 {
+					freeBuffers <- buffer
+					continue
+				}
+				readBytesCount += bytesRead
+				jobs <- &streamBlock{index: index, data: buffer[:bytesRead]}
+				index++
+			}
+			return
+		}
+
+		for index := 0; ; index++ {
+			if err := ctx.Err(); err != nil {
+				readErr = err
+				return
+			}
+
+			buffer := <-freeBuffers
+			bytesRead, err := io.ReadFull(file, buffer)
+			if bytesRead > 0 {
+				readBytesCount += bytesRead
+				jobs <- &streamBlock{index: index, data: buffer[:bytesRead]}
+			}
+
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return
+			} else if err != nil {
+				readErr = err
+				return
+			}
+		}
+	}()
+
+	var workersWg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		workersWg.Add(1)
+		go func() {
+			defer workersWg.Done()
+			for block := range jobs {
+				block.partials = make([]any, len(parallelSinks))
+				for idx, sink := range parallelSinks {
+					block.partials[idx] = sink.ProcessBlock(block.data)
+				}
+				processed <- block
+			}
+		}()
+	}
+	go func() {
+		workersWg.Wait()
+		close(processed)
+	}()
+
+	// Blocks may come out of the pool in any order, they are merged strictly by index
+	pending := make(map[int]*streamBlock)
+	nextIndex := 0
+	mergedBytesCount := offset
+	var wg sync.WaitGroup
+	for block := range processed {
+		pending[block.index] = block
+		for {
+			next, ok := pending[nextIndex]
+			if !ok {
+				break
+			}
+			delete(pending, nextIndex)
+			nextIndex++
+
+			// Progress counts the blocks that went through all the sinks, not the ones merely read
+			tracker.Add(len(next.data))
+			for idx, sink := range parallelSinks {
+				sink.Merge(next.partials[idx])
+			}
+			for _, sink := range sequentialSinks {
+				wg.Add(1)
+				go func(sink BlockSink) {
+					defer wg.Done()
+					sink.Update(next.data)
+				}(sink)
+			}
+			wg.Wait()
+			mergedBytesCount += int64(len(next.data))
+			freeBuffers <- next.data[:cap(next.data)]
+
+			if options.Checkpoint != nil && options.Checkpoint.Due() {
+				options.Checkpoint.saveLogged(blockSize, mergedBytesCount, sinks)
+			}
+		}
+	}
+	if readErr != nil && options.Checkpoint != nil {
+		options.Checkpoint.saveLogged(blockSize, mergedBytesCount, sinks)
+	}
+
+	for _, sink := range sinks {
+		wg.Add(1)
+		go func(sink BlockSink) {
+			defer wg.Done()
+			sink.Finalize()
+		}(sink)
+	}
+	wg.Wait()
+	if readErr == nil {
+		tracker.Finish()
+	}
+	return readBytesCount, readErr
+}
@@ -0,0 +1,151 @@
+/*
+* Block streaming tests module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"hash/crc32"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeTestImage writes pseudo-random data with repeated runs, so that the blocks differ
+func writeTestImage(t *testing.T, size int, seed uint64) (string, []byte) {
+	t.Helper()
+	rng := rand.New(rand.NewPCG(seed, seed))
+	data := make([]byte, size)
+	for idx := range data {
+		if idx%3000 < 1000 {
+			data[idx] = byte(idx / 3000)
+		} else {
+			data[idx] = byte(rng.Uint32())
+		}
+	}
+	fileName := filepath.Join(t.TempDir(), "image.img")
+	if err := os.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName, data
+}
+
+// orderSink records the order in which the partial results are merged
+type orderSink struct {
+	merged []uint32
+}
+
+func (o *orderSink) ProcessBlock(block []byte) any {
+	return crc32.ChecksumIEEE(block)
+}
+
+func (o *orderSink) Merge(partial any) {
+	o.merged = append(o.merged, partial.(uint32))
+}
+
+func (o *orderSink) Update(block []byte) {}
+
+func (o *orderSink) Finalize() {}
+
+// checksumSink is a sequential sink, it checksums the stream as it is updated
+type checksumSink struct {
+	checksum uint32
+}
+
+func (c *checksumSink) Update(block []byte) {
+	c.checksum = crc32.Update(c.checksum, crc32.IEEETable, block)
+}
+
+func (c *checksumSink) Finalize() {}
+
+func TestStreamFileWorkers(t *testing.T) {
+	const blockSize = 4096
+	fileName, data := writeTestImage(t, 300*blockSize+123, 7)
+
+	type streamed struct {
+		bytesRead       int
+		histogram       *HistogramSink
+		bigrams         *BigramSink
+		autocorrelation *AutocorrelationSink
+		order           *orderSink
+		checksum        *checksumSink
+	}
+	stream := func(workers int) streamed {
+		result := streamed{
+			histogram:       NewHistogramSink(),
+			bigrams:         NewBigramSink(),
+			autocorrelation: NewAutocorrelationSink(blockSize, DefaultAutocorrelationOptions()),
+			order:           &orderSink{},
+			checksum:        &checksumSink{},
+		}
+		var err error
+		result.bytesRead, err = StreamFile(context.Background(), fileName, StreamOptions{BlockSize: blockSize, Workers: workers},
+			result.histogram, result.bigrams, result.autocorrelation, result.order, result.checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	single := stream(1)
+	if single.bytesRead != len(data) {
+		t.Errorf("%d bytes read, want %d", single.bytesRead, len(data))
+	}
+	if single.histogram.Counter != countBytes(data) {
+		t.Error("histogram differs from the counts of the data")
+	}
+	if single.bigrams.PairsRead != len(data)-1 {
+		t.Errorf("%d pairs counted, want %d", single.bigrams.PairsRead, len(data)-1)
+	}
+	if single.checksum.checksum != crc32.ChecksumIEEE(data) {
+		t.Error("the sequential sink did not receive the blocks in order")
+	}
+
+	for _, workers := range []int{2, 8} {
+		parallel := stream(workers)
+		if parallel.bytesRead != single.bytesRead {
+			t.Errorf("%d workers: %d bytes read, want %d", workers, parallel.bytesRead, single.bytesRead)
+		}
+		if parallel.histogram.Counter != single.histogram.Counter {
+			t.Errorf("%d workers: histogram differs", workers)
+		}
+		if *parallel.bigrams.Pairs != *single.bigrams.Pairs || parallel.bigrams.PairsRead != single.bigrams.PairsRead {
+			t.Errorf("%d workers: byte pairs differ", workers)
+		}
+		if parallel.autocorrelation.Result != single.autocorrelation.Result || !slices.Equal(parallel.autocorrelation.LagMeans, single.autocorrelation.LagMeans) {
+			t.Errorf("%d workers: autocorrelation %f, want %f", workers, parallel.autocorrelation.Result, single.autocorrelation.Result)
+		}
+		if !slices.Equal(parallel.order.merged, single.order.merged) {
+			t.Errorf("%d workers: blocks merged out of order", workers)
+		}
+		if parallel.checksum.checksum != single.checksum.checksum {
+			t.Errorf("%d workers: the sequential sink did not receive the blocks in order", workers)
+		}
+	}
+}
+
+func TestStreamFileCancelled(t *testing.T) {
+	fileName, _ := writeTestImage(t, 64*1024, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := StreamFile(ctx, fileName, StreamOptions{BlockSize: 4096}, NewHistogramSink()); err == nil {
+		t.Error("the cancelled stream returned no error")
+	}
+}
@@ -0,0 +1,382 @@
+/*
+* Synthetic ground-truth image generator module
+* Copyright (C) 2025  Artem Stefankiv
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/xts"
+)
+
+const (
+	// dm-crypt encrypts 512-byte sectors with aes-xts-plain64 by default
+	fdeSectorSize = 512
+	// fscrypt encrypts file contents in 4096-byte data units
+	fbeDataUnitSize = 4096
+	// Share of the image capacity filled with files
+	syntheticFillRatio = 0.6
+)
+
+var syntheticWords = []string{
+	"шифрування", "диск", "образ", "файл", "система", "блок", "ключ", "дані", "сектор", "аналіз",
+	"the", "disk", "image", "encryption", "file", "system", "block", "key", "data", "sector",
+}
+
+type fileKind struct {
+	extension string
+	generate  func(rng *rand.Rand, size int) []byte
+}
+
+var syntheticFileKinds = map[string]fileKind{
+	"text":   {"TXT", generateText},
+	"csv":    {"CSV", generateCSV},
+	"gzip":   {"GZ", generateGzip},
+	"bitmap": {"BMP", generateBitmap},
+}
+
+// File mixes as shares of the file kinds
+var syntheticMixes = map[string]map[string]float64{
+	"documents": {"text": 0.6, "csv": 0.4},
+	"archives":  {"gzip": 0.7, "text": 0.3},
+	"media":     {"bitmap": 0.6, "gzip": 0.2, "text": 0.2},
+}
+
+func generateText(rng *rand.Rand, size int) []byte {
+	var buffer bytes.Buffer
+	for buffer.Len() < size {
+		for wordIdx := rng.IntN(12) + 3; wordIdx > 0; wordIdx-- {
+			buffer.WriteString(syntheticWords[rng.IntN(len(syntheticWords))])
+			buffer.WriteByte(' ')
+		}
+		buffer.WriteString(".\n")
+	}
+	return buffer.Bytes()[:size]
+}
+
+func generateCSV(rng *rand.Rand, size int) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString("id;offset;size;entropy\n")
+	for row := 1; buffer.Len() < size; row++ {
+		fmt.Fprintf(&buffer, "%d;%d;%d;%.4f\n", row, rng.IntN(1<<30), rng.IntN(1<<20), rng.Float64()*8)
+	}
+	return buffer.Bytes()[:size]
+}
+
+// generateGzip compresses generated text, so the stored file is compressed but not encrypted
+func generateGzip(rng *rand.Rand, size int) []byte {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	for buffer.Len() < size {
+		_, _ = writer.Write(generateText(rng, 65536))
+		_ = writer.Flush()
+	}
+	_ = writer.Close()
+	return buffer.Bytes()
+}
+
+// generateBitmap produces an uncompressed 24-bit BMP with a noisy gradient
+func generateBitmap(rng *rand.Rand, size int) []byte {
+	width := 256
+	height := max(1, (size-54)/(width*3))
+	pixels := make([]byte, width*height*3)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			offset := (y*width + x) * 3
+			pixels[offset] = byte(x + rng.IntN(8))
+			pixels[offset+1] = byte(y + rng.IntN(8))
+			pixels[offset+2] = byte((x + y) / 2)
+		}
+	}
+
+	header := make([]byte, 54)
+	copy(header, "BM")
+	binary.LittleEndian.PutUint32(header[2:], uint32(len(header)+len(pixels)))
+	binary.LittleEndian.PutUint32(header[10:], 54)
+	binary.LittleEndian.PutUint32(header[14:], 40)
+	binary.LittleEndian.PutUint32(header[18:], uint32(width))
+	binary.LittleEndian.PutUint32(header[22:], uint32(height))
+	binary.LittleEndian.PutUint16(header[26:], 1)
+	binary.LittleEndian.PutUint16(header[28:], 24)
+	return append(header, pixels...)
+}
+
+// generateFileMix fills the given capacity with files of the mix
+func generateFileMix(rng *rand.Rand, mix string, capacity int) []SyntheticFile {
+	var files []SyntheticFile
+	var total int
+	kinds := syntheticMixes[mix]
+	for total < capacity && len(files) < fatRootEntries-2 {
+		var kindName string
+		choice := rng.Float64()
+		// Sorted so that the same seed always gives the same files
+		for _, name := range slices.Sorted(maps.Keys(kinds)) {
+			kindName = name
+			if choice < kinds[name] {
+				break
+			}
+			choice -= kinds[name]
+		}
+
+		kind := syntheticFileKinds[kindName]
+		size := min(rng.IntN(512*1024)+4096, capacity-total)
+		content := kind.generate(rng, size)
+		files = append(files, SyntheticFile{Name: fmt.Sprintf("FILE%04d.%s", len(files)+1, kind.extension), Content: content})
+		total += len(content)
+	}
+	return files
+}
+
+func randomKey(rng *rand.Rand, size int) []byte {
+	key := make([]byte, size)
+	for idx := range key {
+		key[idx] = byte(rng.Uint32())
+	}
+	return key
+}
+
+// EncryptSectorsXTS encrypts a whole image in place the way dm-crypt does with aes-xts-plain64:
+// every sector is encrypted with its number as the tweak
+func EncryptSectorsXTS(fileName string, key []byte, sectorSize int) error {
+	cipher, err := xts.NewCipher(aes.NewCipher, key)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(fileName, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	buffer := make([]byte, 256*sectorSize)
+	var offset int64
+	for {
+		bytesRead, err := file.ReadAt(buffer, offset)
+		if bytesRead == 0 {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		for sector := 0; sector+sectorSize <= bytesRead; sector += sectorSize {
+			block := buffer[sector : sector+sectorSize]
+			cipher.Encrypt(block, block, uint64((offset+int64(sector))/int64(sectorSize)))
+		}
+		if _, err := file.WriteAt(buffer[:bytesRead], offset); err != nil {
+			return err
+		}
+		offset += int64(bytesRead)
+	}
+	return file.Sync()
+}
+
+// encryptFileContents encrypts one file the way fscrypt does: a per-file key derived from the master
+// key and a nonce, AES-XTS over 4096-byte data units with the unit index as the tweak.
+// The content is padded to the cipher block size.
+func encryptFileContents(masterKey []byte, nonce []byte, content []byte) ([]byte, error) {
+	fileKey := sha512.Sum512(append(append([]byte{}, masterKey...), nonce...))
+	cipher, err := xts.NewCipher(aes.NewCipher, fileKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	padded := make([]byte, (len(content)+aes.BlockSize-1)/aes.BlockSize*aes.BlockSize)
+	copy(padded, content)
+	for unit := 0; unit*fbeDataUnitSize < len(padded); unit++ {
+		block := padded[unit*fbeDataUnitSize : min(len(padded), (unit+1)*fbeDataUnitSize)]
+		cipher.Encrypt(block, block, uint64(unit))
+	}
+	return padded, nil
+}
+
+// encryptFiles returns the files encrypted one by one with names replaced by random-looking ones
+func encryptFiles(rng *rand.Rand, files []SyntheticFile) ([]SyntheticFile, error) {
+	masterKey := randomKey(rng, 64)
+	encrypted := make([]SyntheticFile, len(files))
+	for idx, file := range files {
+		nonce := randomKey(rng, 16)
+		content, err := encryptFileContents(masterKey, nonce, file.Content)
+		if err != nil {
+			return nil, err
+		}
+		encrypted[idx] = SyntheticFile{Name: fmt.Sprintf("%08X.ENC", rng.Uint32()), Content: content}
+	}
+	return encrypted, nil
+}
+
+// writeExt4Image populates an ext4 file system from a temporary directory with mkfs.ext4 -d
+func writeExt4Image(fileName string, size int64, files []SyntheticFile) error {
+	sourceDir, err := os.MkdirTemp("", "synthetic-ext4-")
+	if err != nil {
+		return err
+	}
+	defer func(path string) {
+		_ = os.RemoveAll(path)
+	}(sourceDir)
+
+	for _, file := range files {
+		if err := os.WriteFile(filepath.Join(sourceDir, strings.ToLower(file.Name)), file.Content, 0644); err != nil {
+			return err
+		}
+	}
+
+	image, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	truncateErr := image.Truncate(size)
+	closeErr := image.Close()
+	if truncateErr != nil {
+		return truncateErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	output, err := exec.Command("mkfs.ext4", "-q", "-F", "-d", sourceDir, fileName).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mkfs.ext4: %v: %s", err, output)
+	}
+	return nil
+}
+
+func writeFileSystemImage(fileSystem string, fileName string, size int64, files []SyntheticFile, rng *rand.Rand) error {
+	switch fileSystem {
+	case "fat":
+		return WriteFAT16Image(fileName, size, files, rng.Uint32())
+	case "ext4":
+		return writeExt4Image(fileName, size, files)
+	default:
+		return fmt.Errorf("невідома файлова система %s", fileSystem)
+	}
+}
+
+// GenerateSyntheticImages writes plaintext, per-file encrypted and fully encrypted images
+// for every file system and mix, returning the manifest entries describing them
+func GenerateSyntheticImages(outputDir string, fileSystems []string, mixes []string, count int, size int64, seed uint64) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+	rng := rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))
+
+	for _, fileSystem := range fileSystems {
+		for _, mix := range mixes {
+			if _, ok := syntheticMixes[mix]; !ok {
+				return entries, fmt.Errorf("невідомий набір файлів %s", mix)
+			}
+			for idx := 0; idx < count; idx++ {
+				files := generateFileMix(rng, mix, int(float64(size)*syntheticFillRatio))
+				baseName := fmt.Sprintf("%s_%s_%02d", fileSystem, mix, idx)
+
+				plainName := filepath.Join(outputDir, "none_"+baseName+".img")
+				if err := writeFileSystemImage(fileSystem, plainName, size, files, rng); err != nil {
+					return entries, err
+				}
+				entries = append(entries, ManifestEntry{Path: filepath.Base(plainName), Label: "none", FileSystem: fileSystem, Mix: mix})
+
+				encryptedFiles, err := encryptFiles(rng, files)
+				if err != nil {
+					return entries, err
+				}
+				fbeName := filepath.Join(outputDir, "fbe_"+baseName+".img")
+				if err := writeFileSystemImage(fileSystem, fbeName, size, encryptedFiles, rng); err != nil {
+					return entries, err
+				}
+				entries = append(entries, ManifestEntry{Path: filepath.Base(fbeName), Label: "fbe", FileSystem: fileSystem, Mix: mix})
+
+				fdeName := filepath.Join(outputDir, "fde_"+baseName+".img")
+				if err := writeFileSystemImage(fileSystem, fdeName, size, files, rng); err != nil {
+					return entries, err
+				}
+				if err := EncryptSectorsXTS(fdeName, randomKey(rng, 64), fdeSectorSize); err != nil {
+					return entries, err
+				}
+				entries = append(entries, ManifestEntry{Path: filepath.Base(fdeName), Label: "fde", FileSystem: fileSystem, Mix: mix})
+
+				fmt.Printf("%s: готово\n", baseName)
+			}
+		}
+	}
+	return entries, nil
+}
+
+func runGenerateCommand(args []string) int {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	outputDir := flags.String("o", "synthetic", "каталог для згенерованих образів")
+	fileSystemsFlag := flags.String("fs", "fat,ext4", "файлові системи через кому (fat, ext4)")
+	mixesFlag := flags.String("mix", "documents,archives,media", "набори файлів через кому (documents, archives, media)")
+	count := flags.Int("count", 2, "кількість образів кожного виду")
+	sizeMiB := flags.Int("size", 32, "розмір образу, МіБ")
+	seed := flags.Uint64("seed", 1, "зерно генератора для відтворюваності")
+	_ = flags.Parse(args)
+
+	fileSystems := strings.Split(*fileSystemsFlag, ",")
+	if _, err := exec.LookPath("mkfs.ext4"); err != nil {
+		var withoutExt4 []string
+		for _, fileSystem := range fileSystems {
+			if fileSystem != "ext4" {
+				withoutExt4 = append(withoutExt4, fileSystem)
+			}
+		}
+		if len(withoutExt4) != len(fileSystems) {
+			fmt.Println("Інструмент mkfs.ext4 не знайдено в PATH, образи ext4 пропущено")
+		}
+		fileSystems = withoutExt4
+	}
+
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	entries, err := GenerateSyntheticImages(*outputDir, fileSystems, strings.Split(*mixesFlag, ","), *count, int64(*sizeMiB)*1048576, *seed)
+	if err != nil {
+		fmt.Printf("Помилка генерації образів: %s\n", err)
+		return 1
+	}
+
+	manifest, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	manifestFile := filepath.Join(*outputDir, "manifest.json")
+	if err := os.WriteFile(manifestFile, manifest, 0644); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Printf("Згенеровано %d образів, маніфест: %s\n", len(entries), manifestFile)
+	return 0
+}